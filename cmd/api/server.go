@@ -3,105 +3,374 @@ package main
 // first run (go mod init restapi)
 
 import (
+	"context"
 	"crypto/tls"
-	"embed"
-	"fmt"
+	"errors"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"restapi/internal/api/handlers"
 	mw "restapi/internal/api/middlewares"
 	"restapi/internal/api/router"
+	"restapi/internal/config"
+	"restapi/internal/logging"
+	"restapi/internal/mailer"
+	"restapi/internal/mailqueue"
+	"restapi/internal/repository/sqlconnect"
+	"restapi/internal/tlsconfig"
+	"restapi/internal/tracing"
 	"restapi/pkg/utils"
+	"sync"
+	"syscall"
 	"time"
-
-	"github.com/joho/godotenv"
 )
 
-//go:embed .env
-var envFile embed.FS
+func main() {
+	// defaults < config file < environment < flags
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatalln(err)
+	}
+	logger, err := logging.New(logging.Options{Level: cfg.Log.Level, Format: cfg.Log.Format})
+	if err != nil {
+		log.Fatalln("Error setting up the logger", err)
+	}
+	slog.SetDefault(logger)
+	sqlconnect.SetLogger(logger)
+	sqlconnect.Configure(sqlconnect.Options{
+		User: cfg.DB.User,
+		Password: cfg.DB.Password,
+		Name: cfg.DB.Name,
+		Host: cfg.DB.Host,
+		Port: cfg.DB.Port,
+		MaxOpenConns: cfg.DB.MaxOpenConns,
+		MaxIdleConns: cfg.DB.MaxIdleConns,
+		ConnMaxLifetime: cfg.DB.ConnMaxLifetime,
+		ReadTimeout: cfg.DB.ReadTimeout,
+		WriteTimeout: cfg.DB.WriteTimeout,
+		PublicBaseURL: cfg.Server.PublicBaseURL,
+		ResetPasswordPath: cfg.Tokens.ResetPasswordPath,
+		EmailVerificationPath: cfg.Tokens.EmailVerificationPath,
+		ResetExpiry: cfg.Tokens.ResetExpiry,
+		InviteExpiry: cfg.Tokens.InviteExpiry,
+		EmailVerificationExpiry: cfg.Tokens.EmailVerificationExpiry,
+	})
+	handlers.SetJWTOptions(handlers.JWTOptions{
+		Secret: cfg.JWT.Secret,
+		ExpiresIn: cfg.JWT.ExpiresIn,
+	})
 
-func loadEnvFromEmbeddedFile() {
-	// read the embedded.env file
-	content, err := envFile.ReadFile(".env")
+	shutdownTracing, err := tracing.Setup(tracing.Options{
+		Exporter: cfg.Tracing.Exporter,
+		File: cfg.Tracing.File,
+		OTLPEndpoint: cfg.Tracing.OTLPEndpoint,
+		OTLPHeaders: tracing.ParseHeaders(cfg.Tracing.OTLPHeaders),
+		ServiceName: cfg.Tracing.ServiceName,
+		SampleRatio: cfg.Tracing.SampleRatio,
+	})
 	if err != nil {
-		log.Fatalf("Error reading .env file: %v", err)
-	} 
+		log.Fatalln("Error setting up tracing", err)
+	}
+
+	// the key pair is read through GetCertificate so rotating cert.pem and
+	// key.pem doesn't need a restart
+	var certs *tlsconfig.CertReloader
+	var tlsConfig *tls.Config
+	if cfg.TLS.Enabled {
+		certs, err = tlsconfig.NewCertReloader(cfg.Server.CertFile, cfg.Server.KeyFile, cfg.TLS.ReloadInterval, logger)
+		if err != nil {
+			log.Fatalln("Error loading the TLS key pair", err)
+		}
+		tlsConfig, err = tlsconfig.ServerConfig(tlsconfig.Options{
+			MinVersion: cfg.TLS.MinVersion,
+			MaxVersion: cfg.TLS.MaxVersion,
+			CipherSuites: cfg.TLS.CipherSuites,
+			ClientAuth: cfg.TLS.ClientAuth,
+			ClientCAFile: cfg.TLS.ClientCAFile,
+		}, certs)
+		if err != nil {
+			log.Fatalln("Error setting up TLS", err)
+		}
+		certs.Start()
+	}
 
-	// create a temp file to load the env vars
-	tempFile, err := os.CreateTemp("", ".env")
+	// limits are per instance unless the buckets live in a shared store
+	var rateLimitStore mw.RateLimitStore
+	if cfg.RateLimit.Store == "redis" {
+		rateLimitStore = mw.NewRedisRateLimitStore(mw.RedisRateLimitOptions{
+			Addr: cfg.RateLimit.RedisAddr,
+			Password: cfg.RateLimit.RedisPassword,
+			DB: cfg.RateLimit.RedisDB,
+		})
+	}
+
+	rateLimitPolicy := func(policy config.RateLimitPolicy) mw.RateLimitPolicy {
+		return mw.RateLimitPolicy{Name: policy.Name, Limit: policy.Limit, Window: policy.Window, Burst: policy.Burst, KeyBy: policy.KeyBy}
+	}
+	var rateLimitRoutes []mw.RouteRateLimit
+	for _, route := range cfg.RateLimit.Routes {
+		rateLimitRoutes = append(rateLimitRoutes, mw.RouteRateLimit{
+			Prefix: route.Prefix,
+			Methods: route.Methods,
+			Policy: rateLimitPolicy(route.Policy),
+		})
+	}
+	rl := mw.NewRateLimiter(mw.RateLimitOptions{
+		Default: rateLimitPolicy(cfg.RateLimit.Default),
+		Routes: rateLimitRoutes,
+		IdleTimeout: cfg.RateLimit.IdleTimeout,
+		Store: rateLimitStore,
+	})
+
+	resetThrottle := handlers.NewEmailThrottle(cfg.Tokens.ResetRequestsPerHour, time.Hour)
+	handlers.SetResetThrottle(resetThrottle)
+
+	m, err := mailer.Configure(mailer.Options{
+		Backend: cfg.Mail.Backend,
+		From: cfg.Mail.From,
+		Dir: cfg.Mail.Dir,
+		DefaultLocale: cfg.Mail.DefaultLocale,
+		SMTPHost: cfg.Mail.SMTPHost,
+		SMTPPort: cfg.Mail.SMTPPort,
+		SMTPUsername: cfg.Mail.SMTPUsername,
+		SMTPPassword: cfg.Mail.SMTPPassword,
+		SMTPTLS: cfg.Mail.SMTPTLS,
+	})
 	if err != nil {
-		log.Fatalf("Error creating temp .env file: %v", err)
+		log.Fatalln("Error setting up the mailer", err)
 	}
-	defer os.Remove(tempFile.Name())
+	handlers.SetReadinessMailer(m)
+	mailWorker := mailqueue.NewWorker(m, cfg.Mail.QueueInterval, cfg.Mail.MaxAttempts, logger)
+	mailWorker.Start()
 
-	// Write content of embedded .env file to the time file
-	_, err = tempFile.Write(content)
+	hpp, err := mw.Hpp(mw.HPPOptions{
+		CheckQuery: true,
+		CheckBody: true,
+		Strict: cfg.HPP.Strict,
+		DefaultPolicy: cfg.HPP.DefaultPolicy,
+		Routes: router.ParamSchemas(),
+	})
 	if err != nil {
-		log.Fatalf("Error writing .env file: %v", err)
-	} 
+		log.Fatalln("Error setting up parameter pollution checks", err)
+	}
 
-	err = tempFile.Close()
+	realIP, err := mw.RealIP(mw.RealIPOptions{
+		TrustedProxies: cfg.Server.TrustedProxies,
+		Header: cfg.Server.TrustedProxyHeader,
+	})
 	if err != nil {
-		log.Fatalf("Error closing .env file: %v", err)
-	} 
+		log.Fatalln("Error reading TRUSTED_PROXIES", err)
+	}
 
-	// Load the env vars from the temp file
-	err = godotenv.Load(tempFile.Name())
+	metricsAccess, err := mw.MetricsAccess(mw.MetricsAccessOptions{
+		Token: cfg.Metrics.Token,
+		AllowedNetworks: cfg.Metrics.AllowedNetworks,
+	})
 	if err != nil {
-		log.Fatalf("Error loading .env file: %v", err)
+		log.Fatalln("Error reading METRICS_ALLOWED_NETWORKS", err)
 	}
-}
 
+	corsOptions := mw.CorsOptions{
+		AllowedOrigins: cfg.CORS.AllowedOrigins,
+		AllowedMethods: cfg.CORS.AllowedMethods,
+		AllowedHeaders: cfg.CORS.AllowedHeaders,
+		ExposedHeaders: cfg.CORS.ExposedHeaders,
+		AllowCredentials: cfg.CORS.AllowCredentials,
+		MaxAge: cfg.CORS.MaxAge,
+	}
 
-func main() {
-	// Only in production, for running source code
-	// err := godotenv.Load()
-	// if err != nil {
-	// 	return
-	// }
-	
-	// Load env vars from the embedded .env file
-	loadEnvFromEmbeddedFile()
+	var headerRoutes []mw.RouteSecurityHeaders
+	for _, route := range cfg.Headers.Routes {
+		headerRoutes = append(headerRoutes, mw.RouteSecurityHeaders{Prefix: route.Prefix, Headers: route.Headers})
+	}
+	securityHeadersOptions := mw.SecurityHeadersOptions{
+		ContentSecurityPolicy: cfg.Headers.ContentSecurityPolicy,
+		CSPReportOnly: cfg.Headers.CSPReportOnly,
+		CSPReportURI: cfg.Headers.CSPReportURI,
+		HSTSMaxAge: cfg.Headers.HSTSMaxAge,
+		HSTSIncludeSubdomains: cfg.Headers.HSTSIncludeSubdomains,
+		HSTSPreload: cfg.Headers.HSTSPreload,
+		FrameOptions: cfg.Headers.FrameOptions,
+		ReferrerPolicy: cfg.Headers.ReferrerPolicy,
+		CacheControl: cfg.Headers.CacheControl,
+		PermissionsPolicy: cfg.Headers.PermissionsPolicy,
+		CrossOriginResourcePolicy: cfg.Headers.CrossOriginResourcePolicy,
+		CrossOriginOpenerPolicy: cfg.Headers.CrossOriginOpenerPolicy,
+		CrossOriginEmbedderPolicy: cfg.Headers.CrossOriginEmbedderPolicy,
+		Routes: headerRoutes,
+	}
+
+	var sanitizeRoutes []mw.RouteSanitizePolicy
+	for _, route := range cfg.Sanitize.Routes {
+		sanitizeRoutes = append(sanitizeRoutes, mw.RouteSanitizePolicy{
+			Prefix: route.Prefix,
+			Methods: route.Methods,
+			DefaultPolicy: route.DefaultPolicy,
+			Fields: route.Fields,
+			MaxBodyBytes: int64(route.MaxBodyBytes),
+			AllowedContentTypes: route.AllowedContentTypes,
+		})
+	}
+	sanitize, err := mw.Sanitize(mw.SanitizeOptions{
+		DefaultPolicy: cfg.Sanitize.DefaultPolicy,
+		Fields: cfg.Sanitize.Fields,
+		MaxBodyBytes: int64(cfg.Sanitize.MaxBodyBytes),
+		AllowedContentTypes: cfg.Sanitize.AllowedContentTypes,
+		Routes: sanitizeRoutes,
+	})
+	if err != nil {
+		log.Fatalln("Error setting up input sanitization", err)
+	}
 
-	fmt.Println("Env Var CERT_FILE:", os.Getenv("CERT_FILE"))
+	// grading and SIS-sync jobs authenticate with client certificates
+	authOptions := mw.AuthOptions{
+		JWTSecret: cfg.JWT.Secret,
+		RateLimiter: rl,
+		APIKeyFailures: rateLimitPolicy(cfg.RateLimit.APIKeyFailures),
+	}
+	for _, account := range cfg.TLS.ServiceAccounts {
+		authOptions.ServiceAccounts = append(authOptions.ServiceAccounts, mw.ServiceAccount{
+			Name: account.Name,
+			Role: account.Role,
+			CommonNames: account.CommonNames,
+			DNSNames: account.DNSNames,
+			URIs: account.URIs,
+			Emails: account.Emails,
+		})
+	}
 
-	port := os.Getenv("API_PORT")
+	// secureMux := mw.Cors(rl.Middleware(mw.ResponsetimeMiddleware(mw.SecurityHeaders(mw.Compression(hpp(mux))))))
+	router := router.MainRouter()
+	// probes and scrapes come from the orchestrator and the monitoring
+	// stack, not from browsers or users; scrapes are restricted to the
+	// metrics networks and token instead
+	probePaths := []string{"/healthz", "/readyz", "/version", "/metrics"}
+	// browsers send CSP reports without credentials, and the reports quote
+	// the blocked markup the sanitizer would mangle
+	jwtMiddleware := mw.MiddlewaresExcludePaths(mw.Authenticate(authOptions), append([]string{"/execs/login", "/execs/forgotpassword", "/execs/resetpassword/reset", "/execs/verifyemail", "/csp-reports"}, probePaths...)...)
+	xssMiddleware := mw.MiddlewaresExcludePaths(sanitize, "/csp-reports")
+	rateLimitMiddleware := mw.MiddlewaresExcludePaths(rl.Middleware, probePaths...)
+	corsMiddleware := mw.MiddlewaresExcludePaths(mw.Cors(corsOptions), probePaths...)
+	secureMux := utils.ApplyMiddlewares(router,
+		mw.RecordRoute,
+		mw.TraceHandler,
+		mw.Traced("security_headers", mw.SecurityHeaders(securityHeadersOptions)),
+		mw.Traced("compression", mw.Compression(mw.DefaultCompressionOptions)),
+		mw.Traced("hpp", hpp),
+		mw.Traced("xss", xssMiddleware),
+		mw.Traced("rate_limit", rateLimitMiddleware),
+		mw.Traced("jwt", jwtMiddleware),
+		mw.Traced("cors", corsMiddleware),
+		mw.Traced("metrics_access", mw.MiddlewaresOnlyPaths(metricsAccess, "/metrics")),
+		mw.Traced("access_log", mw.AccessLog(logger)),
+		mw.Traced("request_id", mw.RequestID),
+		mw.Traced("real_ip", realIP),
+		mw.Tracing,
+	)
 
-	// cert := "cert.pem"
-	// key := "key.pem"
-	cert := os.Getenv("CERT_FILE")
-	key := os.Getenv("KEY_FILE")
 
-	tlsConfig := &tls.Config{
-		MinVersion: tls.VersionTLS12,
+	// create custom servers, HTTPS and/or plain HTTP behind a proxy
+	newServer := func(addr string) *http.Server {
+		return &http.Server{
+			Addr: addr,
+			Handler: secureMux,
+			ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+			ReadTimeout: cfg.Server.ReadTimeout,
+			WriteTimeout: cfg.Server.WriteTimeout,
+			IdleTimeout: cfg.Server.IdleTimeout,
+			ErrorLog: slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
+		}
 	}
+	shutdownTimeout := cfg.Server.ShutdownTimeout
 
-	rl := mw.NewRateLimiter(5, time.Minute)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	hppOptions := mw.HPPOptions{
-		CheckQuery: true,
-		CheckBody: true,
-		CheckBodyOnlyForContentType: "application/x-www-form-encoded",
-		WhiteList: []string{"sortBy", "sortOrder", "name", "age", "class"},
+	var servers []*http.Server
+	serverErr := make(chan error, 2)
+	if cfg.TLS.Enabled {
+		server := newServer(cfg.Server.Port)
+		server.TLSConfig = tlsConfig
+		servers = append(servers, server)
+		go func() {
+			logger.Info("server is running", "port", server.Addr, "tls", true)
+			// the certificate comes from tlsConfig.GetCertificate
+			serverErr <- server.ListenAndServeTLS("", "")
+		}()
+	}
+	if cfg.Server.HTTPPort != "" {
+		server := newServer(cfg.Server.HTTPPort)
+		servers = append(servers, server)
+		go func() {
+			logger.Info("server is running", "port", server.Addr, "tls", false)
+			serverErr <- server.ListenAndServe()
+		}()
 	}
 
-	// secureMux := mw.Cors(rl.Middleware(mw.ResponsetimeMiddleware(mw.SecurityHeaders(mw.Compression(mw.Hpp(hppOptions)(mux))))))
-	router := router.MainRouter()
-	jwtMiddleware := mw.MiddlewaresExcludePaths(mw.JWTMiddleware, "/execs/login", "/execs/forgotpassword", "/execs/resetpassword/reset")
-	secureMux := utils.ApplyMiddlewares(router, mw.SecurityHeaders, mw.Compression, mw.Hpp(hppOptions), mw.XSSMiddleware, jwtMiddleware, mw.ResponsetimeMiddleware, rl.Middleware, mw.Cors)
+	exitCode := 0
+	select {
+	case err = <-serverErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("Error starting the server", "err", err)
+			exitCode = 1
+		}
+	case <-ctx.Done():
+		// a second signal kills the process straight away
+		stop()
+		logger.Info("shutting down, draining requests in flight", "timeout", shutdownTimeout)
+	}
 
-	// secureMux := mw.XSSMiddleware(router)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	err = shutdownServers(shutdownCtx, servers)
+	cancel()
+	if err != nil {
+		logger.Error("requests still running at the drain deadline were cut off", "err", err)
+		exitCode = 1
+	}
 
-	// create custom server
-	server := &http.Server{
-		Addr: port,
-		Handler: secureMux,
-		TLSConfig: tlsConfig,
+	// the background workers go before the pool they write to
+	mailWorker.Stop()
+	if certs != nil {
+		certs.Stop()
+	}
+	resetThrottle.Stop()
+	err = rl.Stop()
+	if err != nil {
+		logger.Error("Error stopping the rate limiter", "err", err)
+	}
+	err = sqlconnect.Close()
+	if err != nil {
+		logger.Error("Error closing the database pool", "err", err)
 	}
 
-	fmt.Println("Server is running on port: ", port)
-	err := server.ListenAndServeTLS(cert, key)
+	// spans of the last requests are still buffered
+	flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	err = shutdownTracing(flushCtx)
+	cancel()
 	if err != nil {
-		log.Fatalln("Error starting the server", err)
+		logger.Error("Error flushing traces", "err", err)
 	}
-}
\ No newline at end of file
+	logger.Info("server stopped")
+	os.Exit(exitCode)
+}
+
+// shutdownServers drains the servers together, closing the connections of
+// any that miss the deadline
+func shutdownServers(ctx context.Context, servers []*http.Server) error {
+	errs := make([]error, len(servers))
+	var wg sync.WaitGroup
+	for i, server := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = server.Shutdown(ctx)
+			if errs[i] != nil {
+				server.Close()
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
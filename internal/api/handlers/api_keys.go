@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"restapi/internal/models"
+	"restapi/internal/repository/sqlconnect"
+	"restapi/pkg/utils"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// old keys keep working for a day after a rotation unless told otherwise
+const defaultRotationGracePeriod = 24 * time.Hour
+
+func GetAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	// admin
+	_, err := utils.AuthorizeUser(r.Context().Value(utils.ContextKey("role")).(string), "admin")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	keys, err := sqlconnect.GetAPIKeys(r.Context())
+	if err != nil {
+		WriteDBError(w, err, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := struct{
+		Status string `json:"status"`
+		Count int `json:"count"`
+		Data []models.APIKey `json:"data"`
+	}{
+		Status: "success",
+		Count: len(keys),
+		Data: keys,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func GetAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	// admin
+	_, err := utils.AuthorizeUser(r.Context().Value(utils.ContextKey("role")).(string), "admin")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid API key Id", http.StatusBadRequest)
+		return
+	}
+
+	key, err := sqlconnect.GetAPIKeyByID(r.Context(), id)
+	if err != nil {
+		WriteDBError(w, err, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(key)
+}
+
+func AddAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	// admin
+	_, err := utils.AuthorizeUser(r.Context().Value(utils.ContextKey("role")).(string), "admin")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	var req models.CreateAPIKeyRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+	err = validateScopes(req.Scopes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	key := models.APIKey{
+		Name: req.Name,
+		Scopes: req.Scopes,
+		CreatedBy: currentUsername(r),
+	}
+	if req.ExpiresAt != "" {
+		expiresAt, err := time.Parse(time.RFC3339, req.ExpiresAt)
+		if err != nil || !expiresAt.After(time.Now()) {
+			http.Error(w, "expires_at must be a future RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+		expires := expiresAt.Format(time.RFC3339)
+		key.ExpiresAt = &expires
+	}
+
+	key, err = sqlconnect.AddAPIKey(r.Context(), key)
+	if err != nil {
+		WriteDBError(w, err, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(key)
+}
+
+func RotateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	// admin
+	_, err := utils.AuthorizeUser(r.Context().Value(utils.ContextKey("role")).(string), "admin")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid API key Id", http.StatusBadRequest)
+		return
+	}
+
+	// the body is optional
+	var req models.RotateAPIKeyRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	gracePeriod := defaultRotationGracePeriod
+	if req.GracePeriod != "" {
+		gracePeriod, err = time.ParseDuration(req.GracePeriod)
+		if err != nil || gracePeriod < 0 {
+			http.Error(w, "grace_period must be a duration such as 1h", http.StatusBadRequest)
+			return
+		}
+	}
+
+	key, err := sqlconnect.RotateAPIKey(r.Context(), id, gracePeriod, currentUsername(r))
+	if err != nil {
+		WriteDBError(w, err, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(key)
+}
+
+func RevokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	// admin
+	_, err := utils.AuthorizeUser(r.Context().Value(utils.ContextKey("role")).(string), "admin")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid API key Id", http.StatusBadRequest)
+		return
+	}
+
+	err = sqlconnect.RevokeAPIKey(r.Context(), id)
+	if err != nil {
+		WriteDBError(w, err, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct{
+		Status string `json:"status"`
+		ID int `json:"id"`
+	}{
+		Status: "API key revoked",
+		ID: id,
+	})
+}
+
+func validateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return errors.New("at least one scope is required")
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(models.APIKeyScopes, scope) {
+			return errors.New("unknown scope " + strconv.Quote(scope) + ", expected one of " + strings.Join(models.APIKeyScopes, ", "))
+		}
+	}
+	return nil
+}
+
+func currentUsername(r *http.Request) string {
+	username, _ := r.Context().Value(utils.ContextKey("username")).(string)
+	return username
+}
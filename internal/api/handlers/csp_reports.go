@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"regexp"
+	"restapi/internal/logging"
+	"restapi/internal/metrics"
+)
+
+var cspViolationsTotal = metrics.Default.NewCounterVec("csp_violations_total",
+	"Content Security Policy violations reported by browsers, by directive.", "directive")
+
+// reports come from any browser, so only directive-like names become labels
+var directivePattern = regexp.MustCompile(`^[a-z-]{1,40}$`)
+
+// cspViolation holds the fields of both report formats we log
+type cspViolation struct {
+	DocumentURL string
+	Directive   string
+	BlockedURL  string
+	SourceFile  string
+	LineNumber  int
+	Disposition string
+}
+
+// CSPReportHandler collects the violation reports browsers send for the
+// report-uri (application/csp-report) and report-to
+// (application/reports+json) directives of our Content-Security-Policy
+func CSPReportHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 64<<10)
+	defer r.Body.Close()
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	var violations []cspViolation
+	switch mediaType {
+	case "application/csp-report":
+		var report struct {
+			Body struct {
+				DocumentURI        string `json:"document-uri"`
+				ViolatedDirective  string `json:"violated-directive"`
+				EffectiveDirective string `json:"effective-directive"`
+				BlockedURI         string `json:"blocked-uri"`
+				SourceFile         string `json:"source-file"`
+				LineNumber         int    `json:"line-number"`
+				Disposition        string `json:"disposition"`
+			} `json:"csp-report"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&report)
+		if err != nil {
+			http.Error(w, "Invalid report", http.StatusBadRequest)
+			return
+		}
+		directive := report.Body.EffectiveDirective
+		if directive == "" {
+			directive = report.Body.ViolatedDirective
+		}
+		violations = append(violations, cspViolation{
+			DocumentURL: report.Body.DocumentURI,
+			Directive:   directive,
+			BlockedURL:  report.Body.BlockedURI,
+			SourceFile:  report.Body.SourceFile,
+			LineNumber:  report.Body.LineNumber,
+			Disposition: report.Body.Disposition,
+		})
+	case "application/reports+json":
+		var reports []struct {
+			Type string `json:"type"`
+			Body struct {
+				DocumentURL        string `json:"documentURL"`
+				EffectiveDirective string `json:"effectiveDirective"`
+				BlockedURL         string `json:"blockedURL"`
+				SourceFile         string `json:"sourceFile"`
+				LineNumber         int    `json:"lineNumber"`
+				Disposition        string `json:"disposition"`
+			} `json:"body"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&reports)
+		if err != nil {
+			http.Error(w, "Invalid report", http.StatusBadRequest)
+			return
+		}
+		for _, report := range reports {
+			if report.Type != "csp-violation" {
+				continue
+			}
+			violations = append(violations, cspViolation{
+				DocumentURL: report.Body.DocumentURL,
+				Directive:   report.Body.EffectiveDirective,
+				BlockedURL:  report.Body.BlockedURL,
+				SourceFile:  report.Body.SourceFile,
+				LineNumber:  report.Body.LineNumber,
+				Disposition: report.Body.Disposition,
+			})
+		}
+	default:
+		http.Error(w, "Unsupported content-type.", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	logger := logging.FromContext(r.Context())
+	for _, violation := range violations {
+		label := violation.Directive
+		if !directivePattern.MatchString(label) {
+			label = "other"
+		}
+		cspViolationsTotal.Inc(label)
+		logger.Warn("content security policy violation",
+			"document_url", violation.DocumentURL,
+			"directive", violation.Directive,
+			"blocked_url", violation.BlockedURL,
+			"source_file", violation.SourceFile,
+			"line_number", violation.LineNumber,
+			"disposition", violation.Disposition,
+		)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"restapi/internal/metrics"
+	"strings"
+	"testing"
+)
+
+func TestCSPReportHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        int
+		counted     []string
+	}{
+		{
+			name:        "report-uri",
+			contentType: "application/csp-report",
+			body:        `{"csp-report":{"document-uri":"https://example.com/","violated-directive":"script-src-elem","effective-directive":"script-src","blocked-uri":"inline"}}`,
+			want:        http.StatusNoContent,
+			counted:     []string{`csp_violations_total{directive="script-src"} 1`},
+		},
+		{
+			name:        "report-uri without effective-directive",
+			contentType: "application/csp-report",
+			body:        `{"csp-report":{"violated-directive":"img-src"}}`,
+			want:        http.StatusNoContent,
+			counted:     []string{`csp_violations_total{directive="img-src"} 1`},
+		},
+		{
+			name:        "report-to",
+			contentType: "application/reports+json",
+			body: `[{"type":"csp-violation","body":{"documentURL":"https://example.com/","effectiveDirective":"style-src-elem"}},
+				{"type":"deprecation","body":{}},
+				{"type":"csp-violation","body":{"effectiveDirective":"style-src-elem"}}]`,
+			want:    http.StatusNoContent,
+			counted: []string{`csp_violations_total{directive="style-src-elem"} 2`},
+		},
+		{
+			name:        "unknown directive",
+			contentType: "application/csp-report; charset=utf-8",
+			body:        `{"csp-report":{"effective-directive":"Script-Src <script>"}}`,
+			want:        http.StatusNoContent,
+			counted:     []string{`csp_violations_total{directive="other"} 1`},
+		},
+		{
+			name:        "directive too long",
+			contentType: "application/csp-report",
+			body:        `{"csp-report":{"effective-directive":"` + strings.Repeat("a", 41) + `"}}`,
+			want:        http.StatusNoContent,
+			counted:     []string{`csp_violations_total{directive="other"} 1`},
+		},
+		{
+			name:        "invalid JSON",
+			contentType: "application/csp-report",
+			body:        `{"csp-report":`,
+			want:        http.StatusBadRequest,
+		},
+		{
+			name:        "report-to not an array",
+			contentType: "application/reports+json",
+			body:        `{"type":"csp-violation"}`,
+			want:        http.StatusBadRequest,
+		},
+		{
+			name:        "other content type",
+			contentType: "application/json",
+			body:        `{"csp-report":{}}`,
+			want:        http.StatusUnsupportedMediaType,
+		},
+	}
+
+	defer func(counter *metrics.CounterVec) { cspViolationsTotal = counter }(cspViolationsTotal)
+	for _, test := range tests {
+		registry := metrics.NewRegistry()
+		cspViolationsTotal = registry.NewCounterVec("csp_violations_total", "", "directive")
+
+		req := httptest.NewRequest(http.MethodPost, "/csp-reports", strings.NewReader(test.body))
+		req.Header.Set("Content-Type", test.contentType)
+		rec := httptest.NewRecorder()
+		CSPReportHandler(rec, req)
+		if rec.Code != test.want {
+			t.Errorf("%s: status = %d, want %d", test.name, rec.Code, test.want)
+		}
+
+		scrape := httptest.NewRecorder()
+		registry.Handler().ServeHTTP(scrape, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		var samples []string
+		for _, line := range strings.Split(scrape.Body.String(), "\n") {
+			if strings.HasPrefix(line, "csp_violations_total{") {
+				samples = append(samples, line)
+			}
+		}
+		if strings.Join(samples, "\n") != strings.Join(test.counted, "\n") {
+			t.Errorf("%s: counted %q, want %q", test.name, samples, test.counted)
+		}
+	}
+}
@@ -5,8 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
+	"restapi/internal/mailer"
+	"restapi/internal/logging"
 	"restapi/internal/models"
 	"restapi/internal/repository/sqlconnect"
 	"restapi/pkg/utils"
@@ -18,6 +19,7 @@ func GetExecsHandler(w http.ResponseWriter, r *http.Request) {
 	var execs []models.Exec
 	execs, err := sqlconnect.GetExecsDBHandler(execs, r)
 	if err != nil {
+		WriteDBError(w, err, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
@@ -42,13 +44,14 @@ func GetExecHandler(w http.ResponseWriter, r *http.Request) {
 	// Handle Path parameter
 	id, err := strconv.Atoi(idStr) 
 	if err != nil {
-		fmt.Println(err)
+		logging.FromContext(r.Context()).Warn("invalid exec id", "err", err)
 		return
 	}
 
-	exec, err := sqlconnect.GetExecByID(id)
+	exec, err := sqlconnect.GetExecByID(r.Context(), id)
 	if err != nil {
-		fmt.Println(err)
+		logging.FromContext(r.Context()).Warn("error retrieving exec", "err", err)
+		WriteDBError(w, err, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
@@ -79,6 +82,8 @@ func AddExecsHandler(w http.ResponseWriter, r *http.Request) {
 	for _, field := range fields {
 		allowedFields[field] = struct{}{}
 	}
+	// execs choose their own password through the invitation email
+	delete(allowedFields, "password")
 
 	for _, exec := range rawExecs {
 		for key := range exec {
@@ -97,16 +102,16 @@ func AddExecsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	for _, exec := range newExecs {
-		err := CheckBlankFields(exec)
+		err := CheckBlankFields(exec, "password")
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 	}
 
-	addedExecs, err := sqlconnect.AddExecsDBHandler(newExecs)
+	addedExecs, err := sqlconnect.AddExecsDBHandler(r.Context(), newExecs, mailer.LocaleFromHeader(r.Header.Get("Accept-Language")))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		WriteDBError(w, err, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -130,7 +135,7 @@ func PatchExecHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.Atoi(idStr)
 
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(r.Context()).Warn("invalid exec id", "err", err)
 		http.Error(w, "Invalid exec Id", http.StatusBadRequest)
 		return
 	}
@@ -138,14 +143,14 @@ func PatchExecHandler(w http.ResponseWriter, r *http.Request) {
 	var updates map[string]interface{}
 	err = json.NewDecoder(r.Body).Decode(&updates)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(r.Context()).Warn("invalid request payload", "err", err)
 		http.Error(w, "Invalid Request Payload", http.StatusBadRequest)
 		return
 	}
 
-	updatedExecFromDB, err := sqlconnect.PatchExec(id, updates)
+	updatedExecFromDB, err := sqlconnect.PatchExec(r.Context(), id, updates, mailer.LocaleFromHeader(r.Header.Get("Accept-Language")))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		WriteDBError(w, err, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -161,9 +166,9 @@ func PatchExecsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = sqlconnect.PatchExecs(updates)
+	err = sqlconnect.PatchExecs(r.Context(), updates, mailer.LocaleFromHeader(r.Header.Get("Accept-Language")))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		WriteDBError(w, err, err.Error(), http.StatusBadRequest)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
@@ -174,14 +179,14 @@ func DeleteExecHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.Atoi(idStr)
 
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(r.Context()).Warn("invalid exec id", "err", err)
 		http.Error(w, "Invalid Exec Id", http.StatusBadRequest)
 		return
 	}
 
-	err = sqlconnect.DeleteOneExec(id)
+	err = sqlconnect.DeleteOneExec(r.Context(), id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		WriteDBError(w, err, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -197,6 +202,51 @@ func DeleteExecHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+func DeactivateExecHandler(w http.ResponseWriter, r *http.Request) {
+	setExecInactiveStatus(w, r, true)
+}
+
+func ReactivateExecHandler(w http.ResponseWriter, r *http.Request) {
+	setExecInactiveStatus(w, r, false)
+}
+
+func setExecInactiveStatus(w http.ResponseWriter, r *http.Request, inactive bool) {
+	// admin
+	_, err := utils.AuthorizeUser(r.Context().Value(utils.ContextKey("role")).(string), "admin")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	idStr := r.PathValue("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "Invalid exec Id", http.StatusBadRequest)
+		return
+	}
+
+	err = sqlconnect.SetExecInactiveStatus(r.Context(), id, inactive)
+	if err != nil {
+		WriteDBError(w, err, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	status := "Exec Successfully reactivated"
+	if inactive {
+		status = "Exec Successfully deactivated"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	response := struct {
+		Status string `json:"status"`
+		ID int `json:"id"`
+	} {
+		Status: status,
+		ID: id,
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
 func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	var req models.Exec
 
@@ -212,9 +262,9 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := sqlconnect.GetUserByUsername(req.Username)
+	user, err := sqlconnect.GetUserByUsername(r.Context(), req.Username)
 	if err != nil {
-		http.Error(w, "invalid username or password", http.StatusBadRequest)
+		WriteDBError(w, err, "invalid username or password", http.StatusBadRequest)
 		return
 	}
 
@@ -229,7 +279,7 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tokenString, err := utils.SignToken(user.ID, req.Username, user.Role)
+	tokenString, err := signToken(user.ID, req.Username, user.Role)
 	if err != nil {
 		utils.ErrorHandler(errors.New("token could not be generated"), "token could not be generated")
 		http.Error(w, "token could not be generated", http.StatusForbidden)
@@ -300,9 +350,9 @@ func UpdatePasswordHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = sqlconnect.UpdatePasswordInDB(userId, req.CurrentPassword, req.NewPassword)
+	_, err = sqlconnect.UpdatePasswordInDB(r.Context(), userId, req.CurrentPassword, req.NewPassword)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		WriteDBError(w, err, err.Error(), http.StatusBadRequest)
 		return
 	}
 	
@@ -332,13 +382,28 @@ func ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = sqlconnect.ForgotPasswordDbHandler(req.Email)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+	// answer no sooner than forgotPasswordMinDuration, whether or not an
+	// email went out, so timing doesn't give away which addresses exist
+	deadline := time.Now().Add(forgotPasswordMinDuration)
+	defer func() {
+		time.Sleep(time.Until(deadline))
+	}()
+
+	if resetThrottle == nil || resetThrottle.Allow(req.Email) {
+		err = sqlconnect.ForgotPasswordDbHandler(r.Context(), req.Email, mailer.LocaleFromHeader(r.Header.Get("Accept-Language")))
+		if err != nil {
+			WriteDBError(w, err, "internal error", http.StatusInternalServerError)
+			return
+		}
 	}
 
-	fmt.Fprintf(w, "Password reset link sent to %s", req.Email)
+	w.Header().Set("Content-Type", "application/json")
+	response := struct {
+		Message string `json:"message"`
+	} {
+		Message: "If an account exists for that email, a password reset link has been sent to it",
+	}
+	json.NewEncoder(w).Encode(response)
 }
 
 func ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
@@ -362,11 +427,58 @@ func ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = sqlconnect.ResetPasswordDbHandler(token, req.NewPassword)
+	err = sqlconnect.ResetPasswordDbHandler(r.Context(), token, req.NewPassword)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		WriteDBError(w, err, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	fmt.Fprintln(w, "Password reset successfully")
+}
+
+// SendEmailVerificationHandler mails a verification link to the current
+// address of an exec, admins can ask for it on behalf of any exec
+func SendEmailVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	idStr := r.PathValue("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "Invalid exec id", http.StatusBadRequest)
+		return
+	}
+
+	userId, _ := r.Context().Value(utils.ContextKey("userId")).(float64)
+	if int(userId) != id {
+		role, _ := r.Context().Value(utils.ContextKey("role")).(string)
+		_, err = utils.AuthorizeUser(role, "admin")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+
+	err = sqlconnect.RequestEmailVerification(r.Context(), id, mailer.LocaleFromHeader(r.Header.Get("Accept-Language")))
+	if err != nil {
+		WriteDBError(w, err, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	response := struct {
+		Message string `json:"message"`
+	} {
+		Message: "A verification link has been sent to the email address on file",
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+func ConfirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("verificationcode")
+
+	err := sqlconnect.ConfirmEmailChangeDbHandler(r.Context(), token, mailer.LocaleFromHeader(r.Header.Get("Accept-Language")))
+	if err != nil {
+		WriteDBError(w, err, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	fmt.Fprintln(w, "Email address verified successfully")
 }
\ No newline at end of file
@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"restapi/internal/mailer"
+	"restapi/internal/repository/sqlconnect"
+	"restapi/migrations"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// buildTime is set at link time:
+// go build -ldflags "-X restapi/internal/api/handlers.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var buildTime string
+
+const (
+	readinessTimeout = 3 * time.Second
+	// readinessTTL is how long a readiness result is served again, probes
+	// and anybody else hitting /readyz don't reach the database more often
+	readinessTTL = 5 * time.Second
+)
+
+type readinessCheck struct {
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type readinessResult struct {
+	Status string                    `json:"status"`
+	Checks map[string]readinessCheck `json:"checks"`
+}
+
+// readinessMailer is the mailer /readyz checks, set at startup
+var readinessMailer mailer.Mailer
+
+// SetReadinessMailer installs the mailer reported on by /readyz
+func SetReadinessMailer(m mailer.Mailer) {
+	readinessMailer = m
+}
+
+// readinessProbe is one check of /readyz, an optional one is reported
+// without failing readiness
+type readinessProbe struct {
+	check    func(context.Context) error
+	optional bool
+}
+
+// readiness holds the last result, mu is held while checking so concurrent
+// probes wait for the one check in flight instead of starting their own
+var readiness struct {
+	mu        sync.Mutex
+	result    readinessResult
+	checkedAt time.Time
+}
+
+// HealthzHandler answers as long as the process is able to serve requests
+func HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(struct {
+		Status string `json:"status"`
+	}{
+		Status: "ok",
+	})
+}
+
+// ReadyzHandler reports whether the dependencies needed to serve traffic are
+// up, with 503 as soon as one of them is not. The mailer is checked and
+// reported too but doesn't fail readiness: the outbox holds emails until the
+// SMTP server is back.
+func ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	readiness.mu.Lock()
+	if time.Since(readiness.checkedAt) >= readinessTTL {
+		readiness.result = checkReadiness()
+		readiness.checkedAt = time.Now()
+	}
+	result := readiness.result
+	readiness.mu.Unlock()
+
+	code := http.StatusOK
+	if result.Status != "ok" {
+		code = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(result)
+}
+
+// checkReadiness runs the checks in parallel. The result is shared between
+// requests, so it doesn't depend on the context of the one that ran it.
+func checkReadiness() readinessResult {
+	ctx, cancel := context.WithTimeout(context.Background(), readinessTimeout)
+	defer cancel()
+
+	checks := map[string]readinessProbe{
+		"database":   {check: sqlconnect.PingDB},
+		"migrations": {check: checkMigrations},
+		"mailer":     {check: checkMailer, optional: true},
+	}
+
+	results := make(map[string]readinessCheck, len(checks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, probe := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			err := probe.check(ctx)
+			result := readinessCheck{
+				Status:    "ok",
+				LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				result.Status = "fail"
+				result.Error = err.Error()
+			}
+			mu.Lock()
+			results[name] = result
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	status := "ok"
+	for name, result := range results {
+		if result.Status != "ok" && !checks[name].optional {
+			status = "unavailable"
+		}
+	}
+	return readinessResult{Status: status, Checks: results}
+}
+
+// checkMigrations fails when a migration shipped with this build has not
+// been recorded in schema_migrations
+func checkMigrations(ctx context.Context) error {
+	expected, err := migrations.Versions()
+	if err != nil {
+		return err
+	}
+	applied, err := sqlconnect.GetAppliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+
+	var missing []string
+	for _, version := range expected {
+		if !slices.Contains(applied, version) {
+			missing = append(missing, version)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("pending migrations: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func checkMailer(ctx context.Context) error {
+	if readinessMailer == nil {
+		return errors.New("mailer is not configured")
+	}
+	return mailer.Ping(ctx, readinessMailer)
+}
+
+// VersionHandler reports what is running, from the build info the Go
+// toolchain embeds in the binary
+func VersionHandler(w http.ResponseWriter, r *http.Request) {
+	version := struct {
+		Version   string `json:"version,omitempty"`
+		Commit    string `json:"commit,omitempty"`
+		Modified  bool   `json:"modified"`
+		BuildTime string `json:"build_time,omitempty"`
+		GoVersion string `json:"go_version"`
+	}{
+		BuildTime: buildTime,
+	}
+
+	info, ok := debug.ReadBuildInfo()
+	if ok {
+		version.Version = info.Main.Version
+		version.GoVersion = info.GoVersion
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				version.Commit = setting.Value
+			case "vcs.modified":
+				version.Modified = setting.Value == "true"
+			case "vcs.time":
+				// the commit time is the best we have without the ldflag
+				if version.BuildTime == "" {
+					version.BuildTime = setting.Value
+				}
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(version)
+}
@@ -2,15 +2,23 @@ package handlers
 
 import (
 	"errors"
+	"net/http"
 	"reflect"
+	"restapi/internal/repository/sqlconnect"
 	"restapi/pkg/utils"
+	"slices"
 	"strings"
 )
 
-func CheckBlankFields(value interface{}) error {
+// CheckBlankFields errors on any empty string field, except the ones whose
+// json names are listed in skip
+func CheckBlankFields(value interface{}, skip ...string) error {
 	val := reflect.ValueOf(value)
 	for i := 0; i < val.NumField(); i++ {
 		field := val.Field(i)
+		if slices.Contains(skip, strings.TrimSuffix(val.Type().Field(i).Tag.Get("json"), ",omitempty")) {
+			continue
+		}
 		if field.Kind() == reflect.String && field.String() == "" {
 			return utils.ErrorHandler(errors.New("all fields are required"), "All fields are required")
 		}
@@ -28,4 +36,17 @@ func GetFieldNames(model interface{}) []string {
 		fields = append(fields, fieldToAdd)
 	}
 	return fields
-}
\ No newline at end of file
+}
+
+// WriteDBError answers a failed repository call, database timeouts and
+// outages override the status the handler would otherwise pick
+func WriteDBError(w http.ResponseWriter, err error, message string, status int) {
+	switch {
+	case errors.Is(err, sqlconnect.ErrDBTimeout):
+		http.Error(w, err.Error(), http.StatusGatewayTimeout)
+	case errors.Is(err, sqlconnect.ErrDBUnavailable):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	default:
+		http.Error(w, message, status)
+	}
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWTOptions sign the session tokens handed out at login, the jwt
+// middleware checks them with the same secret
+type JWTOptions struct {
+	Secret    string
+	ExpiresIn time.Duration
+}
+
+var jwtOptions = JWTOptions{ExpiresIn: 15 * time.Minute}
+
+// SetJWTOptions replaces the token settings, call it before serving
+func SetJWTOptions(options JWTOptions) {
+	jwtOptions = options
+}
+
+func signToken(userID int, username, role string) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"uid":  userID,
+		"user": username,
+		"role": role,
+		"iat":  jwt.NewNumericDate(now),
+		"exp":  jwt.NewNumericDate(now.Add(jwtOptions.ExpiresIn)),
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(jwtOptions.Secret))
+}
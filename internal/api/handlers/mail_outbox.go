@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"restapi/internal/models"
+	"restapi/internal/repository/sqlconnect"
+	"restapi/pkg/utils"
+	"strconv"
+)
+
+func GetOutboxEmailsHandler(w http.ResponseWriter, r *http.Request) {
+	// admin
+	_, err := utils.AuthorizeUser(r.Context().Value(utils.ContextKey("role")).(string), "admin")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", models.OutboxStatusPending, models.OutboxStatusSending, models.OutboxStatusSent, models.OutboxStatusDead:
+	default:
+		http.Error(w, "Invalid status", http.StatusBadRequest)
+		return
+	}
+
+	emails, err := sqlconnect.GetOutboxEmailsDBHandler(r.Context(), status)
+	if err != nil {
+		WriteDBError(w, err, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := struct{
+		Status string `json:"status"`
+		Count int `json:"count"`
+		Data []models.OutboxEmail `json:"data"`
+	}{
+		Status: "success",
+		Count: len(emails),
+		Data: emails,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func ResendOutboxEmailHandler(w http.ResponseWriter, r *http.Request) {
+	// admin
+	_, err := utils.AuthorizeUser(r.Context().Value(utils.ContextKey("role")).(string), "admin")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	idStr := r.PathValue("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "Invalid email Id", http.StatusBadRequest)
+		return
+	}
+
+	email, err := sqlconnect.ResendOutboxEmail(r.Context(), id)
+	if err != nil {
+		WriteDBError(w, err, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(email)
+}
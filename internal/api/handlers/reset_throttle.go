@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+const forgotPasswordMinDuration = 500 * time.Millisecond
+
+// resetThrottle caps how many reset emails a single address can trigger per
+// hour. Requests over the limit are dropped silently, the response stays the
+// same so it can't be used to tell whether the address has an account.
+var resetThrottle *EmailThrottle
+
+// SetResetThrottle installs the throttle of POST /execs/forgotpassword,
+// without one reset requests aren't throttled per address
+func SetResetThrottle(t *EmailThrottle) {
+	resetThrottle = t
+}
+
+// EmailThrottle allows limit requests per address in a sliding window
+type EmailThrottle struct {
+	mu       sync.Mutex
+	requests map[string][]time.Time
+	limit    int
+	window   time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewEmailThrottle starts a throttle that forgets quiet addresses every
+// window, Stop ends the sweeping
+func NewEmailThrottle(limit int, window time.Duration) *EmailThrottle {
+	t := &EmailThrottle{
+		requests: make(map[string][]time.Time),
+		limit:    limit,
+		window:   window,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go t.sweepLoop()
+	return t
+}
+
+func (t *EmailThrottle) Allow(email string) bool {
+	key := strings.ToLower(strings.TrimSpace(email))
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	recent := t.recent(t.requests[key], now)
+	if len(recent) >= t.limit {
+		t.requests[key] = recent
+		return false
+	}
+	t.requests[key] = append(recent, now)
+	return true
+}
+
+func (t *EmailThrottle) Stop() {
+	close(t.stop)
+	<-t.done
+}
+
+// recent drops the requests that fell out of the window
+func (t *EmailThrottle) recent(times []time.Time, now time.Time) []time.Time {
+	kept := times[:0]
+	for _, at := range times {
+		if now.Sub(at) < t.window {
+			kept = append(kept, at)
+		}
+	}
+	return kept
+}
+
+func (t *EmailThrottle) sweepLoop() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(t.window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+			t.sweep()
+		}
+	}
+}
+
+// sweep forgets the addresses that have gone quiet
+func (t *EmailThrottle) sweep() {
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key, times := range t.requests {
+		recent := t.recent(times, now)
+		if len(recent) == 0 {
+			delete(t.requests, key)
+		} else {
+			t.requests[key] = recent
+		}
+	}
+}
@@ -2,10 +2,9 @@ package handlers
 
 import (
 	"encoding/json"
-	"fmt"
 	"io"
-	"log"
 	"net/http"
+	"restapi/internal/logging"
 	"restapi/internal/models"
 	"restapi/internal/repository/sqlconnect"
 	"strconv"
@@ -17,6 +16,7 @@ func GetStudentsHandler(w http.ResponseWriter, r *http.Request) {
 
 	students, totalStudents, err := sqlconnect.GetStudentsDBHandler(students, r, limit, page)
 	if err != nil {
+		WriteDBError(w, err, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
@@ -59,13 +59,14 @@ func GetStudentHandler(w http.ResponseWriter, r *http.Request) {
 	// Handle Path parameter
 	id, err := strconv.Atoi(idStr) 
 	if err != nil {
-		fmt.Println(err)
+		logging.FromContext(r.Context()).Warn("invalid student id", "err", err)
 		return
 	}
 
-	student, err := sqlconnect.GetStudentByID(id)
+	student, err := sqlconnect.GetStudentByID(r.Context(), id)
 	if err != nil {
-		fmt.Println(err)
+		logging.FromContext(r.Context()).Warn("error retrieving student", "err", err)
+		WriteDBError(w, err, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
@@ -121,9 +122,9 @@ func AddStudentHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	addedStudents, err := sqlconnect.AddStudentsDBHandler(newStudents)
+	addedStudents, err := sqlconnect.AddStudentsDBHandler(r.Context(), newStudents)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		WriteDBError(w, err, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -147,7 +148,7 @@ func UpdateStudentHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.Atoi(idStr)
 
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(r.Context()).Warn("invalid student id", "err", err)
 		http.Error(w, "Invalid Student Id", http.StatusBadRequest)
 		return
 	}
@@ -155,14 +156,14 @@ func UpdateStudentHandler(w http.ResponseWriter, r *http.Request) {
 	var updatedStudent models.Student
 	err = json.NewDecoder(r.Body).Decode(&updatedStudent)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(r.Context()).Warn("invalid request payload", "err", err)
 		http.Error(w, "Invalid Request Payload", http.StatusBadRequest)
 		return
 	}
 
-	updatedStudentFromDB, err := sqlconnect.UpdateStudent(id, updatedStudent)
+	updatedStudentFromDB, err := sqlconnect.UpdateStudent(r.Context(), id, updatedStudent)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		WriteDBError(w, err, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -176,7 +177,7 @@ func PatchStudentHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.Atoi(idStr)
 
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(r.Context()).Warn("invalid student id", "err", err)
 		http.Error(w, "Invalid Student Id", http.StatusBadRequest)
 		return
 	}
@@ -184,14 +185,14 @@ func PatchStudentHandler(w http.ResponseWriter, r *http.Request) {
 	var updates map[string]interface{}
 	err = json.NewDecoder(r.Body).Decode(&updates)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(r.Context()).Warn("invalid request payload", "err", err)
 		http.Error(w, "Invalid Request Payload", http.StatusBadRequest)
 		return
 	}
 
-	updatedStudentFromDB, err := sqlconnect.PatchStudent(id, updates)
+	updatedStudentFromDB, err := sqlconnect.PatchStudent(r.Context(), id, updates)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		WriteDBError(w, err, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -207,9 +208,9 @@ func PatchStudentsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = sqlconnect.PatchStudents(updates)
+	err = sqlconnect.PatchStudents(r.Context(), updates)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		WriteDBError(w, err, err.Error(), http.StatusBadRequest)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
@@ -220,14 +221,14 @@ func DeleteStudentHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.Atoi(idStr)
 
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(r.Context()).Warn("invalid student id", "err", err)
 		http.Error(w, "Invalid Student Id", http.StatusBadRequest)
 		return
 	}
 
-	err = sqlconnect.DeleteOneStudent(id)
+	err = sqlconnect.DeleteOneStudent(r.Context(), id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		WriteDBError(w, err, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -247,14 +248,14 @@ func DeleteStudentsHandler(w http.ResponseWriter, r *http.Request) {
 	var ids []int
 	err := json.NewDecoder(r.Body).Decode(&ids)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(r.Context()).Warn("invalid request payload", "err", err)
 		http.Error(w, "Invalid request payload", http.StatusBadRequest)
 		return
 	}
 
-	deletedIds, err := sqlconnect.DeleteStudents(ids)
+	deletedIds, err := sqlconnect.DeleteStudents(r.Context(), ids)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		WriteDBError(w, err, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -2,10 +2,9 @@ package handlers
 
 import (
 	"encoding/json"
-	"fmt"
 	"io"
-	"log"
 	"net/http"
+	"restapi/internal/logging"
 	"restapi/internal/models"
 	"restapi/internal/repository/sqlconnect"
 	"restapi/pkg/utils"
@@ -16,6 +15,7 @@ func GetTeachersHandler(w http.ResponseWriter, r *http.Request) {
 	var teachers []models.Teacher
 	teachers, err := sqlconnect.GetTeachersDBHandler(teachers, r)
 	if err != nil {
+		WriteDBError(w, err, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
@@ -40,13 +40,14 @@ func GetTeacherHandler(w http.ResponseWriter, r *http.Request) {
 	// Handle Path parameter
 	id, err := strconv.Atoi(idStr) 
 	if err != nil {
-		fmt.Println(err)
+		logging.FromContext(r.Context()).Warn("invalid teacher id", "err", err)
 		return
 	}
 
-	teacher, err := sqlconnect.GetTeacherByID(id)
+	teacher, err := sqlconnect.GetTeacherByID(r.Context(), id)
 	if err != nil {
-		fmt.Println(err)
+		logging.FromContext(r.Context()).Warn("error retrieving teacher", "err", err)
+		WriteDBError(w, err, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
@@ -102,9 +103,9 @@ func AddTeacherHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	addedTeachers, err := sqlconnect.AddTeachersDBHandler(newTeachers)
+	addedTeachers, err := sqlconnect.AddTeachersDBHandler(r.Context(), newTeachers)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		WriteDBError(w, err, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -128,7 +129,7 @@ func UpdateTeacherHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.Atoi(idStr)
 
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(r.Context()).Warn("invalid teacher id", "err", err)
 		http.Error(w, "Invalid Teacher Id", http.StatusBadRequest)
 		return
 	}
@@ -136,14 +137,14 @@ func UpdateTeacherHandler(w http.ResponseWriter, r *http.Request) {
 	var updatedTeacher models.Teacher
 	err = json.NewDecoder(r.Body).Decode(&updatedTeacher)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(r.Context()).Warn("invalid request payload", "err", err)
 		http.Error(w, "Invalid Request Payload", http.StatusBadRequest)
 		return
 	}
 
-	updatedTeacherFromDB, err := sqlconnect.UpdateTeacher(id, updatedTeacher)
+	updatedTeacherFromDB, err := sqlconnect.UpdateTeacher(r.Context(), id, updatedTeacher)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		WriteDBError(w, err, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -157,7 +158,7 @@ func PatchTeacherHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.Atoi(idStr)
 
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(r.Context()).Warn("invalid teacher id", "err", err)
 		http.Error(w, "Invalid Teacher Id", http.StatusBadRequest)
 		return
 	}
@@ -165,14 +166,14 @@ func PatchTeacherHandler(w http.ResponseWriter, r *http.Request) {
 	var updates map[string]interface{}
 	err = json.NewDecoder(r.Body).Decode(&updates)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(r.Context()).Warn("invalid request payload", "err", err)
 		http.Error(w, "Invalid Request Payload", http.StatusBadRequest)
 		return
 	}
 
-	updatedTeacherFromDB, err := sqlconnect.PatchTeacher(id, updates)
+	updatedTeacherFromDB, err := sqlconnect.PatchTeacher(r.Context(), id, updates)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		WriteDBError(w, err, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -188,9 +189,9 @@ func PatchTeachersHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = sqlconnect.PatchTeachers(updates)
+	err = sqlconnect.PatchTeachers(r.Context(), updates)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		WriteDBError(w, err, err.Error(), http.StatusBadRequest)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
@@ -201,14 +202,14 @@ func DeleteTeacherHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.Atoi(idStr)
 
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(r.Context()).Warn("invalid teacher id", "err", err)
 		http.Error(w, "Invalid Teacher Id", http.StatusBadRequest)
 		return
 	}
 
-	err = sqlconnect.DeleteOneTeacher(id)
+	err = sqlconnect.DeleteOneTeacher(r.Context(), id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		WriteDBError(w, err, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -228,14 +229,14 @@ func DeleteTeachersHandler(w http.ResponseWriter, r *http.Request) {
 	var ids []int
 	err := json.NewDecoder(r.Body).Decode(&ids)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(r.Context()).Warn("invalid request payload", "err", err)
 		http.Error(w, "Invalid request payload", http.StatusBadRequest)
 		return
 	}
 
-	deletedIds, err := sqlconnect.DeleteTeachers(ids)
+	deletedIds, err := sqlconnect.DeleteTeachers(r.Context(), ids)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		WriteDBError(w, err, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -255,9 +256,9 @@ func GetStudentsByTeacherId (w http.ResponseWriter, r *http.Request) {
 
 	var students []models.Student
 
-	students, err := sqlconnect.GetStudentsByTeacherIdFomDB(teacherId, students)
+	students, err := sqlconnect.GetStudentsByTeacherIdFomDB(r.Context(), teacherId, students)
 	if err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		WriteDBError(w, err, "Invalid request payload", http.StatusBadRequest)
 		return
 	}
 
@@ -285,9 +286,9 @@ func GetStudentCountByTeacherId(w http.ResponseWriter, r *http.Request) {
 
 	var studentCount int
 
-	studentCount, err = sqlconnect.GetStudentCountByTeacherIdFromDB(teacherId)
+	studentCount, err = sqlconnect.GetStudentCountByTeacherIdFromDB(r.Context(), teacherId)
 	if err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		WriteDBError(w, err, "Invalid request payload", http.StatusBadRequest)
 		return
 	}
 
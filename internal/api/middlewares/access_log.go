@@ -0,0 +1,208 @@
+package middlewares
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"restapi/internal/logging"
+	"restapi/pkg/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// requestInfo is filled in by the layers below AccessLog, which only see
+// their own copies of the request
+type requestInfo struct {
+	pattern string
+	userID  any
+}
+
+func requestInfoFrom(r *http.Request) *requestInfo {
+	info, _ := r.Context().Value(utils.ContextKey("requestInfo")).(*requestInfo)
+	return info
+}
+
+// AccessLog writes one structured line per request and reports the time
+// spent in the handler chain through X-Response-Time and Server-Timing
+func AccessLog(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			requestID := logging.RequestID(r.Context())
+			requestLogger := logger
+			if requestID != "" {
+				requestLogger = requestLogger.With("request_id", requestID)
+			}
+			span := trace.SpanFromContext(r.Context())
+			traceID := ""
+			if span.SpanContext().IsValid() {
+				traceID = span.SpanContext().TraceID().String()
+				requestLogger = requestLogger.With("trace_id", traceID)
+			}
+
+			// handlers further down log through the same logger
+			info := &requestInfo{}
+			ctx := context.WithValue(r.Context(), utils.ContextKey("requestInfo"), info)
+			ctx = logging.WithLogger(ctx, requestLogger)
+
+			wrappedWriter := &responseWriter{ResponseWriter: w, status: http.StatusOK, start: start}
+			next.ServeHTTP(wrappedWriter, r.WithContext(ctx))
+
+			duration := time.Since(start)
+			route := metricsRoute(info.pattern)
+			method := metricsMethod(r.Method)
+			httpRequestsTotal.Inc(method, route, strconv.Itoa(wrappedWriter.status))
+			httpRequestDuration.Observe(duration.Seconds(), method, route)
+
+			// the server span started by Tracing only knew the method
+			if info.pattern != "" {
+				span.SetName(info.pattern)
+				span.SetAttributes(attribute.String("http.route", info.pattern))
+			}
+			span.SetAttributes(attribute.Int("http.response.status_code", wrappedWriter.status))
+			if wrappedWriter.status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(wrappedWriter.status))
+			}
+
+			logger.LogAttrs(r.Context(), slog.LevelInfo, "request",
+				slog.String("method", r.Method),
+				slog.String("path", loggedPath(r.URL.Path, info.pattern)),
+				slog.String("route", info.pattern),
+				slog.Int("status", wrappedWriter.status),
+				slog.Int64("bytes", wrappedWriter.bytes),
+				slog.Float64("duration_ms", float64(duration.Microseconds())/1000),
+				slog.String("client_ip", ClientIP(r)),
+				slog.String("user_id", formatUserID(info.userID)),
+				slog.String("request_id", requestID),
+				slog.String("trace_id", traceID),
+				slog.String("user_agent", r.UserAgent()),
+			)
+		})
+	}
+}
+
+// RecordRoute notes the ServeMux pattern that matched, for AccessLog. It has
+// to sit directly around the router, the mux sets Request.Pattern on the
+// request it was handed.
+func RecordRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+		if info := requestInfoFrom(r); info != nil {
+			info.pattern = r.Pattern
+		}
+	})
+}
+
+// loggedPath masks the segments of path that carry a secret, reset and
+// verification codes travel in the path. The wildcards of the matched
+// pattern name them; on an unmatched path every segment long enough to be a
+// token is masked.
+func loggedPath(path, pattern string) string {
+	segments := strings.Split(path, "/")
+
+	if pattern == "" {
+		for i, segment := range segments {
+			if len(segment) >= 32 {
+				segments[i] = "[REDACTED]"
+			}
+		}
+		return strings.Join(segments, "/")
+	}
+
+	// patterns read "[METHOD ][HOST]/PATH"
+	if _, rest, found := strings.Cut(pattern, " "); found {
+		pattern = rest
+	}
+	if i := strings.Index(pattern, "/"); i > 0 {
+		pattern = pattern[i:]
+	}
+
+	for i, wildcard := range strings.Split(pattern, "/") {
+		if i >= len(segments) {
+			break
+		}
+		name, ok := strings.CutPrefix(wildcard, "{")
+		if !ok {
+			continue
+		}
+		name = strings.TrimSuffix(name, "}")
+		name, rest := strings.CutSuffix(name, "...")
+		if !logging.IsSensitive(name) {
+			continue
+		}
+		if rest {
+			return strings.Join(append(segments[:i], "[REDACTED]"), "/")
+		}
+		segments[i] = "[REDACTED]"
+	}
+	return strings.Join(segments, "/")
+}
+
+func formatUserID(userID any) string {
+	switch id := userID.(type) {
+	case nil:
+		return ""
+	case float64:
+		return fmt.Sprintf("%.0f", id)
+	default:
+		return fmt.Sprint(id)
+	}
+}
+
+type responseWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+	start       time.Time
+}
+
+func (rw *responseWriter) WriteHeader(code int) {
+	if rw.wroteHeader {
+		return
+	}
+	if code >= http.StatusOK {
+		rw.wroteHeader = true
+		rw.status = code
+
+		// headers can't change once sent, so this is as late as the time
+		// can be measured
+		duration := time.Since(rw.start)
+		rw.Header().Set("X-Response-Time", duration.String())
+		rw.Header().Add("Server-Timing", fmt.Sprintf("app;dur=%.3f", float64(duration.Microseconds())/1000))
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += int64(n)
+	return n, err
+}
+
+func (rw *responseWriter) Flush() {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	http.NewResponseController(rw.ResponseWriter).Flush()
+}
+
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(rw.ResponseWriter).Hijack()
+}
+
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
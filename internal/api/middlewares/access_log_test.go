@@ -0,0 +1,22 @@
+package middlewares
+
+import "testing"
+
+func TestLoggedPathMasksSecrets(t *testing.T) {
+	token := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	tests := []struct {
+		path, pattern, want string
+	}{
+		{"/execs/resetpassword/reset/" + token, "POST /execs/resetpassword/reset/{resetcode}", "/execs/resetpassword/reset/[REDACTED]"},
+		{"/execs/verifyemail/" + token, "POST /execs/verifyemail/{verificationcode}", "/execs/verifyemail/[REDACTED]"},
+		{"/execs/12", "GET /execs/{id}", "/execs/12"},
+		{"/files/a/b/c", "GET /files/{token...}", "/files/[REDACTED]"},
+		{"/execs/resetpasswrd/" + token, "", "/execs/resetpasswrd/[REDACTED]"},
+		{"/students/unknown", "", "/students/unknown"},
+	}
+	for _, test := range tests {
+		if got := loggedPath(test.path, test.pattern); got != test.want {
+			t.Errorf("loggedPath(%q, %q) = %q, want %q", test.path, test.pattern, got, test.want)
+		}
+	}
+}
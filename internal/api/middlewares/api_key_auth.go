@@ -0,0 +1,170 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"restapi/internal/logging"
+	"restapi/internal/models"
+	"restapi/internal/repository/sqlconnect"
+	"restapi/pkg/utils"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiKeyRole is the role handlers see for API key requests, the scopes
+// decide which resources the key reaches before any handler runs
+const apiKeyRole = "exec"
+
+// apiKeyFailures counts failed API key attempts per client address in the
+// rate limiter's store; authentication runs before the rate limits, so
+// nothing else slows down a client guessing keys. A client out of attempts
+// is refused before its key is looked at, until the bucket has refilled.
+type apiKeyFailures struct {
+	limiter *rateLimiter
+	policy  RateLimitPolicy
+	// blocked maps client addresses to the time they may try again, it is
+	// per instance even with a shared store
+	mu      sync.Mutex
+	blocked map[string]time.Time
+}
+
+func newAPIKeyFailures(limiter *rateLimiter, policy RateLimitPolicy) *apiKeyFailures {
+	if limiter == nil || policy.Limit <= 0 || policy.Window <= 0 {
+		return nil
+	}
+	return &apiKeyFailures{limiter: limiter, policy: policy, blocked: make(map[string]time.Time)}
+}
+
+// blockedFor is how long the client still has to wait, zero if it may try
+func (f *apiKeyFailures) blockedFor(ip string) time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	until, ok := f.blocked[ip]
+	if !ok {
+		return 0
+	}
+	wait := time.Until(until)
+	if wait <= 0 {
+		delete(f.blocked, ip)
+		return 0
+	}
+	return wait
+}
+
+// fail records a failed attempt and returns how long the client is blocked
+// for once it ran out of attempts
+func (f *apiKeyFailures) fail(r *http.Request, ip string) time.Duration {
+	result, err := f.limiter.take(r, f.policy.Name+"|ip:"+ip, f.policy)
+	if err != nil {
+		// the attempt failed anyway, the client just isn't counted
+		logging.FromContext(r.Context()).Error("counting failed API key attempts failed", "err", err)
+		return 0
+	}
+	if result.Allowed {
+		return 0
+	}
+
+	now := time.Now()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for blockedIP, until := range f.blocked {
+		if !now.Before(until) {
+			delete(f.blocked, blockedIP)
+		}
+	}
+	f.blocked[ip] = now.Add(result.RetryIn)
+	return result.RetryIn
+}
+
+func (f *apiKeyFailures) refuse(w http.ResponseWriter, wait time.Duration) {
+	rateLimitRejectionsTotal.Inc(f.policy.Name)
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "Too many failed API key attempts", http.StatusTooManyRequests)
+}
+
+// authenticateAPIKey serves the request if the X-API-Key header holds a
+// valid key whose scopes cover the method and resource
+func authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, failures *apiKeyFailures) {
+	ip := ClientIP(r)
+	if failures != nil {
+		if wait := failures.blockedFor(ip); wait > 0 {
+			failures.refuse(w, wait)
+			return
+		}
+	}
+
+	key, err := sqlconnect.AuthenticateAPIKey(r.Context(), r.Header.Get("X-API-Key"))
+	if err != nil && failures != nil && (errors.Is(err, sqlconnect.ErrInvalidAPIKey) || errors.Is(err, sqlconnect.ErrAPIKeyExpired)) {
+		if wait := failures.fail(r, ip); wait > 0 {
+			apiKeyFailuresTotal.Inc("rate_limited")
+			failures.refuse(w, wait)
+			return
+		}
+	}
+	switch {
+	case errors.Is(err, sqlconnect.ErrDBTimeout):
+		http.Error(w, err.Error(), http.StatusGatewayTimeout)
+		return
+	case errors.Is(err, sqlconnect.ErrDBUnavailable):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case errors.Is(err, sqlconnect.ErrAPIKeyExpired):
+		apiKeyFailuresTotal.Inc("expired")
+		http.Error(w, "API key expired", http.StatusUnauthorized)
+		return
+	case err != nil:
+		apiKeyFailuresTotal.Inc("invalid")
+		http.Error(w, "Invalid API key", http.StatusUnauthorized)
+		return
+	}
+
+	scope := requiredScope(r)
+	if !apiKeyAllows(key, scope) {
+		apiKeyFailuresTotal.Inc("insufficient_scope")
+		logging.FromContext(r.Context()).Warn("API key used outside its scopes", "key_id", key.ID, "required_scope", scope)
+		http.Error(w, "API key is not allowed to access this resource", http.StatusForbidden)
+		return
+	}
+
+	userID := "apikey:" + strconv.Itoa(key.ID)
+	if info := requestInfoFrom(r); info != nil {
+		info.userID = userID
+	}
+
+	ctx := context.WithValue(r.Context(), utils.ContextKey("role"), apiKeyRole)
+	ctx = context.WithValue(ctx, utils.ContextKey("username"), key.Name)
+	ctx = context.WithValue(ctx, utils.ContextKey("userId"), userID)
+
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// requiredScope maps the request to <resource>:read or <resource>:write by
+// the first path segment, e.g. PATCH /students/3 needs students:write
+func requiredScope(r *http.Request) string {
+	resource, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return resource + ":read"
+	default:
+		return resource + ":write"
+	}
+}
+
+// apiKeyAllows only grants scopes that exist, so keys never reach the
+// execs, mail outbox or API key endpoints
+func apiKeyAllows(key models.APIKey, scope string) bool {
+	if !slices.Contains(models.APIKeyScopes, scope) {
+		return false
+	}
+	if slices.Contains(key.Scopes, scope) {
+		return true
+	}
+	resource, access, _ := strings.Cut(scope, ":")
+	return access == "read" && slices.Contains(key.Scopes, resource+":write")
+}
@@ -0,0 +1,47 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAuthenticateLimitsFailedAPIKeys(t *testing.T) {
+	rl := NewRateLimiter(RateLimitOptions{IdleTimeout: time.Minute})
+	defer rl.Stop()
+
+	auth := Authenticate(AuthOptions{
+		JWTSecret:      "secret",
+		RateLimiter:    rl,
+		APIKeyFailures: RateLimitPolicy{Name: "api_key_failures", Limit: 2, Window: time.Minute, KeyBy: KeyByIP},
+	})
+	handler := auth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	// malformed keys are refused before the database is asked
+	var codes []int
+	for range 4 {
+		req := httptest.NewRequest(http.MethodGet, "/students", nil)
+		req.RemoteAddr = "192.0.2.1:5000"
+		req.Header.Set("X-API-Key", "guess")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		codes = append(codes, rec.Code)
+	}
+
+	want := []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests, http.StatusTooManyRequests}
+	for i := range want {
+		if codes[i] != want[i] {
+			t.Fatalf("status codes = %v, want %v", codes, want)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/students", nil)
+	req.RemoteAddr = "192.0.2.2:5000"
+	req.Header.Set("X-API-Key", "guess")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("another client got %d, want 401", rec.Code)
+	}
+}
@@ -0,0 +1,100 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+	"restapi/internal/logging"
+	"restapi/pkg/utils"
+	"slices"
+)
+
+// ServiceAccount is a machine identity authenticated by a client
+// certificate instead of an exec login
+type ServiceAccount struct {
+	Name        string
+	Role        string
+	CommonNames []string
+	DNSNames    []string
+	URIs        []string
+	Emails      []string
+}
+
+type AuthOptions struct {
+	// JWTSecret checks the session tokens of exec logins
+	JWTSecret string
+	// ServiceAccounts are matched against client certificates the TLS
+	// handshake verified against the client CA
+	ServiceAccounts []ServiceAccount
+	// RateLimiter counts failed API key attempts per client address under
+	// APIKeyFailures, its KeyBy is ignored
+	RateLimiter    *rateLimiter
+	APIKeyFailures RateLimitPolicy
+}
+
+// Authenticate lets a request through with either a verified client
+// certificate that maps to a service account, an API key in X-API-Key or a
+// valid JWT. Certificates are only seen on the TLS listener, not behind a
+// TLS-terminating proxy.
+func Authenticate(options AuthOptions) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		jwtAuth := JWTMiddleware(options.JWTSecret)(next)
+		failures := newAPIKeyFailures(options.RateLimiter, options.APIKeyFailures)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// VerifiedChains is only filled once the chain checked out
+			if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+				if r.Header.Get("X-API-Key") != "" {
+					authenticateAPIKey(w, r, next, failures)
+					return
+				}
+				jwtAuth.ServeHTTP(w, r)
+				return
+			}
+
+			cert := r.TLS.VerifiedChains[0][0]
+			account, ok := matchServiceAccount(options.ServiceAccounts, cert)
+			if !ok {
+				jwtFailuresTotal.Inc("unknown_client_cert")
+				logging.FromContext(r.Context()).Warn("client certificate matches no service account", "subject", cert.Subject.String())
+				http.Error(w, "client certificate is not mapped to a service account", http.StatusUnauthorized)
+				return
+			}
+
+			userID := "service:" + account.Name
+			if info := requestInfoFrom(r); info != nil {
+				info.userID = userID
+			}
+
+			ctx := context.WithValue(r.Context(), utils.ContextKey("role"), account.Role)
+			ctx = context.WithValue(ctx, utils.ContextKey("expiresAt"), float64(cert.NotAfter.Unix()))
+			ctx = context.WithValue(ctx, utils.ContextKey("username"), account.Name)
+			ctx = context.WithValue(ctx, utils.ContextKey("userId"), userID)
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func matchServiceAccount(accounts []ServiceAccount, cert *x509.Certificate) (ServiceAccount, bool) {
+	for _, account := range accounts {
+		if cert.Subject.CommonName != "" && slices.Contains(account.CommonNames, cert.Subject.CommonName) {
+			return account, true
+		}
+		for _, name := range cert.DNSNames {
+			if slices.Contains(account.DNSNames, name) {
+				return account, true
+			}
+		}
+		for _, uri := range cert.URIs {
+			if slices.Contains(account.URIs, uri.String()) {
+				return account, true
+			}
+		}
+		for _, email := range cert.EmailAddresses {
+			if slices.Contains(account.Emails, email) {
+				return account, true
+			}
+		}
+	}
+	return ServiceAccount{}, false
+}
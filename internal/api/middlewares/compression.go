@@ -1,32 +1,265 @@
 package middlewares
 
 import (
+	"bufio"
 	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
 )
 
-func Compression(next http.Handler) http.Handler {
-	return http.HandlerFunc(func (w http.ResponseWriter, r *http.Request) {
-		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
-			next.ServeHTTP(w, r)
+// CompressionOptions configures Compression. Responses smaller than MinSize
+// and responses whose Content-Type starts with one of SkipContentTypes are
+// sent as they are, so are ranges and bodies that already have a
+// Content-Encoding.
+type CompressionOptions struct {
+	MinSize          int
+	SkipContentTypes []string
+}
+
+var DefaultCompressionOptions = CompressionOptions{
+	MinSize: 1024,
+	SkipContentTypes: []string{
+		"image/", "video/", "audio/", "font/woff",
+		"application/zip", "application/gzip", "application/x-gzip",
+		"application/x-bzip2", "application/x-7z-compressed", "application/pdf",
+		"application/octet-stream",
+	},
+}
+
+// our preference when the client rates several encodings the same
+var supportedEncodings = []string{"br", "gzip", "deflate"}
+
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+var compressorPools = map[string]*sync.Pool{
+	"br": {New: func() any {
+		return brotli.NewWriterLevel(io.Discard, 5)
+	}},
+	"gzip": {New: func() any {
+		return gzip.NewWriter(io.Discard)
+	}},
+	// the "deflate" content coding is the zlib format, not raw deflate
+	"deflate": {New: func() any {
+		return zlib.NewWriter(io.Discard)
+	}},
+}
+
+func Compression(options CompressionOptions) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+
+			encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+			if encoding == "" || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressResponseWriter{
+				ResponseWriter: w,
+				encoding:       encoding,
+				options:        &options,
+				status:         http.StatusOK,
+			}
+			defer cw.close()
+
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// negotiateEncoding picks the supported encoding with the highest q-value
+// in an Accept-Encoding header, or "" when the body should stay as it is
+func negotiateEncoding(acceptEncoding string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+
+	weights := make(map[string]float64)
+	wildcard := -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, found := strings.Cut(strings.TrimSpace(param), "=")
+			if found && strings.EqualFold(key, "q") {
+				parsed, err := strconv.ParseFloat(value, 64)
+				if err != nil {
+					parsed = 0
+				}
+				q = parsed
+			}
+		}
+		if coding == "*" {
+			wildcard = q
+		} else {
+			weights[coding] = q
+		}
+	}
+
+	best, bestQ := "", 0.0
+	for _, encoding := range supportedEncodings {
+		q, ok := weights[encoding]
+		if !ok {
+			q = max(wildcard, 0)
+		}
+		if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+// compressResponseWriter holds back the first MinSize bytes so that small
+// bodies and incompressible content types can still go out untouched
+type compressResponseWriter struct {
+	http.ResponseWriter
+	encoding string
+	options  *CompressionOptions
+
+	status      int
+	wroteHeader bool
+	buf         []byte
+	decided     bool
+	compressor  compressor
+}
+
+func (cw *compressResponseWriter) WriteHeader(code int) {
+	if cw.wroteHeader {
+		return
+	}
+	if code < http.StatusOK {
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	cw.wroteHeader = true
+	cw.status = code
+	if code == http.StatusNoContent || code == http.StatusNotModified {
+		cw.decide(false)
+	}
+}
+
+func (cw *compressResponseWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.decided {
+		if cw.compressor != nil {
+			return cw.compressor.Write(b)
 		}
+		return cw.ResponseWriter.Write(b)
+	}
 
-		w.Header().Set("Content-Encoding", "gzip")
-		gz := gzip.NewWriter(w)
-		defer gz.Close()
+	cw.buf = append(cw.buf, b...)
+	if len(cw.buf) >= cw.options.MinSize {
+		err := cw.flushBuffer(true)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
 
-		w = &gzipResponseWriter{ResponseWriter: w, Writer: gz}
+// decide settles whether the body gets compressed and sends the headers
+func (cw *compressResponseWriter) decide(compress bool) {
+	if cw.decided {
+		return
+	}
+	cw.decided = true
+
+	header := cw.Header()
+	// a range is a slice of the unencoded body, compressing it would make
+	// the offsets wrong
+	partial := cw.status == http.StatusPartialContent || header.Get("Content-Range") != ""
+	if compress && !partial && header.Get("Content-Encoding") == "" && cw.isCompressible() {
+		header.Del("Content-Length")
+		header.Del("Accept-Ranges")
+		header.Set("Content-Encoding", cw.encoding)
+		cw.compressor = compressorPools[cw.encoding].Get().(compressor)
+		cw.compressor.Reset(cw.ResponseWriter)
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+}
 
-		next.ServeHTTP(w, r)
+func (cw *compressResponseWriter) isCompressible() bool {
+	contentType := cw.Header().Get("Content-Type")
+	if contentType == "" && len(cw.buf) > 0 {
+		contentType = http.DetectContentType(cw.buf)
+		cw.Header().Set("Content-Type", contentType)
+	}
+	contentType = strings.ToLower(contentType)
+	return !slices.ContainsFunc(cw.options.SkipContentTypes, func(skip string) bool {
+		return strings.HasPrefix(contentType, skip)
 	})
 }
 
-type gzipResponseWriter struct {
-	http.ResponseWriter
-	Writer *gzip.Writer
+func (cw *compressResponseWriter) flushBuffer(compress bool) error {
+	cw.decide(compress)
+	if len(cw.buf) == 0 {
+		return nil
+	}
+
+	buf := cw.buf
+	cw.buf = nil
+	var err error
+	if cw.compressor != nil {
+		_, err = cw.compressor.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// Flush sends what has been written so far, a streamed response can't
+// wait for MinSize so it is compressed whatever its size
+func (cw *compressResponseWriter) Flush() {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	cw.flushBuffer(true)
+	if cw.compressor != nil {
+		cw.compressor.Flush()
+	}
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (cw *compressResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(cw.ResponseWriter).Hijack()
 }
 
-func (g *gzipResponseWriter) Write (b []byte) (int, error) {
-	return g.Writer.Write(b)
-}
\ No newline at end of file
+func (cw *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+func (cw *compressResponseWriter) close() {
+	if !cw.wroteHeader {
+		// the handler never wrote anything, leave the response alone
+		if len(cw.buf) == 0 {
+			return
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+	cw.flushBuffer(len(cw.buf) >= cw.options.MinSize)
+
+	if cw.compressor != nil {
+		cw.compressor.Close()
+		cw.compressor.Reset(io.Discard)
+		compressorPools[cw.encoding].Put(cw.compressor)
+		cw.compressor = nil
+	}
+}
@@ -0,0 +1,205 @@
+package middlewares
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := map[string]string{
+		"":                             "",
+		"gzip":                         "gzip",
+		"gzip, deflate, br":            "br",
+		"deflate":                      "deflate",
+		"GZIP;Q=0.5":                   "gzip",
+		"br;q=0, gzip":                 "gzip",
+		"br;q=0.5, gzip;q=0.8":         "gzip",
+		"br;q=0.8, gzip;q=0.8":         "br",
+		"*":                            "br",
+		"br;q=0, *":                    "gzip",
+		"*;q=0.1, deflate":             "deflate",
+		"*;q=0":                        "",
+		"identity":                     "",
+		"compress, x-gzip":             "",
+		"gzip;q=high":                  "",
+		"gzip;level=9;q=0.7, br;q=0.6": "gzip",
+	}
+	for header, want := range tests {
+		if got := negotiateEncoding(header); got != want {
+			t.Errorf("negotiateEncoding(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+// decode reverses the content coding of a response body
+func decode(t *testing.T, encoding string, body []byte) string {
+	t.Helper()
+	var reader io.Reader
+	var err error
+	switch encoding {
+	case "":
+		return string(body)
+	case "br":
+		reader = brotli.NewReader(bytes.NewReader(body))
+	case "gzip":
+		reader, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		reader, err = zlib.NewReader(bytes.NewReader(body))
+	default:
+		t.Fatalf("unexpected Content-Encoding %q", encoding)
+	}
+	if err != nil {
+		t.Fatalf("reading %s body: %v", encoding, err)
+	}
+	decoded, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("reading %s body: %v", encoding, err)
+	}
+	return string(decoded)
+}
+
+func TestCompression(t *testing.T) {
+	large := strings.Repeat(`{"first_name":"Ada","last_name":"Lovelace"},`, 100)
+	options := CompressionOptions{MinSize: 1024, SkipContentTypes: []string{"image/"}}
+
+	tests := []struct {
+		name           string
+		method         string
+		acceptEncoding string
+		headers        map[string]string
+		status         int
+		body           string
+		want           string
+	}{
+		{name: "gzip", acceptEncoding: "gzip", body: large, want: "gzip"},
+		{name: "br preferred", acceptEncoding: "gzip, br", body: large, want: "br"},
+		{name: "deflate", acceptEncoding: "deflate", body: large, want: "deflate"},
+		{name: "refused br", acceptEncoding: "br;q=0, *", body: large, want: "gzip"},
+		{name: "no Accept-Encoding", body: large, want: ""},
+		{name: "below MinSize", acceptEncoding: "gzip", body: `{"status":"ok"}`, want: ""},
+		{name: "exactly MinSize", acceptEncoding: "gzip", body: large[:1024], want: "gzip"},
+		{name: "skipped type", acceptEncoding: "gzip", headers: map[string]string{"Content-Type": "image/png"}, body: large, want: ""},
+		{name: "sniffed type", acceptEncoding: "gzip", body: "\x89PNG\r\n\x1a\n" + large, want: ""},
+		{name: "already encoded", acceptEncoding: "gzip", headers: map[string]string{"Content-Encoding": "identity"}, body: large, want: "identity"},
+		{name: "range", acceptEncoding: "gzip", headers: map[string]string{"Content-Range": "bytes 0-4399/9000"},
+			status: http.StatusPartialContent, body: large, want: ""},
+		{name: "HEAD", method: http.MethodHead, acceptEncoding: "gzip", body: large, want: ""},
+		{name: "not modified", acceptEncoding: "gzip", status: http.StatusNotModified, want: ""},
+		{name: "error", acceptEncoding: "gzip", status: http.StatusInternalServerError, body: large, want: "gzip"},
+	}
+	for _, test := range tests {
+		handler := Compression(options)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for name, value := range test.headers {
+				w.Header().Set(name, value)
+			}
+			w.Header().Set("Content-Length", "4400")
+			w.Header().Set("Accept-Ranges", "bytes")
+			if test.status != 0 {
+				w.WriteHeader(test.status)
+			}
+			// in small writes, the buffer must hold them until MinSize
+			for chunk := range slicesOf(test.body, 100) {
+				io.WriteString(w, chunk)
+			}
+		}))
+
+		method := test.method
+		if method == "" {
+			method = http.MethodGet
+		}
+		req := httptest.NewRequest(method, "/students", nil)
+		if test.acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", test.acceptEncoding)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		wantStatus := test.status
+		if wantStatus == 0 {
+			wantStatus = http.StatusOK
+		}
+		if rec.Code != wantStatus {
+			t.Errorf("%s: status = %d, want %d", test.name, rec.Code, wantStatus)
+		}
+		if got := rec.Header().Get("Content-Encoding"); got != test.want {
+			t.Errorf("%s: Content-Encoding = %q, want %q", test.name, got, test.want)
+			continue
+		}
+		if got := rec.Header().Values("Vary"); len(got) != 1 || got[0] != "Accept-Encoding" {
+			t.Errorf("%s: Vary = %q, want Accept-Encoding", test.name, got)
+		}
+		compressed := test.want != "" && test.want != "identity"
+		if got := rec.Header().Get("Content-Length") == ""; got != compressed {
+			t.Errorf("%s: Content-Length removed = %v, want %v", test.name, got, compressed)
+		}
+		if got := rec.Header().Get("Accept-Ranges") == ""; got != compressed {
+			t.Errorf("%s: Accept-Ranges removed = %v, want %v", test.name, got, compressed)
+		}
+		if compressed {
+			if got := decode(t, test.want, rec.Body.Bytes()); got != test.body {
+				t.Errorf("%s: decoded body differs from the handler's, %d bytes for %d", test.name, len(got), len(test.body))
+			}
+		} else if rec.Body.String() != test.body {
+			t.Errorf("%s: body changed, %d bytes for %d", test.name, rec.Body.Len(), len(test.body))
+		}
+	}
+}
+
+// slicesOf splits s into pieces of at most n bytes
+func slicesOf(s string, n int) func(yield func(string) bool) {
+	return func(yield func(string) bool) {
+		for len(s) > n {
+			if !yield(s[:n]) {
+				return
+			}
+			s = s[n:]
+		}
+		if s != "" {
+			yield(s)
+		}
+	}
+}
+
+func TestCompressionFlush(t *testing.T) {
+	rec := httptest.NewRecorder()
+	handler := Compression(CompressionOptions{MinSize: 1024})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+
+		// a streamed event is sent at once even though it is below MinSize
+		if !rec.Flushed {
+			t.Error("Flush did not reach the underlying writer")
+		}
+		if got := rec.Header().Get("Content-Encoding"); got != "gzip" {
+			t.Errorf("Content-Encoding after Flush = %q, want gzip", got)
+		}
+		reader, err := gzip.NewReader(bytes.NewReader(rec.Body.Bytes()))
+		if err != nil {
+			t.Fatalf("reading flushed body: %v", err)
+		}
+		first := make([]byte, len("data: first\n\n"))
+		_, err = io.ReadFull(reader, first)
+		if err != nil || string(first) != "data: first\n\n" {
+			t.Errorf("flushed body = %q, %v", first, err)
+		}
+
+		io.WriteString(w, "data: second\n\n")
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/events", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	handler.ServeHTTP(rec, req)
+
+	if got := decode(t, "gzip", rec.Body.Bytes()); got != "data: first\n\ndata: second\n\n" {
+		t.Errorf("body = %q", got)
+	}
+}
@@ -1,51 +1,144 @@
 package middlewares
 
 import (
-	"fmt"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 )
 
-// Allowed Origins
-var allowedOrigins = []string {
-	"https://my-origin-url.com",
-	"https://localhost:8000",
+// CorsOptions configures Cors. AllowedOrigins entries are exact origins
+// ("https://school.com"), "*" for any origin, or a wildcard subdomain
+// ("https://*.school.com", which doesn't match https://school.com itself).
+// "*" matches nothing when AllowCredentials is set.
+type CorsOptions struct {
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
 }
 
-func Cors (next http.Handler) http.Handler {
-	fmt.Println("Cors Middleware...")
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		fmt.Println("Cors Middleware being returned...")
-		origin := r.Header.Get("Origin")
+var DefaultCorsOptions = CorsOptions{
+	AllowedOrigins:   []string{"https://my-origin-url.com", "https://localhost:8000"},
+	AllowedMethods:   []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete},
+	AllowedHeaders:   []string{"Content-Type", "Authorization", "X-API-Key"},
+	ExposedHeaders:   []string{"Authorization"},
+	AllowCredentials: true,
+	MaxAge:           time.Hour,
+}
 
-		fmt.Println("origin:", origin)
+func Cors(options CorsOptions) func(http.Handler) http.Handler {
+	allowedMethods := strings.Join(options.AllowedMethods, ", ")
+	exposedHeaders := strings.Join(options.ExposedHeaders, ", ")
+	maxAge := strconv.Itoa(int(options.MaxAge.Seconds()))
 
-		if isOriginAllowed(origin) {
-			w.Header().Set("Access-Control-Allow-Origin", origin)
-		} else {
-			http.Error(w, "Not Allowed by CORS", http.StatusForbidden)
-			return
-		}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Origin")
 
-		w.Header().Set("Allow-Control-Allow-Headers", "Content-Type, Authorization")
-		w.Header().Set("Allow-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE")
-		w.Header().Set("Allow-Control-Allow-Credentials", "true")
-		w.Header().Set("Allow-Control-Expose-Headers", "Authorization")
-		w.Header().Set("Allow-Control-Max-Age", "3600")
+			origin := r.Header.Get("Origin")
+			// same-origin and non-browser requests don't send an Origin,
+			// CORS has nothing to say about them
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
 
-		if r.Method == http.MethodOptions {
-			return
-		}
+			allowed := options.isOriginAllowed(origin)
+			isPreflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+			if isPreflight {
+				w.Header().Add("Vary", "Access-Control-Request-Method")
+				w.Header().Add("Vary", "Access-Control-Request-Headers")
+
+				requestedMethod := r.Header.Get("Access-Control-Request-Method")
+				requestedHeaders := parseHeaderList(r.Header.Get("Access-Control-Request-Headers"))
+				if !allowed || !slices.Contains(options.AllowedMethods, requestedMethod) || !options.areHeadersAllowed(requestedHeaders) {
+					http.Error(w, "Not Allowed by CORS", http.StatusForbidden)
+					return
+				}
+
+				options.setAllowOrigin(w, origin)
+				w.Header().Set("Access-Control-Allow-Methods", allowedMethods)
+				if len(requestedHeaders) > 0 {
+					w.Header().Set("Access-Control-Allow-Headers", strings.Join(requestedHeaders, ", "))
+				}
+				if options.MaxAge > 0 {
+					w.Header().Set("Access-Control-Max-Age", maxAge)
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			// a disallowed origin still gets its response, without the
+			// headers the browser won't let the page read it
+			if allowed {
+				options.setAllowOrigin(w, origin)
+				if exposedHeaders != "" {
+					w.Header().Set("Access-Control-Expose-Headers", exposedHeaders)
+				}
+			}
 
-		next.ServeHTTP(w, r)
-		fmt.Println("Cors Middleware ends...")
-	})
+			next.ServeHTTP(w, r)
+		})
+	}
 }
 
-func isOriginAllowed(origin string) bool {
-	for _, allowedOrigin := range allowedOrigins {
-		if origin == allowedOrigin {
+func (o CorsOptions) setAllowOrigin(w http.ResponseWriter, origin string) {
+	if slices.Contains(o.AllowedOrigins, "*") && !o.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+	if o.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (o CorsOptions) isOriginAllowed(origin string) bool {
+	for _, allowedOrigin := range o.AllowedOrigins {
+		// any origin with credentials would let every site act as the user
+		if allowedOrigin == "*" && !o.AllowCredentials {
+			return true
+		}
+		if strings.EqualFold(origin, allowedOrigin) {
+			return true
+		}
+
+		scheme, host, found := strings.Cut(allowedOrigin, "://*.")
+		if !found {
+			continue
+		}
+		prefix := scheme + "://"
+		if len(origin) > len(prefix) && strings.EqualFold(origin[:len(prefix)], prefix) &&
+			strings.HasSuffix(strings.ToLower(origin), "."+strings.ToLower(host)) {
 			return true
 		}
 	}
 	return false
-}
\ No newline at end of file
+}
+
+func (o CorsOptions) areHeadersAllowed(headers []string) bool {
+	for _, header := range headers {
+		if !slices.ContainsFunc(o.AllowedHeaders, func(allowed string) bool {
+			return allowed == "*" || strings.EqualFold(allowed, header)
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+func parseHeaderList(value string) []string {
+	var headers []string
+	for _, header := range strings.Split(value, ",") {
+		header = strings.TrimSpace(header)
+		if header != "" {
+			headers = append(headers, header)
+		}
+	}
+	return headers
+}
@@ -1,15 +1,12 @@
 package middlewares
 
 import (
-	"fmt"
 	"net/http"
 	"strings"
 )
 
 func MiddlewaresExcludePaths(middleware func(http.Handler) http.Handler, excludedPaths ...string) func(http.Handler) http.Handler {
-	fmt.Println("MiddlewaresExcludePaths initialized")
 	return func(next http.Handler) http.Handler {
-		fmt.Println("============= MiddlewaresExcludePaths RAN")
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			for _, path := range excludedPaths {
 				if strings.HasPrefix(r.URL.Path, path) {
@@ -18,7 +15,22 @@ func MiddlewaresExcludePaths(middleware func(http.Handler) http.Handler, exclude
 				}
 			}
 			middleware(next).ServeHTTP(w, r)
-			fmt.Println("Sent response from MiddlewaresExcludePaths")
 		})
 	}
-}
\ No newline at end of file
+}
+
+// MiddlewaresOnlyPaths applies middleware to the paths starting with one of
+// paths and lets every other request through untouched
+func MiddlewaresOnlyPaths(middleware func(http.Handler) http.Handler, paths ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, path := range paths {
+				if strings.HasPrefix(r.URL.Path, path) {
+					middleware(next).ServeHTTP(w, r)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
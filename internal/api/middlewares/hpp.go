@@ -1,72 +1,235 @@
 package middlewares
 
 import (
+	"encoding/json"
+	"fmt"
+	"mime"
 	"net/http"
+	"net/url"
+	"restapi/internal/logging"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 )
 
+// Parameter types
+const (
+	ParamString = "string"
+	ParamInt    = "int"
+	ParamBool   = "bool"
+)
+
+// What to do with a single-valued parameter sent more than once
+const (
+	HPPFirst  = "first"
+	HPPLast   = "last"
+	HPPReject = "reject"
+)
+
+// ParamSchema describes one query or form parameter a route accepts
+type ParamSchema struct {
+	Name string
+	// Type is string (the default), int or bool
+	Type string
+	// Multi keeps every value of a repeated parameter
+	Multi bool
+	// Policy overrides HPPOptions.DefaultPolicy for this parameter
+	Policy string
+}
+
+// RouteParams lists the parameters of one route, Pattern is written like
+// the router's, e.g. "GET /students"
+type RouteParams struct {
+	Pattern string
+	Query   []ParamSchema
+	Body    []ParamSchema
+}
+
+// HPPOptions configures Hpp. Only the routes listed are checked. Parameters
+// that are unknown, of the wrong type or repeated under the reject policy
+// are dropped, or answered with a 400 listing them in Strict mode.
 type HPPOptions struct {
-	CheckQuery 					bool
-	CheckBody					bool
-	CheckBodyOnlyForContentType	string
-	WhiteList					[]string
+	CheckQuery bool
+	// CheckBody checks application/x-www-form-urlencoded bodies of POST,
+	// PUT and PATCH requests
+	CheckBody     bool
+	Strict        bool
+	DefaultPolicy string
+	Routes        []RouteParams
+}
+
+type rejectedParam struct {
+	Name   string `json:"name"`
+	In     string `json:"in"`
+	Reason string `json:"reason"`
 }
 
-func Hpp(options HPPOptions) func (http.Handler) http.Handler {
-	return func (next http.Handler) http.Handler {
+func Hpp(options HPPOptions) (func(http.Handler) http.Handler, error) {
+	if options.DefaultPolicy == "" {
+		options.DefaultPolicy = HPPLast
+	}
+	if !slices.Contains(hppPolicies, options.DefaultPolicy) {
+		return nil, fmt.Errorf("hpp: unknown policy %q", options.DefaultPolicy)
+	}
+
+	// a mux of its own finds the route the way the router will
+	routes := http.NewServeMux()
+	schemas := make(map[string]RouteParams)
+	for _, route := range options.Routes {
+		err := validateRouteParams(route)
+		if err != nil {
+			return nil, err
+		}
+		routes.Handle(route.Pattern, http.NotFoundHandler())
+		schemas[route.Pattern] = route
+	}
+
+	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if options.CheckBody && r.Method == http.MethodPost &&  isCorrectContentType(r, options.CheckBodyOnlyForContentType){
-				filterBodyParams(r, options.WhiteList)
+			_, pattern := routes.Handler(r)
+			route, ok := schemas[pattern]
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			var rejected []rejectedParam
+			if options.CheckQuery && r.URL.RawQuery != "" {
+				query, queryRejected := filterParams(r.URL.Query(), route.Query, options.DefaultPolicy, "query")
+				r.URL.RawQuery = query.Encode()
+				rejected = append(rejected, queryRejected...)
+			}
+			if options.CheckBody && isFormRequest(r) {
+				body, ok := readBody(w, r)
+				if !ok {
+					return
+				}
+				form, err := url.ParseQuery(string(body))
+				if err != nil {
+					http.Error(w, "Invalid form body", http.StatusBadRequest)
+					return
+				}
+				form, bodyRejected := filterParams(form, route.Body, options.DefaultPolicy, "body")
+				setBody(r, []byte(form.Encode()))
+				rejected = append(rejected, bodyRejected...)
 			}
-			if options.CheckQuery && r.URL.Query() != nil {
-				filterQueryParams(r, options.WhiteList)
+
+			if len(rejected) > 0 {
+				if options.Strict {
+					writeRejectedParams(w, r, rejected)
+					return
+				}
+				logging.FromContext(r.Context()).Debug("dropped request parameters", "route", pattern, "params", rejectedNames(rejected))
 			}
 			next.ServeHTTP(w, r)
 		})
-	}
+	}, nil
 }
 
-func isCorrectContentType(r *http.Request, contentType string) bool {
-	return strings.Contains(r.Header.Get("Content-Type"), contentType)
-}
+var hppPolicies = []string{HPPFirst, HPPLast, HPPReject}
 
-func filterBodyParams(r *http.Request, whiteList []string) {
-	err := r.ParseForm()
-	if err != nil {
-		return
-	}
-	for k, v := range r.Form {
-		if len(v) > 1 {
-			r.Form.Set(k, v[0]) // first value
-			// r.Form.Set(k, v[len(v) - 1]) last value
+func validateRouteParams(route RouteParams) error {
+	for _, param := range slices.Concat(route.Query, route.Body) {
+		if param.Type != "" && !slices.Contains([]string{ParamString, ParamInt, ParamBool}, param.Type) {
+			return fmt.Errorf("hpp: %s: parameter %s has unknown type %q", route.Pattern, param.Name, param.Type)
 		}
-		if !isWhiteListed(k, whiteList) {
-			delete(r.Form, k)
+		if param.Policy != "" && !slices.Contains(hppPolicies, param.Policy) {
+			return fmt.Errorf("hpp: %s: parameter %s has unknown policy %q", route.Pattern, param.Name, param.Policy)
 		}
 	}
+	return nil
+}
+
+// isFormRequest tells url-encoded form bodies apart by media type, so
+// "application/x-www-form-urlencoded; charset=utf-8" matches too
+func isFormRequest(r *http.Request) bool {
+	if r.Body == nil || r.Body == http.NoBody {
+		return false
+	}
+	if r.Method != http.MethodPost && r.Method != http.MethodPut && r.Method != http.MethodPatch {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/x-www-form-urlencoded"
 }
 
-func filterQueryParams(r *http.Request, whiteList []string) {
-	query := r.URL.Query()
+// filterParams keeps the parameters of the schema and reports the others
+func filterParams(values url.Values, schema []ParamSchema, defaultPolicy, in string) (url.Values, []rejectedParam) {
+	filtered := make(url.Values, len(values))
+	var rejected []rejectedParam
 
-	for k, v := range query {
-		if len(v) > 1 {
-			// query.Set(k, v[0]) // first value
-			query.Set(k, v[len(v) - 1]) //last value
+	for name, paramValues := range values {
+		index := slices.IndexFunc(schema, func(param ParamSchema) bool { return param.Name == name })
+		if index < 0 {
+			rejected = append(rejected, rejectedParam{Name: name, In: in, Reason: "unknown parameter"})
+			continue
 		}
-		if !isWhiteListed(k, whiteList) {
-			query.Del(k)
+		param := schema[index]
+
+		if !param.Multi && len(paramValues) > 1 {
+			policy := param.Policy
+			if policy == "" {
+				policy = defaultPolicy
+			}
+			switch policy {
+			case HPPFirst:
+				paramValues = paramValues[:1]
+			case HPPLast:
+				paramValues = paramValues[len(paramValues)-1:]
+			default:
+				rejected = append(rejected, rejectedParam{Name: name, In: in, Reason: "expected a single value"})
+				continue
+			}
+		}
+
+		if !validParamValues(param.Type, paramValues) {
+			rejected = append(rejected, rejectedParam{Name: name, In: in, Reason: "expected " + param.Type})
+			continue
 		}
+		filtered[name] = paramValues
 	}
 
-	r.URL.RawQuery = query.Encode()
+	sort.Slice(rejected, func(i, j int) bool { return rejected[i].Name < rejected[j].Name })
+	return filtered, rejected
 }
 
-func isWhiteListed(param string, whitelist []string) bool {
-	for _, v := range whitelist {
-		if param == v {
-			return true
+func validParamValues(paramType string, values []string) bool {
+	for _, value := range values {
+		var err error
+		switch paramType {
+		case ParamInt:
+			_, err = strconv.Atoi(value)
+		case ParamBool:
+			_, err = strconv.ParseBool(value)
 		}
+		if err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func writeRejectedParams(w http.ResponseWriter, r *http.Request, rejected []rejectedParam) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(struct {
+		errorEnvelope
+		Rejected []rejectedParam `json:"rejected"`
+	}{
+		errorEnvelope: errorEnvelope{
+			Status:    "error",
+			Error:     "invalid parameters: " + strings.Join(rejectedNames(rejected), ", "),
+			RequestID: logging.RequestID(r.Context()),
+		},
+		Rejected: rejected,
+	})
+}
+
+func rejectedNames(rejected []rejectedParam) []string {
+	names := make([]string, len(rejected))
+	for i, param := range rejected {
+		names[i] = param.In + "." + param.Name
 	}
-	return false
+	return names
 }
@@ -0,0 +1,170 @@
+package middlewares
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+var testParamRoutes = []RouteParams{
+	{Pattern: "GET /students", Query: []ParamSchema{
+		{Name: "page", Type: ParamInt, Policy: HPPReject},
+		{Name: "active", Type: ParamBool},
+		{Name: "class", Policy: HPPFirst},
+		{Name: "last_name"},
+		{Name: "sortby", Multi: true},
+	}},
+	{Pattern: "POST /students", Body: []ParamSchema{
+		{Name: "first_name"},
+		{Name: "age", Type: ParamInt},
+	}},
+}
+
+type hppResult struct {
+	code  int
+	query url.Values
+	body  string
+	reply string
+}
+
+func runHpp(t *testing.T, strict bool, req *http.Request) hppResult {
+	t.Helper()
+
+	hpp, err := Hpp(HPPOptions{CheckQuery: true, CheckBody: true, Strict: strict, Routes: testParamRoutes})
+	if err != nil {
+		t.Fatalf("Hpp: %v", err)
+	}
+	var result hppResult
+	handler := hpp(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		result.query = r.URL.Query()
+		if r.Body != nil {
+			body, _ := io.ReadAll(r.Body)
+			result.body = string(body)
+		}
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	result.code = rec.Code
+	result.reply = rec.Body.String()
+	return result
+}
+
+func TestHppRepeatedParamPolicies(t *testing.T) {
+	// the default policy is last, class is first and page rejects
+	result := runHpp(t, false, httptest.NewRequest(http.MethodGet, "/students?class=9A&class=9B&last_name=a&last_name=b&page=1&page=2&sortby=a:asc&sortby=b:desc", nil))
+	if result.code != http.StatusOK {
+		t.Fatalf("status = %d", result.code)
+	}
+
+	want := url.Values{"class": {"9A"}, "last_name": {"b"}, "sortby": {"a:asc", "b:desc"}}
+	if result.query.Encode() != want.Encode() {
+		t.Errorf("query = %v, want %v", result.query, want)
+	}
+}
+
+func TestHppTypeChecks(t *testing.T) {
+	tests := []struct {
+		query string
+		kept  bool
+	}{
+		{"page=2", true},
+		{"page=two", false},
+		{"active=true", true},
+		{"active=1", true},
+		{"active=yes", false},
+	}
+	for _, test := range tests {
+		result := runHpp(t, false, httptest.NewRequest(http.MethodGet, "/students?"+test.query, nil))
+		name, _, _ := strings.Cut(test.query, "=")
+		if result.query.Has(name) != test.kept {
+			t.Errorf("%s kept = %v, want %v", test.query, result.query.Has(name), test.kept)
+		}
+	}
+}
+
+func TestHppDropsUnknownParams(t *testing.T) {
+	result := runHpp(t, false, httptest.NewRequest(http.MethodGet, "/students?last_name=a&role=admin", nil))
+	if result.code != http.StatusOK || result.query.Has("role") || result.query.Get("last_name") != "a" {
+		t.Errorf("status %d, query %v, want role dropped and last_name kept", result.code, result.query)
+	}
+}
+
+func TestHppStrictListsRejectedParams(t *testing.T) {
+	result := runHpp(t, true, httptest.NewRequest(http.MethodGet, "/students?page=1&page=2&role=admin&active=maybe&last_name=a", nil))
+	if result.code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", result.code)
+	}
+
+	var reply struct {
+		Status   string          `json:"status"`
+		Error    string          `json:"error"`
+		Rejected []rejectedParam `json:"rejected"`
+	}
+	err := json.Unmarshal([]byte(result.reply), &reply)
+	if err != nil {
+		t.Fatalf("reply %q: %v", result.reply, err)
+	}
+	want := []rejectedParam{
+		{Name: "active", In: "query", Reason: "expected bool"},
+		{Name: "page", In: "query", Reason: "expected a single value"},
+		{Name: "role", In: "query", Reason: "unknown parameter"},
+	}
+	if len(reply.Rejected) != len(want) {
+		t.Fatalf("rejected = %+v, want %+v", reply.Rejected, want)
+	}
+	for i := range want {
+		if reply.Rejected[i] != want[i] {
+			t.Errorf("rejected[%d] = %+v, want %+v", i, reply.Rejected[i], want[i])
+		}
+	}
+	if reply.Status != "error" || reply.Error != "invalid parameters: query.active, query.page, query.role" {
+		t.Errorf("status %q, error %q", reply.Status, reply.Error)
+	}
+}
+
+func TestHppChecksFormBodies(t *testing.T) {
+	for _, contentType := range []string{"application/x-www-form-urlencoded", "application/x-www-form-urlencoded; charset=utf-8"} {
+		req := httptest.NewRequest(http.MethodPost, "/students", strings.NewReader("first_name=a&first_name=b&age=x&role=admin"))
+		req.Header.Set("Content-Type", contentType)
+		result := runHpp(t, false, req)
+
+		if result.body != "first_name=b" {
+			t.Errorf("%s: body = %q, want first_name=b", contentType, result.body)
+		}
+	}
+
+	// JSON bodies are the sanitizer's business
+	req := httptest.NewRequest(http.MethodPost, "/students", strings.NewReader(`{"role":"admin"}`))
+	req.Header.Set("Content-Type", "application/json")
+	if result := runHpp(t, false, req); result.body != `{"role":"admin"}` {
+		t.Errorf("JSON body = %q, want it untouched", result.body)
+	}
+}
+
+func TestHppLeavesRoutesWithoutSchemaAlone(t *testing.T) {
+	result := runHpp(t, true, httptest.NewRequest(http.MethodGet, "/teachers?x=1&x=2&anything=else", nil))
+	want := url.Values{"x": {"1", "2"}, "anything": {"else"}}
+	if result.code != http.StatusOK || result.query.Encode() != want.Encode() {
+		t.Errorf("status %d, query %v, want 200 and %v", result.code, result.query, want)
+	}
+
+	result = runHpp(t, true, httptest.NewRequest(http.MethodDelete, "/students?ids=1&ids=2", nil))
+	if result.code != http.StatusOK || len(result.query["ids"]) != 2 {
+		t.Errorf("DELETE /students: status %d, query %v, want it untouched", result.code, result.query)
+	}
+}
+
+func TestHppRejectsUnknownPolicyAndType(t *testing.T) {
+	_, err := Hpp(HPPOptions{DefaultPolicy: "middle"})
+	if err == nil {
+		t.Error("Hpp accepted an unknown default policy")
+	}
+	_, err = Hpp(HPPOptions{Routes: []RouteParams{{Pattern: "GET /x", Query: []ParamSchema{{Name: "a", Type: "float"}}}}})
+	if err == nil {
+		t.Error("Hpp accepted an unknown parameter type")
+	}
+}
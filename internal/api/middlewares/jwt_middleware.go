@@ -4,63 +4,110 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
-	"os"
+	"restapi/internal/logging"
+	"restapi/internal/repository/sqlconnect"
 	"restapi/pkg/utils"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func JWTMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request){
+// JWTMiddleware checks the session token of the Bearer cookie against the
+// secret it was signed with
+func JWTMiddleware(secret string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request){
 
-		token, err := r.Cookie("Bearer")
-		if err != nil {
-			http.Error(w, "Authorization Header missing", http.StatusUnauthorized)
-			return
-		}
+			token, err := r.Cookie("Bearer")
+			if err != nil {
+				jwtFailuresTotal.Inc("missing_token")
+				http.Error(w, "Authorization Header missing", http.StatusUnauthorized)
+				return
+			}
 
-		jwtSecret := os.Getenv("JWT_SECRET")
+			parsedToken, err := jwt.Parse(token.Value, func(token *jwt.Token)(interface{}, error){
+				if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+					return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+				} 
+				return []byte(secret), nil
+			})
+			if err != nil {
+				if errors.Is(err, jwt.ErrTokenExpired) {
+					jwtFailuresTotal.Inc("expired")
+					http.Error(w, "Token Expired", http.StatusUnauthorized)
+					return
+				} else if errors.Is(err, jwt.ErrTokenMalformed) {
+					jwtFailuresTotal.Inc("malformed")
+					http.Error(w, "Token Malformed", http.StatusUnauthorized)
+					return
+				}
+				jwtFailuresTotal.Inc("invalid_token")
+				utils.ErrorHandler(err, "")
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
 
-		parsedToken, err := jwt.Parse(token.Value, func(token *jwt.Token)(interface{}, error){
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			} 
-			return []byte(jwtSecret), nil
-		})
-		if err != nil {
-			if errors.Is(err, jwt.ErrTokenExpired) {
-				http.Error(w, "Token Expired", http.StatusUnauthorized)
+			if !parsedToken.Valid {
+				jwtFailuresTotal.Inc("invalid")
+				logging.FromContext(r.Context()).Warn("invalid jwt")
+				http.Error(w, "Invalid Login Token", http.StatusUnauthorized)
 				return
-			} else if errors.Is(err, jwt.ErrTokenMalformed) {
-				http.Error(w, "Token Malformed", http.StatusUnauthorized)
+			}
+		
+			claims, ok := parsedToken.Claims.(jwt.MapClaims)
+			if !ok {
+				jwtFailuresTotal.Inc("invalid_claims")
+				logging.FromContext(r.Context()).Warn("invalid jwt claims")
+				http.Error(w, "Invalid Login Token", http.StatusUnauthorized)
 				return
 			}
-			utils.ErrorHandler(err, "")
-			http.Error(w, err.Error(), http.StatusUnauthorized)
-			return
-		}
 
-		if parsedToken.Valid {
-			log.Println("Valid JWT")
-		} else {
-			http.Error(w, "Invalid Login Token", http.StatusUnauthorized)
-			log.Println("Invalid jwt:", token.Value)
-		}
-		
-		claims, ok := parsedToken.Claims.(jwt.MapClaims)
-		if !ok {
-			http.Error(w, "Invalid Login Token", http.StatusUnauthorized)
-			log.Println("Invalid jwt:", token.Value)
-			return
-		}
+			// a deactivated exec loses every session it still holds, and keeps
+			// them lost once reactivated
+			uid, ok := claims["uid"].(float64)
+			if !ok {
+				jwtFailuresTotal.Inc("missing_uid")
+				http.Error(w, "Invalid Login Token", http.StatusUnauthorized)
+				return
+			}
+			state, err := sqlconnect.GetExecSessionState(r.Context(), int(uid))
+			if errors.Is(err, sqlconnect.ErrDBTimeout) {
+				http.Error(w, err.Error(), http.StatusGatewayTimeout)
+				return
+			}
+			if errors.Is(err, sqlconnect.ErrDBUnavailable) {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			if err != nil {
+				jwtFailuresTotal.Inc("unknown_user")
+				http.Error(w, "Invalid Login Token", http.StatusUnauthorized)
+				return
+			}
+			if state.Inactive {
+				jwtFailuresTotal.Inc("inactive_account")
+				http.Error(w, "account is inactive", http.StatusUnauthorized)
+				return
+			}
+			// tokens without iat predate the claim and count as issued at the
+			// epoch
+			issuedAt, _ := claims.GetIssuedAt()
+			if !state.RevokedAt.IsZero() && (issuedAt == nil || !issuedAt.After(state.RevokedAt)) {
+				jwtFailuresTotal.Inc("revoked")
+				http.Error(w, "session revoked, log in again", http.StatusUnauthorized)
+				return
+			}
 
-		ctx := context.WithValue(r.Context(), utils.ContextKey("role"), claims["role"])
-		ctx = context.WithValue(ctx, utils.ContextKey("expiresAt"), claims["exp"])
-		ctx = context.WithValue(ctx, utils.ContextKey("username"), claims["user"])
-		ctx = context.WithValue(ctx, utils.ContextKey("userId"), claims["uid"])
+			if info := requestInfoFrom(r); info != nil {
+				info.userID = claims["uid"]
+			}
 
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+			ctx := context.WithValue(r.Context(), utils.ContextKey("role"), claims["role"])
+			ctx = context.WithValue(ctx, utils.ContextKey("expiresAt"), claims["exp"])
+			ctx = context.WithValue(ctx, utils.ContextKey("username"), claims["user"])
+			ctx = context.WithValue(ctx, utils.ContextKey("userId"), claims["uid"])
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
 }
\ No newline at end of file
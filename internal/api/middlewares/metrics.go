@@ -0,0 +1,40 @@
+package middlewares
+
+import (
+	"net/http"
+	"restapi/internal/metrics"
+)
+
+var (
+	httpRequestsTotal = metrics.Default.NewCounterVec("http_requests_total",
+		"Requests served, by method, route pattern and status code.", "method", "route", "status")
+	httpRequestDuration = metrics.Default.NewHistogramVec("http_request_duration_seconds",
+		"Time spent serving requests, by method and route pattern.", metrics.DefaultBuckets, "method", "route")
+	rateLimitRejectionsTotal = metrics.Default.NewCounterVec("rate_limit_rejections_total",
+		"Requests turned away with 429, by rate limit policy.", "policy")
+	rateLimitFallbacksTotal = metrics.Default.NewCounterVec("rate_limit_store_fallbacks_total",
+		"Requests limited by the in-memory store because the shared store failed, by rate limit policy.", "policy")
+	jwtFailuresTotal = metrics.Default.NewCounterVec("jwt_failures_total",
+		"Requests rejected by the JWT middleware, by reason.", "reason")
+	apiKeyFailuresTotal = metrics.Default.NewCounterVec("api_key_failures_total",
+		"Requests with an X-API-Key header that were rejected, by reason.", "reason")
+)
+
+// metricsRoute keeps unmatched paths from each getting their own series
+func metricsRoute(pattern string) string {
+	if pattern == "" {
+		return "unmatched"
+	}
+	return pattern
+}
+
+// metricsMethod keeps made-up methods, which the client picks freely, from
+// each getting their own series
+func metricsMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "other"
+}
@@ -0,0 +1,47 @@
+package middlewares
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// MetricsAccessOptions restricts who can scrape: clients in AllowedNetworks
+// (CIDRs or single addresses), and when Token is set only those sending it as
+// a bearer token
+type MetricsAccessOptions struct {
+	Token           string
+	AllowedNetworks []string
+}
+
+// MetricsAccess guards the metrics endpoint, which sits outside the JWT
+// check and the rate limits like the probes. It relies on RealIP for the
+// client address, a client behind our proxies isn't mistaken for the proxy.
+func MetricsAccess(options MetricsAccessOptions) (func(http.Handler) http.Handler, error) {
+	allowed, err := parsePrefixes(options.AllowedNetworks)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metrics network %w", err)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			addr, err := netip.ParseAddr(ClientIP(r))
+			if err != nil || !prefixesContain(allowed, addr) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			if options.Token != "" {
+				token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+				if !found || subtle.ConstantTimeCompare([]byte(token), []byte(options.Token)) != 1 {
+					w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+					http.Error(w, "Unauthorized", http.StatusUnauthorized)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
@@ -0,0 +1,53 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMetricsAccess(t *testing.T) {
+	access, err := MetricsAccess(MetricsAccessOptions{Token: "scrape", AllowedNetworks: []string{"10.0.0.0/8", "::1"}})
+	if err != nil {
+		t.Fatalf("MetricsAccess: %v", err)
+	}
+	handler := access(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		remote string
+		auth   string
+		want   int
+	}{
+		{"10.1.2.3:4000", "Bearer scrape", http.StatusOK},
+		{"[::1]:4000", "Bearer scrape", http.StatusOK},
+		{"10.1.2.3:4000", "Bearer guess", http.StatusUnauthorized},
+		{"10.1.2.3:4000", "", http.StatusUnauthorized},
+		{"192.0.2.1:4000", "Bearer scrape", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.RemoteAddr = test.remote
+		if test.auth != "" {
+			req.Header.Set("Authorization", test.auth)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != test.want {
+			t.Errorf("%s with %q = %d, want %d", test.remote, test.auth, rec.Code, test.want)
+		}
+	}
+}
+
+func TestMetricsMethodBoundsTheLabel(t *testing.T) {
+	tests := map[string]string{
+		http.MethodGet:    http.MethodGet,
+		http.MethodDelete: http.MethodDelete,
+		"PROPFIND":        "other",
+		"get":             "other",
+	}
+	for method, want := range tests {
+		if got := metricsMethod(method); got != want {
+			t.Errorf("metricsMethod(%q) = %q, want %q", method, got, want)
+		}
+	}
+}
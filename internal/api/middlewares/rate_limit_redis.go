@@ -0,0 +1,289 @@
+package middlewares
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenBucketScript runs the whole refill-and-take step inside Redis so
+// concurrent replicas can't race on the same bucket. It uses the server
+// clock, which keeps replicas with drifting clocks in agreement.
+const tokenBucketScript = `
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local ttl = tonumber(ARGV[3])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+	tokens = capacity
+	ts = now
+end
+
+tokens = math.min(capacity, tokens + math.max(0, now - ts) * rate)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(now))
+redis.call('PEXPIRE', KEYS[1], ttl)
+
+local wait
+if allowed == 1 then
+	wait = (1 - (tokens % 1)) / rate
+else
+	wait = (1 - tokens) / rate
+end
+return {allowed, math.floor(tokens), math.ceil(wait)}
+`
+
+var tokenBucketScriptSHA = func() string {
+	sum := sha1.Sum([]byte(tokenBucketScript))
+	return hex.EncodeToString(sum[:])
+}()
+
+type RedisRateLimitOptions struct {
+	Addr      string
+	Password  string
+	DB        int
+	KeyPrefix string
+	PoolSize  int
+	Timeout   time.Duration
+}
+
+// redisRateLimitStore keeps buckets in Redis, or anything that speaks the
+// Redis protocol and runs Lua scripts (KeyDB, Dragonfly, Valkey)
+type redisRateLimitStore struct {
+	options RedisRateLimitOptions
+	pool    chan *redisConn
+	mu      sync.Mutex
+	closed  bool
+}
+
+func NewRedisRateLimitStore(options RedisRateLimitOptions) *redisRateLimitStore {
+	if options.PoolSize <= 0 {
+		options.PoolSize = 10
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = time.Second
+	}
+	if options.KeyPrefix == "" {
+		options.KeyPrefix = "ratelimit:"
+	}
+	return &redisRateLimitStore{
+		options: options,
+		pool:    make(chan *redisConn, options.PoolSize),
+	}
+}
+
+func (s *redisRateLimitStore) Take(ctx context.Context, key string, policy RateLimitPolicy) (RateLimitResult, error) {
+	capacity := policy.capacity()
+	ratePerMs := policy.refillRate() / 1000
+	// an idle bucket refills completely within this time, after that
+	// there is no point in keeping it
+	ttl := int64(float64(capacity)/ratePerMs) + 1000
+
+	args := []string{strconv.Itoa(capacity), strconv.FormatFloat(ratePerMs, 'f', -1, 64), strconv.FormatInt(ttl, 10)}
+
+	reply, err := s.eval(ctx, s.options.KeyPrefix+key, args)
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+
+	values, ok := reply.([]any)
+	if !ok || len(values) != 3 {
+		return RateLimitResult{}, fmt.Errorf("redis rate limit: unexpected reply %v", reply)
+	}
+	allowed, _ := values[0].(int64)
+	remaining, _ := values[1].(int64)
+	waitMs, _ := values[2].(int64)
+
+	return RateLimitResult{
+		Allowed:   allowed == 1,
+		Remaining: int(remaining),
+		RetryIn:   time.Duration(waitMs) * time.Millisecond,
+	}, nil
+}
+
+// eval runs the script by its hash, loading it on the first NOSCRIPT reply
+func (s *redisRateLimitStore) eval(ctx context.Context, key string, args []string) (any, error) {
+	conn, err := s.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := conn.do(ctx, s.options.Timeout, append([]string{"EVALSHA", tokenBucketScriptSHA, "1", key}, args...)...)
+	var redisErr redisError
+	if errors.As(err, &redisErr) && strings.HasPrefix(string(redisErr), "NOSCRIPT") {
+		reply, err = conn.do(ctx, s.options.Timeout, append([]string{"EVAL", tokenBucketScript, "1", key}, args...)...)
+	}
+	s.put(conn, err)
+	return reply, err
+}
+
+func (s *redisRateLimitStore) get(ctx context.Context) (*redisConn, error) {
+	select {
+	case conn := <-s.pool:
+		return conn, nil
+	default:
+	}
+
+	dialer := net.Dialer{Timeout: s.options.Timeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", s.options.Addr)
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{conn: netConn, reader: bufio.NewReader(netConn)}
+
+	if s.options.Password != "" {
+		_, err = conn.do(ctx, s.options.Timeout, "AUTH", s.options.Password)
+		if err != nil {
+			netConn.Close()
+			return nil, err
+		}
+	}
+	if s.options.DB != 0 {
+		_, err = conn.do(ctx, s.options.Timeout, "SELECT", strconv.Itoa(s.options.DB))
+		if err != nil {
+			netConn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// put returns the connection to the pool, unless it broke mid-command
+func (s *redisRateLimitStore) put(conn *redisConn, err error) {
+	var redisErr redisError
+	if err != nil && !errors.As(err, &redisErr) {
+		conn.conn.Close()
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		conn.conn.Close()
+		return
+	}
+	select {
+	case s.pool <- conn:
+	default:
+		conn.conn.Close()
+	}
+}
+
+func (s *redisRateLimitStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	for {
+		select {
+		case conn := <-s.pool:
+			conn.conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// redisError is an error reply sent by the server, the connection itself
+// is still fine afterwards
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func (c *redisConn) do(ctx context.Context, timeout time.Duration, args ...string) (any, error) {
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	c.conn.SetDeadline(deadline)
+
+	var cmd strings.Builder
+	fmt.Fprintf(&cmd, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&cmd, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_, err := io.WriteString(c.conn, cmd.String())
+	if err != nil {
+		return nil, err
+	}
+	return c.readReply()
+}
+
+// readReply parses one RESP2 reply
+func (c *redisConn) readReply() (any, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if len(line) == 0 {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		_, err = io.ReadFull(c.reader, buf)
+		if err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		values := make([]any, n)
+		for i := range values {
+			values[i], err = c.readReply()
+			if err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply %q", line)
+	}
+}
@@ -0,0 +1,257 @@
+package middlewares
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis speaks enough RESP2 to stand in for Redis: AUTH, SELECT and the
+// token bucket script, which it runs as a plain counter per key
+type fakeRedis struct {
+	t        *testing.T
+	listener net.Listener
+	password string
+
+	mu       sync.Mutex
+	commands [][]string
+	loaded   bool
+	taken    map[string]int
+	// evalError, when set, is the error reply of every script call
+	evalError string
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	f := &fakeRedis{t: t, listener: listener, password: password, taken: make(map[string]int)}
+	t.Cleanup(func() { listener.Close() })
+	go f.serve()
+	return f
+}
+
+func (f *fakeRedis) addr() string {
+	return f.listener.Addr().String()
+}
+
+func (f *fakeRedis) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeRedis) handle(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	authed := f.password == ""
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+
+		f.mu.Lock()
+		f.commands = append(f.commands, args)
+		f.mu.Unlock()
+
+		var reply string
+		switch strings.ToUpper(args[0]) {
+		case "AUTH":
+			if args[1] != f.password {
+				reply = "-WRONGPASS invalid password\r\n"
+			} else {
+				authed = true
+				reply = "+OK\r\n"
+			}
+		case "SELECT":
+			reply = "+OK\r\n"
+		case "EVALSHA", "EVAL":
+			reply = f.eval(authed, args)
+		default:
+			reply = fmt.Sprintf("-ERR unknown command '%s'\r\n", args[0])
+		}
+		_, err = io.WriteString(conn, reply)
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (f *fakeRedis) eval(authed bool, args []string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !authed {
+		return "-NOAUTH Authentication required.\r\n"
+	}
+	if f.evalError != "" {
+		return "-" + f.evalError + "\r\n"
+	}
+	if strings.ToUpper(args[0]) == "EVALSHA" {
+		if !f.loaded || args[1] != tokenBucketScriptSHA {
+			return "-NOSCRIPT No matching script. Please use EVAL.\r\n"
+		}
+	} else {
+		if args[1] != tokenBucketScript {
+			return "-ERR unexpected script\r\n"
+		}
+		f.loaded = true
+	}
+
+	key := args[3]
+	capacity, _ := strconv.Atoi(args[4])
+	if f.taken[key] >= capacity {
+		return "*3\r\n:0\r\n:0\r\n:1500\r\n"
+	}
+	f.taken[key]++
+	return fmt.Sprintf("*3\r\n:1\r\n:%d\r\n:250\r\n", capacity-f.taken[key])
+}
+
+func (f *fakeRedis) commandNames() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	names := make([]string, len(f.commands))
+	for i, args := range f.commands {
+		names[i] = args[0]
+	}
+	return names
+}
+
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(line[1:], "\r\n"))
+	if err != nil || line[0] != '*' {
+		return nil, fmt.Errorf("expected an array, got %q", line)
+	}
+	args := make([]string, n)
+	for i := range args {
+		line, err = reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSuffix(line[1:], "\r\n"))
+		if err != nil || line[0] != '$' {
+			return nil, fmt.Errorf("expected a bulk string, got %q", line)
+		}
+		buf := make([]byte, size+2)
+		_, err = io.ReadFull(reader, buf)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+var testPolicy = RateLimitPolicy{Name: "login", Limit: 2, Window: time.Minute, KeyBy: KeyByIP}
+
+func TestRedisStoreTakesUntilTheBucketIsEmpty(t *testing.T) {
+	server := newFakeRedis(t, "secret")
+	store := NewRedisRateLimitStore(RedisRateLimitOptions{Addr: server.addr(), Password: "secret", DB: 2})
+	defer store.Close()
+
+	ctx := context.Background()
+	for i, wantRemaining := range []int{1, 0} {
+		result, err := store.Take(ctx, "login|ip:192.0.2.1", testPolicy)
+		if err != nil {
+			t.Fatalf("Take %d: %v", i, err)
+		}
+		if !result.Allowed || result.Remaining != wantRemaining || result.RetryIn != 250*time.Millisecond {
+			t.Errorf("Take %d = %+v, want allowed with %d remaining", i, result, wantRemaining)
+		}
+	}
+
+	result, err := store.Take(ctx, "login|ip:192.0.2.1", testPolicy)
+	if err != nil {
+		t.Fatalf("Take: %v", err)
+	}
+	if result.Allowed || result.RetryIn != 1500*time.Millisecond {
+		t.Errorf("Take on an empty bucket = %+v, want denied for 1.5s", result)
+	}
+
+	// one connection, authenticated once, that loads the script on the
+	// first NOSCRIPT and reuses its hash afterwards
+	want := []string{"AUTH", "SELECT", "EVALSHA", "EVAL", "EVALSHA", "EVALSHA"}
+	if got := server.commandNames(); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("commands = %v, want %v", got, want)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.taken["ratelimit:login|ip:192.0.2.1"] != 2 {
+		t.Errorf("buckets = %v, want the key under the ratelimit: prefix", server.taken)
+	}
+}
+
+func TestRedisStoreKeepsTheConnectionAfterAnErrorReply(t *testing.T) {
+	server := newFakeRedis(t, "")
+	server.evalError = "BUSY Redis is busy running a script"
+	store := NewRedisRateLimitStore(RedisRateLimitOptions{Addr: server.addr()})
+	defer store.Close()
+
+	ctx := context.Background()
+	_, err := store.Take(ctx, "k", testPolicy)
+	if err == nil || !strings.Contains(err.Error(), "BUSY") {
+		t.Fatalf("Take = %v, want the BUSY error", err)
+	}
+
+	server.mu.Lock()
+	server.evalError = ""
+	server.mu.Unlock()
+
+	result, err := store.Take(ctx, "k", testPolicy)
+	if err != nil || !result.Allowed {
+		t.Fatalf("Take after the error = %+v, %v", result, err)
+	}
+	if len(store.pool) != 1 {
+		t.Errorf("pool holds %d connections, want the one connection back", len(store.pool))
+	}
+}
+
+func TestRedisStoreWrongPassword(t *testing.T) {
+	server := newFakeRedis(t, "secret")
+	store := NewRedisRateLimitStore(RedisRateLimitOptions{Addr: server.addr(), Password: "guess"})
+	defer store.Close()
+
+	_, err := store.Take(context.Background(), "k", testPolicy)
+	if err == nil || !strings.Contains(err.Error(), "WRONGPASS") {
+		t.Fatalf("Take = %v, want the WRONGPASS error", err)
+	}
+}
+
+func TestRedisStoreUnreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	store := NewRedisRateLimitStore(RedisRateLimitOptions{Addr: addr, Timeout: 100 * time.Millisecond})
+	defer store.Close()
+
+	_, err = store.Take(context.Background(), "k", testPolicy)
+	if err == nil {
+		t.Fatal("Take against a closed port succeeded")
+	}
+}
@@ -0,0 +1,128 @@
+package middlewares
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+)
+
+const rateLimiterShards = 32
+
+type RateLimitResult struct {
+	Allowed   bool
+	Remaining int
+	// RetryIn is how long until the bucket holds another token
+	RetryIn time.Duration
+}
+
+// RateLimitStore keeps the token buckets. Stores shared between API
+// instances make the limits hold across replicas.
+type RateLimitStore interface {
+	// Take removes a token from the bucket under key, creating a full
+	// bucket for policy when there is none yet
+	Take(ctx context.Context, key string, policy RateLimitPolicy) (RateLimitResult, error)
+	Close() error
+}
+
+type bucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+type limiterShard struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// memoryRateLimitStore is a process-local store, buckets are spread over
+// shards so requests for different keys rarely wait on the same lock
+type memoryRateLimitStore struct {
+	shards      [rateLimiterShards]*limiterShard
+	idleTimeout time.Duration
+	stop        chan struct{}
+	stopOnce    sync.Once
+}
+
+func NewMemoryRateLimitStore(idleTimeout time.Duration) *memoryRateLimitStore {
+	if idleTimeout <= 0 {
+		idleTimeout = 10 * time.Minute
+	}
+	s := &memoryRateLimitStore{
+		idleTimeout: idleTimeout,
+		stop:        make(chan struct{}),
+	}
+	for i := range s.shards {
+		s.shards[i] = &limiterShard{buckets: make(map[string]*bucket)}
+	}
+	go s.evictIdle()
+	return s
+}
+
+func (s *memoryRateLimitStore) Close() error {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+	return nil
+}
+
+func (s *memoryRateLimitStore) evictIdle() {
+	ticker := time.NewTicker(s.idleTimeout)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			for _, shard := range s.shards {
+				shard.mu.Lock()
+				for key, b := range shard.buckets {
+					if now.Sub(b.lastSeen) > s.idleTimeout {
+						delete(shard.buckets, key)
+					}
+				}
+				shard.mu.Unlock()
+			}
+		}
+	}
+}
+
+func (s *memoryRateLimitStore) shardFor(key string) *limiterShard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return s.shards[h.Sum32()%rateLimiterShards]
+}
+
+func (s *memoryRateLimitStore) Take(_ context.Context, key string, policy RateLimitPolicy) (RateLimitResult, error) {
+	capacity := float64(policy.capacity())
+	refillRate := policy.refillRate()
+	now := time.Now()
+
+	shard := s.shardFor(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	b, ok := shard.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, lastSeen: now}
+		shard.buckets[key] = b
+	}
+
+	b.tokens = math.Min(capacity, b.tokens+now.Sub(b.lastSeen).Seconds()*refillRate)
+	b.lastSeen = now
+
+	if b.tokens < 1 {
+		return RateLimitResult{
+			RetryIn: time.Duration((1 - b.tokens) / refillRate * float64(time.Second)),
+		}, nil
+	}
+	b.tokens--
+
+	return RateLimitResult{
+		Allowed:   true,
+		Remaining: int(b.tokens),
+		RetryIn:   time.Duration((1 - math.Mod(b.tokens, 1)) / refillRate * float64(time.Second)),
+	}, nil
+}
@@ -1,49 +1,157 @@
 package middlewares
 
 import (
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
-	"sync"
+	"restapi/internal/logging"
+	"restapi/pkg/utils"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 )
 
+const (
+	// KeyByIP limits each client address separately
+	KeyByIP = "ip"
+	// KeyByUser limits each logged in exec separately, and falls back to the
+	// client address for anonymous requests
+	KeyByUser = "user"
+)
+
+// RateLimitPolicy is a token bucket holding Burst tokens that refills at
+// Limit tokens per Window
+type RateLimitPolicy struct {
+	Name   string
+	Limit  int
+	Window time.Duration
+	Burst  int
+	KeyBy  string
+}
+
+func (p RateLimitPolicy) capacity() int {
+	if p.Burst > 0 {
+		return p.Burst
+	}
+	return p.Limit
+}
+
+// refillRate is the number of tokens added back per second
+func (p RateLimitPolicy) refillRate() float64 {
+	return float64(p.Limit) / p.Window.Seconds()
+}
+
+// RouteRateLimit applies Policy to requests whose path starts with Prefix
+// and, when Methods is set, whose method is one of Methods
+type RouteRateLimit struct {
+	Prefix  string
+	Methods []string
+	Policy  RateLimitPolicy
+}
+
+// RateLimitOptions configures the limiter. Store defaults to an in-memory
+// store that forgets buckets idle for longer than IdleTimeout.
+type RateLimitOptions struct {
+	Default     RateLimitPolicy
+	Routes      []RouteRateLimit
+	IdleTimeout time.Duration
+	Store       RateLimitStore
+}
+
 type rateLimiter struct {
-	mu sync.Mutex
-	visitors map[string] int
-	limit int
-	resetTime time.Duration
+	options RateLimitOptions
+	store   RateLimitStore
+	// fallback takes over while a shared store fails, the limits then hold
+	// per instance rather than not at all
+	fallback RateLimitStore
 }
 
-func NewRateLimiter(limit int, resetTime time.Duration) *rateLimiter {
+func NewRateLimiter(options RateLimitOptions) *rateLimiter {
 	rl := &rateLimiter{
-		visitors: make(map[string]int),
-		limit: limit,
-		resetTime: resetTime,
+		options: options,
+		store:   options.Store,
+	}
+	if rl.store == nil {
+		rl.store = NewMemoryRateLimitStore(options.IdleTimeout)
+	} else {
+		rl.fallback = NewMemoryRateLimitStore(options.IdleTimeout)
 	}
-	go rl.resetVisitorCount()
 	return rl
 }
 
-func (rl *rateLimiter) resetVisitorCount () {
-	for {
-		time.Sleep(rl.resetTime)
-		rl.mu.Lock()
-		rl.visitors = make(map[string]int)
-		rl.mu.Unlock()
+// Stop releases the stores, ending their background work
+func (rl *rateLimiter) Stop() error {
+	err := rl.store.Close()
+	if rl.fallback != nil {
+		err = errors.Join(err, rl.fallback.Close())
+	}
+	return err
+}
+
+func (rl *rateLimiter) policyFor(r *http.Request) RateLimitPolicy {
+	for _, route := range rl.options.Routes {
+		if !strings.HasPrefix(r.URL.Path, route.Prefix) {
+			continue
+		}
+		if len(route.Methods) > 0 && !slices.Contains(route.Methods, r.Method) {
+			continue
+		}
+		return route.Policy
+	}
+	return rl.options.Default
+}
+
+// take draws a token for key, from the fallback store while the shared one
+// fails
+func (rl *rateLimiter) take(r *http.Request, key string, policy RateLimitPolicy) (RateLimitResult, error) {
+	result, err := rl.store.Take(r.Context(), key, policy)
+	if err != nil && rl.fallback != nil {
+		logging.FromContext(r.Context()).Warn("rate limit store unavailable, limiting in memory", "policy", policy.Name, "err", err)
+		rateLimitFallbacksTotal.Inc(policy.Name)
+		result, err = rl.fallback.Take(r.Context(), key, policy)
 	}
+	return result, err
 }
 
 func (rl *rateLimiter) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		rl.mu.Lock()
-		defer rl.mu.Unlock()
+		policy := rl.policyFor(r)
+		if policy.Limit <= 0 || policy.Window <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
 
-		visitorIP := r.RemoteAddr
-		rl.visitors[visitorIP]++
+		key := policy.Name + "|" + rateLimitIdentity(r, policy.KeyBy)
+		result, err := rl.take(r, key, policy)
+		if err != nil {
+			// requests aren't let through unlimited, login and the password
+			// reset endpoints would be open to guessing
+			logging.FromContext(r.Context()).Error("rate limiting failed", "policy", policy.Name, "err", err)
+			http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+			return
+		}
 
-		if rl.visitors[visitorIP] > rl.limit {
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(policy.capacity()))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(result.RetryIn.Seconds()))))
+
+		if !result.Allowed {
+			rateLimitRejectionsTotal.Inc(policy.Name)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(result.RetryIn.Seconds()))))
 			http.Error(w, "Too many requests", http.StatusTooManyRequests)
 			return
 		}
 		next.ServeHTTP(w, r)
 	})
-}
\ No newline at end of file
+}
+
+func rateLimitIdentity(r *http.Request, keyBy string) string {
+	if keyBy == KeyByUser {
+		if userId := r.Context().Value(utils.ContextKey("userId")); userId != nil {
+			return fmt.Sprintf("user:%v", userId)
+		}
+	}
+	return "ip:" + ClientIP(r)
+}
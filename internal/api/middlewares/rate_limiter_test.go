@@ -0,0 +1,49 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type failingRateLimitStore struct{}
+
+func (failingRateLimitStore) Take(context.Context, string, RateLimitPolicy) (RateLimitResult, error) {
+	return RateLimitResult{}, errors.New("connection refused")
+}
+
+func (failingRateLimitStore) Close() error {
+	return nil
+}
+
+func TestRateLimiterFallsBackToMemoryWhenTheStoreFails(t *testing.T) {
+	rl := NewRateLimiter(RateLimitOptions{
+		Default: RateLimitPolicy{Name: "default", Limit: 100, Window: time.Minute, KeyBy: KeyByIP},
+		Routes: []RouteRateLimit{
+			{Prefix: "/execs/login", Policy: RateLimitPolicy{Name: "login", Limit: 2, Window: time.Minute, KeyBy: KeyByIP}},
+		},
+		Store: failingRateLimitStore{},
+	})
+	defer rl.Stop()
+
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	var codes []int
+	for range 3 {
+		req := httptest.NewRequest(http.MethodPost, "/execs/login", nil)
+		req.RemoteAddr = "192.0.2.1:5000"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		codes = append(codes, rec.Code)
+	}
+
+	want := []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}
+	for i := range want {
+		if codes[i] != want[i] {
+			t.Fatalf("status codes = %v, want %v", codes, want)
+		}
+	}
+}
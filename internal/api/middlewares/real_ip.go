@@ -0,0 +1,177 @@
+package middlewares
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"restapi/pkg/utils"
+	"strings"
+)
+
+// the forwarding headers RealIP can read the client address from
+const (
+	HeaderForwarded     = "forwarded"
+	HeaderXForwardedFor = "x-forwarded-for"
+	HeaderXRealIP       = "x-real-ip"
+)
+
+// RealIPOptions lists the proxies whose forwarding header is believed.
+// TrustedProxies takes CIDRs ("10.0.0.0/8") or single addresses. Header is
+// the one header the proxies set, X-Forwarded-For by default; the others are
+// ignored since a client can send them through the proxy untouched.
+type RealIPOptions struct {
+	TrustedProxies []string
+	Header         string
+}
+
+// RealIP stores the client address in the request context. The forwarding
+// header is only looked at when the immediate peer is a trusted proxy,
+// otherwise anybody could pick their own address.
+func RealIP(options RealIPOptions) (func(http.Handler) http.Handler, error) {
+	header := strings.ToLower(options.Header)
+	switch header {
+	case "":
+		header = HeaderXForwardedFor
+	case HeaderForwarded, HeaderXForwardedFor, HeaderXRealIP:
+	default:
+		return nil, fmt.Errorf("unknown forwarding header %q", options.Header)
+	}
+
+	trusted, err := parsePrefixes(options.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trusted proxy %w", err)
+	}
+
+	isTrusted := func(addr netip.Addr) bool {
+		return prefixesContain(trusted, addr)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			clientIP := peerIP(r)
+
+			peer, err := netip.ParseAddr(clientIP)
+			if err == nil && isTrusted(peer) {
+				clientIP = forwardedClientIP(forwardedHops(r, header), isTrusted, clientIP)
+			}
+
+			ctx := context.WithValue(r.Context(), utils.ContextKey("clientIP"), clientIP)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}, nil
+}
+
+// parsePrefixes reads CIDRs ("10.0.0.0/8") and single addresses
+func parsePrefixes(networks []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, network := range networks {
+		network = strings.TrimSpace(network)
+		if network == "" {
+			continue
+		}
+		if !strings.Contains(network, "/") {
+			addr, err := netip.ParseAddr(network)
+			if err != nil {
+				return nil, fmt.Errorf("%q: %w", network, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(network)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", network, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func prefixesContain(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address resolved by RealIP, or the peer address when
+// the middleware didn't run
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(utils.ContextKey("clientIP")).(string); ok && ip != "" {
+		return ip
+	}
+	return peerIP(r)
+}
+
+// peerIP strips the ephemeral port from the peer address
+func peerIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// forwardedClientIP walks the proxy chain from the nearest hop outwards and
+// returns the first address that isn't one of our proxies
+func forwardedClientIP(hops []string, isTrusted func(netip.Addr) bool, fallback string) string {
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(hops[i])
+		if err != nil {
+			// obfuscated identifiers and "unknown" end the chain we can vouch for
+			break
+		}
+		if !isTrusted(addr) || i == 0 {
+			return addr.Unmap().String()
+		}
+	}
+	return fallback
+}
+
+// forwardedHops lists the addresses of header, client first
+func forwardedHops(r *http.Request, header string) []string {
+	var hops []string
+	switch header {
+	case HeaderForwarded:
+		for _, element := range strings.Split(strings.Join(r.Header.Values("Forwarded"), ","), ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if found && strings.EqualFold(key, "for") {
+					hops = append(hops, stripPort(strings.Trim(value, `"`)))
+				}
+			}
+		}
+	case HeaderXForwardedFor:
+		if values := r.Header.Values("X-Forwarded-For"); len(values) > 0 {
+			for _, hop := range strings.Split(strings.Join(values, ","), ",") {
+				hops = append(hops, stripPort(strings.TrimSpace(hop)))
+			}
+		}
+	case HeaderXRealIP:
+		if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); realIP != "" {
+			hops = append(hops, stripPort(realIP))
+		}
+	}
+	return hops
+}
+
+// stripPort handles "1.2.3.4:80", "[2001:db8::1]:80" and bare addresses
+func stripPort(hop string) string {
+	if strings.HasPrefix(hop, "[") {
+		end := strings.Index(hop, "]")
+		if end > 0 {
+			return hop[1:end]
+		}
+		return hop
+	}
+	if strings.Count(hop, ":") == 1 {
+		host, _, err := net.SplitHostPort(hop)
+		if err == nil {
+			return host
+		}
+	}
+	return hop
+}
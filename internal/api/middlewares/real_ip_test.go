@@ -0,0 +1,68 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRealIPReadsOnlyTheConfiguredHeader(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{HeaderXForwardedFor, "198.51.100.7"},
+		{HeaderForwarded, "203.0.113.9"},
+		{HeaderXRealIP, "192.0.2.44"},
+	}
+	for _, test := range tests {
+		realIP, err := RealIP(RealIPOptions{TrustedProxies: []string{"10.0.0.0/8"}, Header: test.header})
+		if err != nil {
+			t.Fatalf("RealIP(%q): %v", test.header, err)
+		}
+
+		var got string
+		handler := realIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = ClientIP(r)
+		}))
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "10.0.0.2:4000"
+		req.Header.Set("X-Forwarded-For", "198.51.100.7, 10.0.0.3")
+		req.Header.Set("Forwarded", `for=203.0.113.9;proto=https, for="10.0.0.3"`)
+		req.Header.Set("X-Real-IP", "192.0.2.44")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		if got != test.want {
+			t.Errorf("with %s the client is %q, want %q", test.header, got, test.want)
+		}
+	}
+}
+
+func TestRealIPIgnoresHeadersFromUntrustedPeers(t *testing.T) {
+	realIP, err := RealIP(RealIPOptions{TrustedProxies: []string{"10.0.0.0/8"}})
+	if err != nil {
+		t.Fatalf("RealIP: %v", err)
+	}
+
+	var got string
+	handler := realIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = ClientIP(r)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "192.0.2.1:4000"
+	req.Header.Set("X-Forwarded-For", "198.51.100.7")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got != "192.0.2.1" {
+		t.Errorf("client = %q, want the peer address", got)
+	}
+}
+
+func TestRealIPRejectsUnknownHeader(t *testing.T) {
+	_, err := RealIP(RealIPOptions{Header: "x-client-ip"})
+	if err == nil {
+		t.Fatal("RealIP accepted an unknown header")
+	}
+}
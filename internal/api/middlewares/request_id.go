@@ -0,0 +1,145 @@
+package middlewares
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"restapi/internal/logging"
+	"strings"
+)
+
+const maxRequestIDLength = 128
+
+// RequestID takes the X-Request-ID sent by the client or a proxy in front of
+// us, or makes one up, and echoes it on the response. Plain text error
+// responses are turned into a JSON envelope carrying the id, so a client
+// reporting an error can point at the matching log lines.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get("X-Request-ID")
+		if !isValidRequestID(requestID) {
+			requestID = newRequestID()
+		}
+		r.Header.Set("X-Request-ID", requestID)
+		w.Header().Set("X-Request-ID", requestID)
+
+		ew := &errorEnvelopeWriter{ResponseWriter: w, requestID: requestID}
+		defer ew.close()
+
+		next.ServeHTTP(ew, r.WithContext(logging.WithRequestID(r.Context(), requestID)))
+	})
+}
+
+// isValidRequestID accepts ids that are safe to log and to put in a SQL
+// comment, anything else is replaced
+func isValidRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range requestID {
+		isSafe := c == '-' || c == '_' || c == '.' ||
+			c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+		if !isSafe {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+type errorEnvelope struct {
+	Status    string `json:"status"`
+	Error     string `json:"error"`
+	RequestID string `json:"request_id"`
+}
+
+// errorEnvelopeWriter holds back the body of http.Error responses and
+// rewrites them as JSON once the handler is done
+type errorEnvelopeWriter struct {
+	http.ResponseWriter
+	requestID   string
+	wroteHeader bool
+	buffering   bool
+	status      int
+	buf         []byte
+}
+
+func (ew *errorEnvelopeWriter) WriteHeader(code int) {
+	if ew.wroteHeader {
+		return
+	}
+	if code < http.StatusOK {
+		ew.ResponseWriter.WriteHeader(code)
+		return
+	}
+	ew.wroteHeader = true
+
+	header := ew.Header()
+	if code >= http.StatusBadRequest && header.Get("Content-Encoding") == "" &&
+		strings.HasPrefix(header.Get("Content-Type"), "text/plain") {
+		ew.buffering = true
+		ew.status = code
+		return
+	}
+	ew.ResponseWriter.WriteHeader(code)
+}
+
+func (ew *errorEnvelopeWriter) Write(b []byte) (int, error) {
+	if !ew.wroteHeader {
+		ew.WriteHeader(http.StatusOK)
+	}
+	if ew.buffering {
+		ew.buf = append(ew.buf, b...)
+		return len(b), nil
+	}
+	return ew.ResponseWriter.Write(b)
+}
+
+func (ew *errorEnvelopeWriter) Flush() {
+	if ew.buffering {
+		return
+	}
+	if !ew.wroteHeader {
+		ew.WriteHeader(http.StatusOK)
+	}
+	http.NewResponseController(ew.ResponseWriter).Flush()
+}
+
+func (ew *errorEnvelopeWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(ew.ResponseWriter).Hijack()
+}
+
+func (ew *errorEnvelopeWriter) Unwrap() http.ResponseWriter {
+	return ew.ResponseWriter
+}
+
+func (ew *errorEnvelopeWriter) close() {
+	if !ew.buffering {
+		return
+	}
+
+	body, err := json.Marshal(errorEnvelope{
+		Status:    "error",
+		Error:     strings.TrimSpace(string(ew.buf)),
+		RequestID: ew.requestID,
+	})
+	if err != nil {
+		ew.ResponseWriter.WriteHeader(ew.status)
+		ew.ResponseWriter.Write(ew.buf)
+		return
+	}
+
+	header := ew.Header()
+	header.Del("Content-Length")
+	header.Set("Content-Type", "application/json")
+	ew.ResponseWriter.WriteHeader(ew.status)
+	ew.ResponseWriter.Write(body)
+}
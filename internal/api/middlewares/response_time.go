@@ -1,29 +0,0 @@
-package middlewares
-
-import (
-	"net/http"
-	"time"
-)
-
-func ResponsetimeMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request){
-		start := time.Now()
-
-		wrappedWriter := &responseWriter{ResponseWriter: w, status: http.StatusOK}
-
-		duration := time.Since(start)
-		wrappedWriter.Header().Set("X-Response-Time", duration.String())
-
-		next.ServeHTTP(wrappedWriter, r)
-	}) 
-}
-
-type responseWriter struct {
-	http.ResponseWriter
-	status int
-}
-
-func (rw *responseWriter) WriteHeader(code int) {
-	rw.status = code
-	rw.ResponseWriter.WriteHeader(code)
-}
\ No newline at end of file
@@ -3,153 +3,324 @@ package middlewares
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"mime"
 	"net/http"
 	"net/url"
+	"restapi/internal/logging"
 	"restapi/pkg/utils"
+	"slices"
 	"strings"
 
 	"github.com/microcosm-cc/bluemonday"
+	"golang.org/x/net/html"
 )
 
-func XSSMiddleware(next http.Handler) http.Handler {
-	fmt.Println("**************Initialiozing XSSMiddleware")
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		fmt.Println("************ XSSMiddleware ran")
+// Sanitization policies
+const (
+	// PolicyStrict strips every complete tag but leaves text such as
+	// "a < b", "a<b@x.com" or "Smith & Sons" as it was
+	PolicyStrict = "strict"
+	// PolicyUGC keeps the formatting markup bluemonday deems safe, for
+	// values rendered as HTML
+	PolicyUGC = "ugc"
+	// PolicyNone leaves the value alone, for secrets and tokens
+	PolicyNone = "none"
+)
 
-		sanitizedPath, err := clean(r.URL.Path)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-		fmt.Println("Original Path:", r.URL.Path)
-		fmt.Println("Sanitized Path:", sanitizedPath)
-
-		params := r.URL.Query()
-		sanitizedQuery := make(map[string][]string)
-		for key, values := range params {
-			sanitizedKey, err := clean(key)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
+// SanitizeOptions configures Sanitize. JSON and form bodies and query
+// values are sanitized; the AllowedContentTypes bodies only get the size
+// limit. The path is left to the router, its wildcards are matched and
+// decoded there.
+type SanitizeOptions struct {
+	// DefaultPolicy applies to every value without a more specific policy
+	DefaultPolicy string
+	// Fields sets the policy of a JSON, form or query field by name,
+	// wherever it appears in the body; nested values inherit it
+	Fields map[string]string
+	// MaxBodyBytes answers larger bodies with 413
+	MaxBodyBytes int64
+	// AllowedContentTypes are accepted besides JSON and forms, e.g.
+	// multipart/form-data for uploads or text/csv for imports
+	AllowedContentTypes []string
+	// Routes override the options for the paths starting with their prefix,
+	// the first matching route wins
+	Routes []RouteSanitizePolicy
+}
+
+// RouteSanitizePolicy overrides SanitizeOptions for one route. Empty
+// settings keep the global ones and Fields are merged over the global ones.
+type RouteSanitizePolicy struct {
+	Prefix              string
+	Methods             []string
+	DefaultPolicy       string
+	Fields              map[string]string
+	MaxBodyBytes        int64
+	AllowedContentTypes []string
+}
+
+var DefaultSanitizeOptions = SanitizeOptions{
+	DefaultPolicy: PolicyStrict,
+	Fields: map[string]string{
+		"password":         PolicyNone,
+		"current_password": PolicyNone,
+		"new_password":     PolicyNone,
+	},
+	MaxBodyBytes:        1 << 20,
+	AllowedContentTypes: []string{"multipart/form-data", "text/csv"},
+}
+
+var ugcPolicy = bluemonday.UGCPolicy()
+
+var sanitizers = map[string]func(string) string{
+	PolicyStrict: stripTags,
+	PolicyUGC:    ugcPolicy.Sanitize,
+	PolicyNone:   func(value string) string { return value },
+}
+
+// stripTags drops the markup in value and keeps the text between tags byte
+// for byte. Entities stay encoded, decoding them would turn
+// "&lt;script&gt;" into the very markup being stripped. Script and style
+// contents go with their tags; a "<" that never closes into a tag is text.
+func stripTags(value string) string {
+	if !strings.Contains(value, "<") {
+		return value
+	}
+
+	var stripped strings.Builder
+	tokenizer := html.NewTokenizer(strings.NewReader(value))
+	skipping := ""
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			// at the end of the value Raw holds what couldn't be read as a
+			// token, an unfinished tag like the "<b@x.com" of "a<b@x.com"
+			if skipping == "" {
+				stripped.Write(tokenizer.Raw())
+			}
+			return stripped.String()
+		case html.TextToken:
+			if skipping == "" {
+				stripped.Write(tokenizer.Raw())
 			}
+		case html.StartTagToken:
+			name, _ := tokenizer.TagName()
+			if tag := string(name); tag == "script" || tag == "style" {
+				skipping = tag
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			if string(name) == skipping {
+				skipping = ""
+			}
+		}
+	}
+}
 
-			var sanitizedValues []string
-			for _, value := range values {
-				cleanValue, err := clean(value)
-				if err != nil {
-					http.Error(w, err.Error(), http.StatusBadRequest)
-					return
+// sanitizePolicy is the resolved set of options for one request
+type sanitizePolicy struct {
+	defaultPolicy       string
+	fields              map[string]string
+	maxBodyBytes        int64
+	allowedContentTypes []string
+}
+
+func (p sanitizePolicy) forField(name, inherited string) string {
+	if policy, ok := p.fields[name]; ok {
+		return policy
+	}
+	return inherited
+}
+
+func Sanitize(options SanitizeOptions) (func(http.Handler) http.Handler, error) {
+	err := validateSanitizePolicies(options)
+	if err != nil {
+		return nil, err
+	}
+
+	defaults := sanitizePolicy{
+		defaultPolicy:       options.DefaultPolicy,
+		fields:              options.Fields,
+		maxBodyBytes:        options.MaxBodyBytes,
+		allowedContentTypes: options.AllowedContentTypes,
+	}
+	if defaults.defaultPolicy == "" {
+		defaults.defaultPolicy = PolicyStrict
+	}
+
+	routes := make([]sanitizePolicy, len(options.Routes))
+	for i, route := range options.Routes {
+		policy := defaults
+		if route.DefaultPolicy != "" {
+			policy.defaultPolicy = route.DefaultPolicy
+		}
+		if route.MaxBodyBytes > 0 {
+			policy.maxBodyBytes = route.MaxBodyBytes
+		}
+		if route.AllowedContentTypes != nil {
+			policy.allowedContentTypes = route.AllowedContentTypes
+		}
+		policy.fields = make(map[string]string, len(defaults.fields)+len(route.Fields))
+		for name, fieldPolicy := range defaults.fields {
+			policy.fields[name] = fieldPolicy
+		}
+		for name, fieldPolicy := range route.Fields {
+			policy.fields[name] = fieldPolicy
+		}
+		routes[i] = policy
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			policy := defaults
+			for i, route := range options.Routes {
+				if strings.HasPrefix(r.URL.Path, route.Prefix) && (len(route.Methods) == 0 || slices.Contains(route.Methods, r.Method)) {
+					policy = routes[i]
+					break
 				}
-				sanitizedValues = append(sanitizedValues, cleanValue.(string))
 			}
 
-			sanitizedQuery[sanitizedKey.(string)] = sanitizedValues
-			fmt.Printf("Original Query %s: %s\n", key, strings.Join(values, ". "))
-			fmt.Printf("Sanitized Query %s: %s\n", sanitizedKey, strings.Join(sanitizedValues, ". "))
-		}
+			logger := logging.FromContext(r.Context())
 
-		r.URL.Path = sanitizedPath.(string)
-		r.URL.RawQuery = url.Values(sanitizedQuery).Encode()
-		fmt.Println("Updated URL:", r.URL.String())
+			if r.URL.RawQuery != "" {
+				r.URL.RawQuery = sanitizeForm(r.URL.Query(), policy).Encode()
+			}
+
+			if r.Body == nil || r.Body == http.NoBody {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if policy.maxBodyBytes > 0 {
+				r.Body = http.MaxBytesReader(w, r.Body, policy.maxBodyBytes)
+			}
 
-		if r.Header.Get("Content-Type") == "application/json" {
-			if r.Body != nil {
-				bodyBytes, err := io.ReadAll(r.Body)
+			contentType := r.Header.Get("Content-Type")
+			mediaType, _, _ := mime.ParseMediaType(contentType)
+			switch {
+			case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+				body, ok := readBody(w, r)
+				if !ok {
+					return
+				}
+				if len(bytes.TrimSpace(body)) == 0 {
+					logger.Debug("request body is empty")
+					setBody(r, nil)
+					break
+				}
+
+				decoder := json.NewDecoder(bytes.NewReader(body))
+				// numbers go back out exactly as they came in
+				decoder.UseNumber()
+				var data any
+				err := decoder.Decode(&data)
 				if err != nil {
-					http.Error(w, utils.ErrorHandler(err, "Error reading request body").Error(), http.StatusBadRequest)
+					http.Error(w, utils.ErrorHandler(err, "Invalid JSON body").Error(), http.StatusBadRequest)
 					return
 				}
 
-				bodyString := strings.TrimSpace(string(bodyBytes))
-				fmt.Println("Original Body:", bodyString)
-
-				r.Body = io.NopCloser(bytes.NewReader([]byte(bodyString)))
-
-				if len(bodyString) > 0{
-					var inputData interface{}
-					err := json.NewDecoder(bytes.NewReader([]byte(bodyString))).Decode(&inputData)
-					if err != nil {
-						http.Error(w, utils.ErrorHandler(err, "Invalid JSON body").Error(), http.StatusBadRequest)
-						return
-					}
-					fmt.Println("Original JSON data:", inputData)
-
-					//Saitize the json body
-					sanitizedData, err := clean(inputData)
-					if err != nil {
-						http.Error(w, err.Error(), http.StatusBadRequest)
-						return
-					}
-					fmt.Println("Sanitized JSON data:", sanitizedData)
-
-					sanitizedBody, err := json.Marshal(sanitizedData)
-					if err != nil {
-						http.Error(w, utils.ErrorHandler(err, "Error sanitizing body").Error(), http.StatusBadRequest)
-						return
-					}
-					r.Body = io.NopCloser(bytes.NewReader(sanitizedBody))
-					fmt.Println("Sanitized  body:", string(sanitizedBody))
-				} else {
-					log.Println("Request body is empty")
+				sanitized, err := json.Marshal(sanitizeJSON(data, policy.defaultPolicy, policy))
+				if err != nil {
+					http.Error(w, utils.ErrorHandler(err, "Error sanitizing body").Error(), http.StatusBadRequest)
+					return
+				}
+				setBody(r, sanitized)
+			case mediaType == "application/x-www-form-urlencoded":
+				body, ok := readBody(w, r)
+				if !ok {
+					return
 				}
-			} else {
-				log.Println("No body in the request")
+				form, err := url.ParseQuery(string(body))
+				if err != nil {
+					http.Error(w, "Invalid form body", http.StatusBadRequest)
+					return
+				}
+				setBody(r, []byte(sanitizeForm(form, policy).Encode()))
+			case slices.Contains(policy.allowedContentTypes, mediaType):
+				// passed on as it is, the handler parses it
+			case contentType == "":
+				logger.Debug("no content type, body left as it is")
+			default:
+				logger.Warn("unsupported content type", "content_type", contentType)
+				http.Error(w, "Unsupported content-type.", http.StatusUnsupportedMediaType)
+				return
 			}
-		} else if r.Header.Get("Content-Type") != "" {
-			log.Printf("Recieved request with unsupported Content-Type: %s. Expected application/json. \n", r.Header.Get("Content-Type"))
-			http.Error(w, "Unsupported content-type.", http.StatusUnsupportedMediaType)
-			return
-		}
 
-		next.ServeHTTP(w, r)
-		fmt.Println("************ sending response from XSSMiddleware ran")
-	})
+			next.ServeHTTP(w, r)
+		})
+	}, nil
 }
 
-// Clean sanitizes input data to prevent xss attacks
-func clean(data interface{}) (interface{}, error) {
-	switch v := data.(type) {
-	case map[string]interface{}:
-		for key, value:= range v {
-			v[key] = sanitizeValue(value)
+func validateSanitizePolicies(options SanitizeOptions) error {
+	var errs []error
+	check := func(policy, setting string) {
+		if _, ok := sanitizers[policy]; !ok && policy != "" {
+			errs = append(errs, fmt.Errorf("%s: unknown sanitize policy %q", setting, policy))
 		}
-		return v, nil
-	case []interface{}:
-		for i, value := range v {
-			v[i] = sanitizeValue(value)
+	}
+	check(options.DefaultPolicy, "default")
+	for name, policy := range options.Fields {
+		check(policy, "field "+name)
+	}
+	for _, route := range options.Routes {
+		check(route.DefaultPolicy, "route "+route.Prefix)
+		for name, policy := range route.Fields {
+			check(policy, "route "+route.Prefix+" field "+name)
 		}
-		return v, nil
-	case string:
-		return sanitizeString(v), nil
-	default:
-		// error
-		return nil, utils.ErrorHandler(fmt.Errorf("unsupported type: %T", data), fmt.Sprintf("unsupported type: %T", data))
 	}
+	return errors.Join(errs...)
 }
 
-func sanitizeValue (data interface{}) interface{} {
+// readBody answers 413 or 400 itself when the body can't be read
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return nil, false
+		}
+		http.Error(w, utils.ErrorHandler(err, "Error reading request body").Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return body, true
+}
+
+func setBody(r *http.Request, body []byte) {
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+}
+
+func sanitizeForm(values url.Values, policy sanitizePolicy) url.Values {
+	sanitized := make(url.Values, len(values))
+	for key, fieldValues := range values {
+		fieldPolicy := policy.forField(key, policy.defaultPolicy)
+		cleanKey := sanitizers[policy.defaultPolicy](key)
+		for _, value := range fieldValues {
+			sanitized[cleanKey] = append(sanitized[cleanKey], sanitizers[fieldPolicy](value))
+		}
+	}
+	return sanitized
+}
+
+// sanitizeJSON cleans every string in a decoded JSON value, numbers, bools
+// and nulls pass through
+func sanitizeJSON(data any, fieldPolicy string, policy sanitizePolicy) any {
 	switch v := data.(type) {
 	case string:
-		return sanitizeString(v)
-	case map[string]interface{}:
-		for k, value := range v {
-			v[k] = sanitizeValue(value)
+		return sanitizers[fieldPolicy](v)
+	case map[string]any:
+		for key, value := range v {
+			v[key] = sanitizeJSON(value, policy.forField(key, fieldPolicy), policy)
 		}
 		return v
-	case []interface{}:
+	case []any:
 		for i, value := range v {
-			v[i] = sanitizeValue(value)
+			v[i] = sanitizeJSON(value, fieldPolicy, policy)
 		}
-
 		return v
 	default:
 		return v
 	}
 }
-
-func sanitizeString(value string) string {
-	return bluemonday.UGCPolicy().Sanitize(value)
-}
\ No newline at end of file
@@ -0,0 +1,152 @@
+package middlewares
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStripTagsKeepsEntitiesEncoded(t *testing.T) {
+	tests := map[string]string{
+		"&lt;script&gt;alert(1)&lt;/script&gt;":     "&lt;script&gt;alert(1)&lt;/script&gt;",
+		"&#60;img src=x onerror=alert(1)&#62;":      "&#60;img src=x onerror=alert(1)&#62;",
+		"<b>Smith & Sons</b>":                       "Smith & Sons",
+		"a < b":                                     "a < b",
+		"<script>alert(1)</script>hello":            "hello",
+		"<style>p{}</style><p>one</p><p>two</p>":    "onetwo",
+		"<a href=\"javascript:alert(1)\">click</a>": "click",
+		"plain text":                                "plain text",
+		"x<y and more":                              "x<y and more",
+		"a<b@x.com":                                 "a<b@x.com",
+		"<script>alert(1)":                          "",
+	}
+	for value, want := range tests {
+		if got := stripTags(value); got != want {
+			t.Errorf("stripTags(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
+
+func TestSanitizeStrictBodyLeavesEncodedPayloadInert(t *testing.T) {
+	sanitize, err := Sanitize(DefaultSanitizeOptions)
+	if err != nil {
+		t.Fatalf("Sanitize: %v", err)
+	}
+
+	var body map[string]string
+	var path string
+	handler := sanitize(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+		path = r.URL.Path
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/execs/resetpassword/reset/<b>code</b>", strings.NewReader(`{"first_name":"&lt;img src=x onerror=alert(1)&gt;<i>Ada</i>"}`))
+	req.Header.Set("Content-Type", "application/json")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got := body["first_name"]; got != "&lt;img src=x onerror=alert(1)&gt;Ada" {
+		t.Errorf("sanitized first_name = %q, want the entities kept and the tags dropped", got)
+	}
+	if path != "/execs/resetpassword/reset/<b>code</b>" {
+		t.Errorf("path = %q, want it untouched", path)
+	}
+}
+
+// sanitizedRequest runs req through Sanitize with options and returns the
+// status and the body the handler was given
+func sanitizedRequest(t *testing.T, options SanitizeOptions, req *http.Request) (int, string) {
+	t.Helper()
+
+	sanitize, err := Sanitize(options)
+	if err != nil {
+		t.Fatalf("Sanitize: %v", err)
+	}
+	var body []byte
+	handler := sanitize(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec.Code, string(body)
+}
+
+func TestSanitizeLeavesPasswordsAlone(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/execs/login", strings.NewReader(`{"username":"<b>ada</b>","password":"p<a>ss&amp;<script>w</script>"}`))
+	req.Header.Set("Content-Type", "application/json")
+	code, body := sanitizedRequest(t, DefaultSanitizeOptions, req)
+	if code != http.StatusOK {
+		t.Fatalf("status = %d", code)
+	}
+
+	var fields map[string]string
+	json.Unmarshal([]byte(body), &fields)
+	if fields["username"] != "ada" {
+		t.Errorf("username = %q, want the tags stripped", fields["username"])
+	}
+	if fields["password"] != "p<a>ss&amp;<script>w</script>" {
+		t.Errorf("password = %q, want it untouched", fields["password"])
+	}
+}
+
+func TestSanitizeKeepsNumbersExact(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/students", strings.NewReader(`{"id":9007199254740993,"score":1.10,"class":"9A","tags":[1,2.5e3,null,true]}`))
+	req.Header.Set("Content-Type", "application/json")
+	_, body := sanitizedRequest(t, DefaultSanitizeOptions, req)
+
+	for _, number := range []string{"9007199254740993", "1.10", "2.5e3"} {
+		if !strings.Contains(body, number) {
+			t.Errorf("sanitized body %s lost the number %s", body, number)
+		}
+	}
+	if !strings.Contains(body, "null") || !strings.Contains(body, "true") {
+		t.Errorf("sanitized body %s lost the null or the bool", body)
+	}
+}
+
+func TestSanitizeBodySizeLimit(t *testing.T) {
+	options := DefaultSanitizeOptions
+	options.MaxBodyBytes = 16
+
+	req := httptest.NewRequest(http.MethodPost, "/students", strings.NewReader(`{"first_name":"a name far longer than sixteen bytes"}`))
+	req.Header.Set("Content-Type", "application/json")
+	code, _ := sanitizedRequest(t, options, req)
+	if code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want 413", code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/students", strings.NewReader(`{"a":"b"}`))
+	req.Header.Set("Content-Type", "application/json")
+	code, _ = sanitizedRequest(t, options, req)
+	if code != http.StatusOK {
+		t.Errorf("status of a small body = %d, want 200", code)
+	}
+}
+
+func TestSanitizePassesAllowedContentTypesThrough(t *testing.T) {
+	tests := []struct {
+		contentType string
+		body        string
+	}{
+		{"multipart/form-data; boundary=x", "--x\r\nContent-Disposition: form-data; name=\"f\"\r\n\r\n<b>kept</b>\r\n--x--\r\n"},
+		{"text/csv", "first_name,last_name\n<b>Ada</b>,Lovelace\n"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/students/import", strings.NewReader(test.body))
+		req.Header.Set("Content-Type", test.contentType)
+		code, body := sanitizedRequest(t, DefaultSanitizeOptions, req)
+		if code != http.StatusOK || body != test.body {
+			t.Errorf("%s: status %d, body %q, want 200 and the body untouched", test.contentType, code, body)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/students", bytes.NewReader([]byte("<xml/>")))
+	req.Header.Set("Content-Type", "application/xml")
+	code, _ := sanitizedRequest(t, DefaultSanitizeOptions, req)
+	if code != http.StatusUnsupportedMediaType {
+		t.Errorf("application/xml status = %d, want 415", code)
+	}
+}
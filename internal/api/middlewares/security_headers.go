@@ -1,27 +1,175 @@
 package middlewares
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
 	"net/http"
+	"restapi/pkg/utils"
+	"strconv"
+	"strings"
+	"time"
 )
 
-func SecurityHeaders(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request){
-		w.Header().Set("X-DNS-PreFetch-Control", "off")
-		w.Header().Set("X-Frame-Options", "DENY")
-		w.Header().Set("X-XSS-Protection", "1; mode=block")
-		w.Header().Set("X-Content-Type-Options",  "nosniff")
-		w.Header().Set("Strict-Transport-Security", "max-age=63072000; includeSubDomains; preload")
-		w.Header().Set("Content-Security-Policy", "default-src 'self'")
-		w.Header().Set("Referred-Policy", "no-referrer")
-		w.Header().Set("X-Powered-By", "Django")
-		w.Header().Set("Server", "")
-		w.Header().Set("X-Permitted-Cross-Domain-Policies", "none")
-		w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate, max-age-0")
-		w.Header().Set("Cross-Origin-Resource-Policy", "same-origin")
-		w.Header().Set("Cross-Origin-Opener-Policy", "same-origin")
-		w.Header().Set("Cross-Origin-Embedder-Policy", "require-corp")
-		w.Header().Set("Permissions-Policy", "geolocation=(self), microphone=()")
-
-		next.ServeHTTP(w, r)
-	})
-}
\ No newline at end of file
+// SecurityHeadersOptions configures SecurityHeaders. An empty value leaves
+// its header out. ContentSecurityPolicy may contain {nonce}, which is
+// replaced by a fresh nonce on every request, see CSPNonce.
+type SecurityHeadersOptions struct {
+	ContentSecurityPolicy string
+	// CSPReportOnly sends the policy as Content-Security-Policy-Report-Only,
+	// to try a policy out without breaking pages
+	CSPReportOnly bool
+	// CSPReportURI receives the violation reports, through both report-uri
+	// and the Reporting API (report-to)
+	CSPReportURI              string
+	HSTSMaxAge                time.Duration
+	HSTSIncludeSubdomains     bool
+	HSTSPreload               bool
+	FrameOptions              string
+	ReferrerPolicy            string
+	CacheControl              string
+	PermissionsPolicy         string
+	CrossOriginResourcePolicy string
+	CrossOriginOpenerPolicy   string
+	CrossOriginEmbedderPolicy string
+	// Routes override headers for the paths starting with their prefix, the
+	// first matching route wins
+	Routes []RouteSecurityHeaders
+}
+
+// RouteSecurityHeaders replaces headers by name for one route, e.g. a
+// Cache-Control for a public endpoint or a relaxed Content-Security-Policy
+// for an API docs page. An empty value removes the header.
+type RouteSecurityHeaders struct {
+	Prefix  string
+	Headers map[string]string
+}
+
+var DefaultSecurityHeadersOptions = SecurityHeadersOptions{
+	ContentSecurityPolicy:     "default-src 'none'; frame-ancestors 'none'",
+	CSPReportURI:              "/csp-reports",
+	HSTSMaxAge:                2 * 365 * 24 * time.Hour,
+	HSTSIncludeSubdomains:     true,
+	HSTSPreload:               true,
+	FrameOptions:              "DENY",
+	ReferrerPolicy:            "no-referrer",
+	CacheControl:              "no-store, max-age=0",
+	PermissionsPolicy:         "geolocation=(), microphone=(), camera=()",
+	CrossOriginResourcePolicy: "same-origin",
+	CrossOriginOpenerPolicy:   "same-origin",
+	CrossOriginEmbedderPolicy: "require-corp",
+}
+
+// the Reporting API group the CSP reports are sent to
+const cspReportGroup = "csp-endpoint"
+
+func SecurityHeaders(options SecurityHeadersOptions) func(http.Handler) http.Handler {
+	cspHeader := "Content-Security-Policy"
+	if options.CSPReportOnly {
+		cspHeader = "Content-Security-Policy-Report-Only"
+	}
+
+	headers := map[string]string{
+		"X-Content-Type-Options":            "nosniff",
+		"X-DNS-Prefetch-Control":            "off",
+		"X-Permitted-Cross-Domain-Policies": "none",
+		// the XSS auditor is gone from browsers and could be abused to leak
+		// data, CSP replaces it
+		"X-XSS-Protection":             "0",
+		"X-Frame-Options":              options.FrameOptions,
+		"Referrer-Policy":              options.ReferrerPolicy,
+		"Cache-Control":                options.CacheControl,
+		"Permissions-Policy":           options.PermissionsPolicy,
+		"Cross-Origin-Resource-Policy": options.CrossOriginResourcePolicy,
+		"Cross-Origin-Opener-Policy":   options.CrossOriginOpenerPolicy,
+		"Cross-Origin-Embedder-Policy": options.CrossOriginEmbedderPolicy,
+		cspHeader:                      options.ContentSecurityPolicy,
+	}
+	if options.HSTSMaxAge > 0 {
+		hsts := "max-age=" + strconv.Itoa(int(options.HSTSMaxAge.Seconds()))
+		if options.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if options.HSTSPreload {
+			hsts += "; preload"
+		}
+		headers["Strict-Transport-Security"] = hsts
+	}
+
+	defaults := resolveSecurityHeaders(headers, nil, cspHeader, options.CSPReportURI)
+	routes := make([]map[string]string, len(options.Routes))
+	for i, route := range options.Routes {
+		routes[i] = resolveSecurityHeaders(headers, route.Headers, cspHeader, options.CSPReportURI)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			resolved := defaults
+			for i, route := range options.Routes {
+				if strings.HasPrefix(r.URL.Path, route.Prefix) {
+					resolved = routes[i]
+					break
+				}
+			}
+
+			var nonce string
+			for name, value := range resolved {
+				// over plain HTTP the TLS-terminating proxy owns HSTS
+				if name == "Strict-Transport-Security" && r.TLS == nil {
+					continue
+				}
+				if strings.Contains(value, "{nonce}") {
+					if nonce == "" {
+						nonce = newCSPNonce()
+						r = r.WithContext(context.WithValue(r.Context(), utils.ContextKey("cspNonce"), nonce))
+					}
+					value = strings.ReplaceAll(value, "{nonce}", nonce)
+				}
+				w.Header().Set(name, value)
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// resolveSecurityHeaders applies a route's overrides to the defaults and
+// points the policy at the report endpoint
+func resolveSecurityHeaders(defaults, overrides map[string]string, cspHeader, reportURI string) map[string]string {
+	resolved := make(map[string]string, len(defaults))
+	for name, value := range defaults {
+		resolved[http.CanonicalHeaderKey(name)] = value
+	}
+	for name, value := range overrides {
+		name = http.CanonicalHeaderKey(name)
+		if name == "Content-Security-Policy" {
+			name = cspHeader
+		}
+		resolved[name] = value
+	}
+	for name, value := range resolved {
+		if value == "" {
+			delete(resolved, name)
+		}
+	}
+
+	if csp, ok := resolved[cspHeader]; ok && reportURI != "" {
+		resolved[cspHeader] = csp + "; report-uri " + reportURI + "; report-to " + cspReportGroup
+		resolved["Reporting-Endpoints"] = cspReportGroup + `="` + reportURI + `"`
+	}
+	return resolved
+}
+
+func newCSPNonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// CSPNonce returns the nonce of the Content-Security-Policy sent with the
+// response, for the inline scripts and styles of a page. It is empty when
+// the policy of the route has no {nonce}.
+func CSPNonce(r *http.Request) string {
+	nonce, _ := r.Context().Value(utils.ContextKey("cspNonce")).(string)
+	return nonce
+}
@@ -0,0 +1,165 @@
+package middlewares
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	options := SecurityHeadersOptions{
+		ContentSecurityPolicy: "default-src 'none'",
+		HSTSMaxAge:            365 * 24 * time.Hour,
+		HSTSIncludeSubdomains: true,
+		FrameOptions:          "DENY",
+		CacheControl:          "no-store",
+		Routes: []RouteSecurityHeaders{
+			{Prefix: "/docs", Headers: map[string]string{
+				"content-security-policy": "default-src 'self'",
+				"X-Frame-Options":         "",
+			}},
+			{Prefix: "/public", Headers: map[string]string{"Cache-Control": "public, max-age=60"}},
+			{Prefix: "/public/private", Headers: map[string]string{"Cache-Control": "private"}},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		tls     bool
+		want    map[string]string
+		missing []string
+	}{
+		{
+			name: "defaults over plain HTTP",
+			path: "/students",
+			want: map[string]string{
+				"Content-Security-Policy": "default-src 'none'",
+				"X-Frame-Options":         "DENY",
+				"Cache-Control":           "no-store",
+				"X-Content-Type-Options":  "nosniff",
+			},
+			missing: []string{"Strict-Transport-Security"},
+		},
+		{
+			name: "HSTS over TLS",
+			path: "/students",
+			tls:  true,
+			want: map[string]string{"Strict-Transport-Security": "max-age=31536000; includeSubDomains"},
+		},
+		{
+			name: "route replaces the policy and removes a header",
+			path: "/docs/index.html",
+			want: map[string]string{
+				"Content-Security-Policy": "default-src 'self'",
+				"Cache-Control":           "no-store",
+			},
+			missing: []string{"X-Frame-Options"},
+		},
+		{
+			name: "first matching route wins",
+			path: "/public/private/x",
+			want: map[string]string{"Cache-Control": "public, max-age=60"},
+		},
+	}
+	handler := SecurityHeaders(options)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, test.path, nil)
+		if test.tls {
+			req.TLS = &tls.ConnectionState{}
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		for name, want := range test.want {
+			if got := rec.Header().Get(name); got != want {
+				t.Errorf("%s: %s = %q, want %q", test.name, name, got, want)
+			}
+		}
+		for _, name := range test.missing {
+			if got, ok := rec.Header()[name]; ok {
+				t.Errorf("%s: %s = %q, want no header", test.name, name, got)
+			}
+		}
+	}
+}
+
+func TestSecurityHeadersReportOnly(t *testing.T) {
+	tests := []struct {
+		name       string
+		reportOnly bool
+		reportURI  string
+		header     string
+		want       string
+		endpoints  string
+	}{
+		{"enforced", false, "", "Content-Security-Policy", "default-src 'none'", ""},
+		{"report only", true, "", "Content-Security-Policy-Report-Only", "default-src 'none'", ""},
+		{"reports sent", true, "/csp-reports", "Content-Security-Policy-Report-Only",
+			"default-src 'none'; report-uri /csp-reports; report-to csp-endpoint", `csp-endpoint="/csp-reports"`},
+	}
+	for _, test := range tests {
+		handler := SecurityHeaders(SecurityHeadersOptions{
+			ContentSecurityPolicy: "default-src 'none'",
+			CSPReportOnly:         test.reportOnly,
+			CSPReportURI:          test.reportURI,
+		})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if got := rec.Header().Get(test.header); got != test.want {
+			t.Errorf("%s: %s = %q, want %q", test.name, test.header, got, test.want)
+		}
+		other := "Content-Security-Policy-Report-Only"
+		if test.reportOnly {
+			other = "Content-Security-Policy"
+		}
+		if got := rec.Header().Get(other); got != "" {
+			t.Errorf("%s: %s = %q, want no header", test.name, other, got)
+		}
+		if got := rec.Header().Get("Reporting-Endpoints"); got != test.endpoints {
+			t.Errorf("%s: Reporting-Endpoints = %q, want %q", test.name, got, test.endpoints)
+		}
+	}
+}
+
+func TestSecurityHeadersNonce(t *testing.T) {
+	var nonce string
+	handler := SecurityHeaders(SecurityHeadersOptions{
+		ContentSecurityPolicy: "script-src 'nonce-{nonce}'; style-src 'nonce-{nonce}'",
+		Routes: []RouteSecurityHeaders{
+			{Prefix: "/api", Headers: map[string]string{"Content-Security-Policy": "default-src 'none'"}},
+		},
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce = CSPNonce(r)
+	}))
+
+	seen := map[string]bool{}
+	for range 3 {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if nonce == "" {
+			t.Fatal("CSPNonce is empty for a policy with {nonce}")
+		}
+		if seen[nonce] {
+			t.Errorf("nonce %q was used twice", nonce)
+		}
+		seen[nonce] = true
+		want := "script-src 'nonce-" + nonce + "'; style-src 'nonce-" + nonce + "'"
+		if got := rec.Header().Get("Content-Security-Policy"); got != want {
+			t.Errorf("Content-Security-Policy = %q, want %q", got, want)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/students", nil))
+	if nonce != "" {
+		t.Errorf("CSPNonce = %q on a route without {nonce}, want empty", nonce)
+	}
+	if got := rec.Header().Get("Content-Security-Policy"); strings.Contains(got, "nonce") {
+		t.Errorf("Content-Security-Policy = %q, want the route's policy", got)
+	}
+}
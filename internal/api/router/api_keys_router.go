@@ -0,0 +1,19 @@
+package router
+
+import (
+	"net/http"
+	"restapi/internal/api/handlers"
+)
+
+func APIKeysRouter() *http.ServeMux {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /apikeys", handlers.GetAPIKeysHandler)
+	mux.HandleFunc("POST /apikeys", handlers.AddAPIKeyHandler)
+
+	mux.HandleFunc("GET /apikeys/{id}", handlers.GetAPIKeyHandler)
+	mux.HandleFunc("DELETE /apikeys/{id}", handlers.RevokeAPIKeyHandler)
+	mux.HandleFunc("POST /apikeys/{id}/rotate", handlers.RotateAPIKeyHandler)
+
+	return mux
+}
@@ -3,8 +3,21 @@ package router
 import (
 	"net/http"
 	"restapi/internal/api/handlers"
+	mw "restapi/internal/api/middlewares"
 )
 
+// execsParams are the query parameters of the exec list, filters on the
+// model's columns plus sorting
+var execsParams = []mw.RouteParams{
+	{Pattern: "GET /execs", Query: append([]mw.ParamSchema{
+		{Name: "first_name"},
+		{Name: "last_name"},
+		{Name: "email"},
+		{Name: "username"},
+		{Name: "role"},
+	}, sortParams...)},
+}
+
 func ExecsRouter() *http.ServeMux {
 	mux := http.NewServeMux()
 
@@ -16,11 +29,15 @@ func ExecsRouter() *http.ServeMux {
 	mux.HandleFunc("PATCH /execs/{id}", handlers.PatchExecHandler)
 	mux.HandleFunc("DELETE /execs/{id}", handlers.DeleteExecHandler)
 	mux.HandleFunc("POST /execs/{id}/updatepassword", handlers.UpdatePasswordHandler)
+	mux.HandleFunc("POST /execs/{id}/deactivate", handlers.DeactivateExecHandler)
+	mux.HandleFunc("POST /execs/{id}/reactivate", handlers.ReactivateExecHandler)
+	mux.HandleFunc("POST /execs/{id}/sendverification", handlers.SendEmailVerificationHandler)
 
 	mux.HandleFunc("POST /execs/login", handlers.LoginHandler)
 	mux.HandleFunc("POST /execs/logout", handlers.LogoutHandler)
 	mux.HandleFunc("POST /execs/forgotpassword", handlers.ForgotPasswordHandler)
 	mux.HandleFunc("POST /execs/resetpassword/reset/{resetcode}", handlers.ResetPasswordHandler)
+	mux.HandleFunc("POST /execs/verifyemail/{verificationcode}", handlers.ConfirmEmailChangeHandler)
 
 	return mux
 }
\ No newline at end of file
@@ -0,0 +1,18 @@
+package router
+
+import (
+	"net/http"
+	"restapi/internal/api/handlers"
+	"restapi/internal/metrics"
+)
+
+func HealthRouter() *http.ServeMux {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /healthz", handlers.HealthzHandler)
+	mux.HandleFunc("GET /readyz", handlers.ReadyzHandler)
+	mux.HandleFunc("GET /version", handlers.VersionHandler)
+	mux.Handle("GET /metrics", metrics.Default.Handler())
+
+	return mux
+}
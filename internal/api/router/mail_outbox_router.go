@@ -0,0 +1,23 @@
+package router
+
+import (
+	"net/http"
+	"restapi/internal/api/handlers"
+	mw "restapi/internal/api/middlewares"
+)
+
+// mailOutboxParams are the query parameters of the outbox listing
+var mailOutboxParams = []mw.RouteParams{
+	{Pattern: "GET /mailoutbox", Query: []mw.ParamSchema{
+		{Name: "status"},
+	}},
+}
+
+func MailOutboxRouter() *http.ServeMux {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /mailoutbox", handlers.GetOutboxEmailsHandler)
+	mux.HandleFunc("POST /mailoutbox/{id}/resend", handlers.ResendOutboxEmailHandler)
+
+	return mux
+}
@@ -0,0 +1,16 @@
+package router
+
+import (
+	"net/http"
+	"restapi/internal/api/handlers"
+)
+
+// ReportsRouter takes the reports browsers send on their own, without
+// credentials
+func ReportsRouter() *http.ServeMux {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("POST /csp-reports", handlers.CSPReportHandler)
+
+	return mux
+}
@@ -2,14 +2,38 @@ package router
 
 import (
 	"net/http"
+	mw "restapi/internal/api/middlewares"
+	"slices"
 )
 
+// sortParams are accepted by every list endpoint, sortby=<field>:<asc|desc>
+// may be repeated
+var sortParams = []mw.ParamSchema{
+	{Name: "sortby", Multi: true},
+	{Name: "sortBy"},
+	{Name: "sortOrder"},
+}
+
+// ParamSchemas lists the query parameters each route accepts, for the HPP
+// check; the schemas live next to the routes they describe
+func ParamSchemas() []mw.RouteParams {
+	return slices.Concat(studentsParams, teachersParams, execsParams, mailOutboxParams)
+}
+
 func MainRouter() *http.ServeMux {
 
 	tRouter := TeachersRouter()
 	sRouter := StudentsRouter()
 	eRouter := ExecsRouter()
+	mRouter := MailOutboxRouter()
+	aRouter := APIKeysRouter()
+	rRouter := ReportsRouter()
+	hRouter := HealthRouter()
 
+	rRouter.Handle("/", hRouter)
+	aRouter.Handle("/", rRouter)
+	mRouter.Handle("/", aRouter)
+	eRouter.Handle("/", mRouter)
 	sRouter.Handle("/", eRouter)
 	tRouter.Handle("/", sRouter)
 	return tRouter
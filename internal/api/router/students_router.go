@@ -3,8 +3,22 @@ package router
 import (
 	"net/http"
 	"restapi/internal/api/handlers"
+	mw "restapi/internal/api/middlewares"
 )
 
+// studentsParams are the query parameters of the student list, filters on
+// the model's columns plus paging and sorting
+var studentsParams = []mw.RouteParams{
+	{Pattern: "GET /students", Query: append([]mw.ParamSchema{
+		{Name: "page", Type: mw.ParamInt, Policy: mw.HPPReject},
+		{Name: "limit", Type: mw.ParamInt, Policy: mw.HPPReject},
+		{Name: "first_name"},
+		{Name: "last_name"},
+		{Name: "email"},
+		{Name: "class"},
+	}, sortParams...)},
+}
+
 func StudentsRouter() *http.ServeMux {
 	mux := http.NewServeMux()
 
@@ -3,8 +3,21 @@ package router
 import (
 	"net/http"
 	"restapi/internal/api/handlers"
+	mw "restapi/internal/api/middlewares"
 )
 
+// teachersParams are the query parameters of the teacher list, filters on
+// the model's columns plus sorting
+var teachersParams = []mw.RouteParams{
+	{Pattern: "GET /teachers", Query: append([]mw.ParamSchema{
+		{Name: "first_name"},
+		{Name: "last_name"},
+		{Name: "email"},
+		{Name: "class"},
+		{Name: "subject"},
+	}, sortParams...)},
+}
+
 func TeachersRouter() *http.ServeMux {
 	mux := http.NewServeMux()
 
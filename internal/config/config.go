@@ -0,0 +1,726 @@
+// Package config loads the server settings. Every setting is resolved from,
+// in increasing order of precedence: the defaults below, an optional YAML or
+// TOML file, environment variables and command line flags.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Each setting carries the environment variable that sets it, and its
+// flag is the dotted path of yaml names, e.g. -db.user for DB.User.
+type Config struct {
+	Server    ServerConfig    `yaml:"server" toml:"server"`
+	TLS       TLSConfig       `yaml:"tls" toml:"tls"`
+	DB        DBConfig        `yaml:"db" toml:"db"`
+	JWT       JWTConfig       `yaml:"jwt" toml:"jwt"`
+	Tokens    TokensConfig    `yaml:"tokens" toml:"tokens"`
+	CORS      CORSConfig      `yaml:"cors" toml:"cors"`
+	Headers   HeadersConfig   `yaml:"security_headers" toml:"security_headers"`
+	Sanitize  SanitizeConfig  `yaml:"sanitize" toml:"sanitize"`
+	HPP       HPPConfig       `yaml:"hpp" toml:"hpp"`
+	RateLimit RateLimitConfig `yaml:"rate_limit" toml:"rate_limit"`
+	Mail      MailConfig      `yaml:"mail" toml:"mail"`
+	Log       LogConfig       `yaml:"log" toml:"log"`
+	Tracing   TracingConfig   `yaml:"tracing" toml:"tracing"`
+	Metrics   MetricsConfig   `yaml:"metrics" toml:"metrics"`
+}
+
+type ServerConfig struct {
+	// Port serves HTTPS, HTTPPort plain HTTP for a TLS-terminating proxy
+	Port           string   `yaml:"port" toml:"port" env:"API_PORT"`
+	HTTPPort       string   `yaml:"http_port" toml:"http_port" env:"HTTP_PORT"`
+	CertFile       string   `yaml:"cert_file" toml:"cert_file" env:"CERT_FILE"`
+	KeyFile        string   `yaml:"key_file" toml:"key_file" env:"KEY_FILE"`
+	PublicBaseURL  string   `yaml:"public_base_url" toml:"public_base_url" env:"PUBLIC_BASE_URL"`
+	TrustedProxies []string `yaml:"trusted_proxies" toml:"trusted_proxies" env:"TRUSTED_PROXIES"`
+	// TrustedProxyHeader is the one header the trusted proxies set:
+	// forwarded, x-forwarded-for or x-real-ip
+	TrustedProxyHeader string        `yaml:"trusted_proxy_header" toml:"trusted_proxy_header" env:"TRUSTED_PROXY_HEADER"`
+	ReadHeaderTimeout  time.Duration `yaml:"read_header_timeout" toml:"read_header_timeout" env:"SERVER_READ_HEADER_TIMEOUT"`
+	ReadTimeout        time.Duration `yaml:"read_timeout" toml:"read_timeout" env:"SERVER_READ_TIMEOUT"`
+	WriteTimeout       time.Duration `yaml:"write_timeout" toml:"write_timeout" env:"SERVER_WRITE_TIMEOUT"`
+	IdleTimeout        time.Duration `yaml:"idle_timeout" toml:"idle_timeout" env:"SERVER_IDLE_TIMEOUT"`
+	ShutdownTimeout    time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT"`
+}
+
+type TLSConfig struct {
+	Enabled        bool          `yaml:"enabled" toml:"enabled" env:"TLS_ENABLED"`
+	MinVersion     string        `yaml:"min_version" toml:"min_version" env:"TLS_MIN_VERSION"`
+	MaxVersion     string        `yaml:"max_version" toml:"max_version" env:"TLS_MAX_VERSION"`
+	CipherSuites   []string      `yaml:"cipher_suites" toml:"cipher_suites" env:"TLS_CIPHER_SUITES"`
+	ReloadInterval time.Duration `yaml:"reload_interval" toml:"reload_interval" env:"TLS_RELOAD_INTERVAL"`
+	// ClientAuth is none, optional or require
+	ClientAuth   string `yaml:"client_auth" toml:"client_auth" env:"TLS_CLIENT_AUTH"`
+	ClientCAFile string `yaml:"client_ca_file" toml:"client_ca_file" env:"TLS_CLIENT_CA_FILE"`
+	// ServiceAccounts can only be set in the config file
+	ServiceAccounts []ServiceAccount `yaml:"service_accounts" toml:"service_accounts"`
+}
+
+// ServiceAccount maps a verified client certificate to an identity. A
+// certificate matching any of the names is authenticated as Name with Role.
+type ServiceAccount struct {
+	Name        string   `yaml:"name" toml:"name"`
+	Role        string   `yaml:"role" toml:"role"`
+	CommonNames []string `yaml:"common_names" toml:"common_names"`
+	DNSNames    []string `yaml:"dns_names" toml:"dns_names"`
+	URIs        []string `yaml:"uris" toml:"uris"`
+	Emails      []string `yaml:"emails" toml:"emails"`
+}
+
+type DBConfig struct {
+	User            string        `yaml:"user" toml:"user" env:"DB_USER"`
+	Password        string        `yaml:"password" toml:"password" env:"DB_PASSWORD"`
+	Name            string        `yaml:"name" toml:"name" env:"DB_NAME"`
+	Host            string        `yaml:"host" toml:"host" env:"HOST"`
+	Port            string        `yaml:"port" toml:"port" env:"DB_PORT"`
+	MaxOpenConns    int           `yaml:"max_open_conns" toml:"max_open_conns" env:"DB_MAX_OPEN_CONNS"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" toml:"max_idle_conns" env:"DB_MAX_IDLE_CONNS"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" toml:"conn_max_lifetime" env:"DB_CONN_MAX_LIFETIME"`
+	ReadTimeout     time.Duration `yaml:"read_timeout" toml:"read_timeout" env:"DB_READ_TIMEOUT"`
+	WriteTimeout    time.Duration `yaml:"write_timeout" toml:"write_timeout" env:"DB_WRITE_TIMEOUT"`
+}
+
+type JWTConfig struct {
+	Secret    string        `yaml:"secret" toml:"secret" env:"JWT_SECRET"`
+	ExpiresIn time.Duration `yaml:"expires_in" toml:"expires_in" env:"JWT_EXPIRES_IN"`
+}
+
+// TokensConfig holds the validity of the emailed tokens and the frontend
+// routes of their links
+type TokensConfig struct {
+	ResetExpiry             time.Duration `yaml:"reset_expiry" toml:"reset_expiry" env:"RESET_TOKEN_EXP_DURATION"`
+	InviteExpiry            time.Duration `yaml:"invite_expiry" toml:"invite_expiry" env:"INVITE_TOKEN_EXP_DURATION"`
+	EmailVerificationExpiry time.Duration `yaml:"email_verification_expiry" toml:"email_verification_expiry" env:"EMAIL_VERIFICATION_TOKEN_EXP_DURATION"`
+	ResetRequestsPerHour    int           `yaml:"reset_requests_per_hour" toml:"reset_requests_per_hour" env:"RESET_REQUESTS_PER_HOUR"`
+	ResetPasswordPath       string        `yaml:"reset_password_path" toml:"reset_password_path" env:"RESET_PASSWORD_PATH"`
+	EmailVerificationPath   string        `yaml:"email_verification_path" toml:"email_verification_path" env:"EMAIL_VERIFICATION_PATH"`
+}
+
+type CORSConfig struct {
+	AllowedOrigins   []string      `yaml:"allowed_origins" toml:"allowed_origins" env:"CORS_ALLOWED_ORIGINS"`
+	AllowedMethods   []string      `yaml:"allowed_methods" toml:"allowed_methods" env:"CORS_ALLOWED_METHODS"`
+	AllowedHeaders   []string      `yaml:"allowed_headers" toml:"allowed_headers" env:"CORS_ALLOWED_HEADERS"`
+	ExposedHeaders   []string      `yaml:"exposed_headers" toml:"exposed_headers" env:"CORS_EXPOSED_HEADERS"`
+	AllowCredentials bool          `yaml:"allow_credentials" toml:"allow_credentials" env:"CORS_ALLOW_CREDENTIALS"`
+	MaxAge           time.Duration `yaml:"max_age" toml:"max_age" env:"CORS_MAX_AGE"`
+}
+
+// HeadersConfig sets the security headers, an empty value in the file
+// drops the header
+type HeadersConfig struct {
+	// ContentSecurityPolicy may contain {nonce} for a per-request nonce
+	ContentSecurityPolicy     string        `yaml:"content_security_policy" toml:"content_security_policy" env:"CONTENT_SECURITY_POLICY"`
+	CSPReportOnly             bool          `yaml:"csp_report_only" toml:"csp_report_only" env:"CSP_REPORT_ONLY"`
+	CSPReportURI              string        `yaml:"csp_report_uri" toml:"csp_report_uri" env:"CSP_REPORT_URI"`
+	HSTSMaxAge                time.Duration `yaml:"hsts_max_age" toml:"hsts_max_age" env:"HSTS_MAX_AGE"`
+	HSTSIncludeSubdomains     bool          `yaml:"hsts_include_subdomains" toml:"hsts_include_subdomains" env:"HSTS_INCLUDE_SUBDOMAINS"`
+	HSTSPreload               bool          `yaml:"hsts_preload" toml:"hsts_preload" env:"HSTS_PRELOAD"`
+	FrameOptions              string        `yaml:"frame_options" toml:"frame_options" env:"FRAME_OPTIONS"`
+	ReferrerPolicy            string        `yaml:"referrer_policy" toml:"referrer_policy" env:"REFERRER_POLICY"`
+	CacheControl              string        `yaml:"cache_control" toml:"cache_control" env:"CACHE_CONTROL"`
+	PermissionsPolicy         string        `yaml:"permissions_policy" toml:"permissions_policy" env:"PERMISSIONS_POLICY"`
+	CrossOriginResourcePolicy string        `yaml:"cross_origin_resource_policy" toml:"cross_origin_resource_policy" env:"CROSS_ORIGIN_RESOURCE_POLICY"`
+	CrossOriginOpenerPolicy   string        `yaml:"cross_origin_opener_policy" toml:"cross_origin_opener_policy" env:"CROSS_ORIGIN_OPENER_POLICY"`
+	CrossOriginEmbedderPolicy string        `yaml:"cross_origin_embedder_policy" toml:"cross_origin_embedder_policy" env:"CROSS_ORIGIN_EMBEDDER_POLICY"`
+	// Routes can only be set in the config file
+	Routes []HeadersRoute `yaml:"routes" toml:"routes"`
+}
+
+// HeadersRoute replaces headers by name for the paths starting with Prefix
+type HeadersRoute struct {
+	Prefix  string            `yaml:"prefix" toml:"prefix"`
+	Headers map[string]string `yaml:"headers" toml:"headers"`
+}
+
+// SanitizeConfig sets how request input is cleaned. Policies are strict
+// (strip tags), ugc (keep safe formatting markup) or none.
+type SanitizeConfig struct {
+	DefaultPolicy       string   `yaml:"default_policy" toml:"default_policy" env:"SANITIZE_DEFAULT_POLICY"`
+	MaxBodyBytes        int      `yaml:"max_body_bytes" toml:"max_body_bytes" env:"MAX_BODY_BYTES"`
+	AllowedContentTypes []string `yaml:"allowed_content_types" toml:"allowed_content_types" env:"SANITIZE_ALLOWED_CONTENT_TYPES"`
+	// Fields and Routes can only be set in the config file
+	Fields map[string]string `yaml:"fields" toml:"fields"`
+	Routes []SanitizeRoute   `yaml:"routes" toml:"routes"`
+}
+
+// SanitizeRoute overrides the sanitize settings for the paths starting with
+// Prefix, limited to Methods when set
+type SanitizeRoute struct {
+	Prefix              string            `yaml:"prefix" toml:"prefix"`
+	Methods             []string          `yaml:"methods" toml:"methods"`
+	DefaultPolicy       string            `yaml:"default_policy" toml:"default_policy"`
+	Fields              map[string]string `yaml:"fields" toml:"fields"`
+	MaxBodyBytes        int               `yaml:"max_body_bytes" toml:"max_body_bytes"`
+	AllowedContentTypes []string          `yaml:"allowed_content_types" toml:"allowed_content_types"`
+}
+
+// HPPConfig sets how parameters outside a route's schema are handled. The
+// default policy picks the first or last value of a repeated single-valued
+// parameter, or rejects it.
+type HPPConfig struct {
+	// Strict answers 400 instead of dropping the parameters
+	Strict        bool   `yaml:"strict" toml:"strict" env:"HPP_STRICT"`
+	DefaultPolicy string `yaml:"default_policy" toml:"default_policy" env:"HPP_DEFAULT_POLICY"`
+}
+
+type RateLimitConfig struct {
+	// Store is memory or redis
+	Store         string `yaml:"store" toml:"store" env:"RATE_LIMIT_STORE"`
+	RedisAddr     string `yaml:"redis_addr" toml:"redis_addr" env:"REDIS_ADDR"`
+	RedisPassword string `yaml:"redis_password" toml:"redis_password" env:"REDIS_PASSWORD"`
+	RedisDB       int    `yaml:"redis_db" toml:"redis_db" env:"REDIS_DB"`
+	// IdleTimeout is how long the memory store keeps an untouched bucket
+	IdleTimeout time.Duration `yaml:"idle_timeout" toml:"idle_timeout" env:"RATE_LIMIT_IDLE_TIMEOUT"`
+	// Default, Routes and APIKeyFailures can only be set in the config
+	// file, Routes replaces the built-in list as a whole
+	Default RateLimitPolicy  `yaml:"default" toml:"default"`
+	Routes  []RateLimitRoute `yaml:"routes" toml:"routes"`
+	// APIKeyFailures limits failed API key attempts per client address,
+	// API keys are checked before the other limits apply
+	APIKeyFailures RateLimitPolicy `yaml:"api_key_failures" toml:"api_key_failures"`
+}
+
+// RateLimitPolicy is a token bucket of Burst tokens, Limit by default,
+// refilled at Limit tokens per Window. KeyBy is ip or user, a Limit of 0
+// turns limiting off.
+type RateLimitPolicy struct {
+	Name   string        `yaml:"name" toml:"name"`
+	Limit  int           `yaml:"limit" toml:"limit"`
+	Window time.Duration `yaml:"window" toml:"window"`
+	Burst  int           `yaml:"burst" toml:"burst"`
+	KeyBy  string        `yaml:"key_by" toml:"key_by"`
+}
+
+// RateLimitRoute applies Policy to the paths starting with Prefix, limited
+// to Methods when set. The first matching route wins.
+type RateLimitRoute struct {
+	Prefix  string          `yaml:"prefix" toml:"prefix"`
+	Methods []string        `yaml:"methods" toml:"methods"`
+	Policy  RateLimitPolicy `yaml:"policy" toml:"policy"`
+}
+
+type MailConfig struct {
+	// Backend is smtp, file or capture
+	Backend       string `yaml:"backend" toml:"backend" env:"MAIL_BACKEND"`
+	From          string `yaml:"from" toml:"from" env:"MAIL_FROM"`
+	Dir           string `yaml:"dir" toml:"dir" env:"MAIL_DIR"`
+	DefaultLocale string `yaml:"default_locale" toml:"default_locale" env:"MAIL_DEFAULT_LOCALE"`
+	SMTPHost      string `yaml:"smtp_host" toml:"smtp_host" env:"SMTP_HOST"`
+	SMTPPort      int    `yaml:"smtp_port" toml:"smtp_port" env:"SMTP_PORT"`
+	SMTPUsername  string `yaml:"smtp_username" toml:"smtp_username" env:"SMTP_USERNAME"`
+	SMTPPassword  string `yaml:"smtp_password" toml:"smtp_password" env:"SMTP_PASSWORD"`
+	// SMTPTLS is none, starttls or tls
+	SMTPTLS string `yaml:"smtp_tls" toml:"smtp_tls" env:"SMTP_TLS"`
+	// QueueInterval is how often the worker polls the outbox, MaxAttempts
+	// how often it tries a message before marking it dead
+	QueueInterval time.Duration `yaml:"queue_interval" toml:"queue_interval" env:"MAIL_QUEUE_INTERVAL"`
+	MaxAttempts   int           `yaml:"max_attempts" toml:"max_attempts" env:"MAIL_MAX_ATTEMPTS"`
+}
+
+type LogConfig struct {
+	Level  string `yaml:"level" toml:"level" env:"LOG_LEVEL"`
+	Format string `yaml:"format" toml:"format" env:"LOG_FORMAT"`
+}
+
+type TracingConfig struct {
+	// Exporter is none, stdout, file or otlp
+	Exporter     string  `yaml:"exporter" toml:"exporter" env:"OTEL_TRACES_EXPORTER"`
+	File         string  `yaml:"file" toml:"file" env:"TRACE_FILE"`
+	OTLPEndpoint string  `yaml:"otlp_endpoint" toml:"otlp_endpoint" env:"OTEL_EXPORTER_OTLP_ENDPOINT"`
+	OTLPHeaders  string  `yaml:"otlp_headers" toml:"otlp_headers" env:"OTEL_EXPORTER_OTLP_HEADERS"`
+	ServiceName  string  `yaml:"service_name" toml:"service_name" env:"OTEL_SERVICE_NAME"`
+	SampleRatio  float64 `yaml:"sample_ratio" toml:"sample_ratio" env:"OTEL_TRACES_SAMPLER_ARG"`
+}
+
+// MetricsConfig restricts who can scrape /metrics: clients in
+// AllowedNetworks, and with Token set only those sending it as a bearer token
+type MetricsConfig struct {
+	Token           string   `yaml:"token" toml:"token" env:"METRICS_TOKEN"`
+	AllowedNetworks []string `yaml:"allowed_networks" toml:"allowed_networks" env:"METRICS_ALLOWED_NETWORKS"`
+}
+
+// Defaults are the settings used when nothing else sets them. Secrets and
+// database credentials have none on purpose.
+func Defaults() Config {
+	return Config{
+		Server: ServerConfig{
+			Port:               ":3000",
+			CertFile:           "cert.pem",
+			KeyFile:            "key.pem",
+			PublicBaseURL:      "https://localhost:8000",
+			TrustedProxyHeader: "x-forwarded-for",
+			ReadHeaderTimeout:  5 * time.Second,
+			ReadTimeout:        15 * time.Second,
+			WriteTimeout:       30 * time.Second,
+			IdleTimeout:        2 * time.Minute,
+			ShutdownTimeout:    30 * time.Second,
+		},
+		TLS: TLSConfig{
+			Enabled:        true,
+			MinVersion:     "1.2",
+			ReloadInterval: 30 * time.Second,
+			ClientAuth:     "none",
+		},
+		DB: DBConfig{
+			Port:            "3306",
+			MaxOpenConns:    25,
+			MaxIdleConns:    25,
+			ConnMaxLifetime: 5 * time.Minute,
+			ReadTimeout:     5 * time.Second,
+			WriteTimeout:    10 * time.Second,
+		},
+		JWT: JWTConfig{
+			ExpiresIn: 15 * time.Minute,
+		},
+		Tokens: TokensConfig{
+			ResetExpiry:             10 * time.Minute,
+			InviteExpiry:            24 * time.Hour,
+			EmailVerificationExpiry: time.Hour,
+			ResetRequestsPerHour:    3,
+			ResetPasswordPath:       "/execs/resetpassword/reset/{token}",
+			EmailVerificationPath:   "/execs/verifyemail/{token}",
+		},
+		CORS: CORSConfig{
+			AllowedOrigins:   []string{"https://my-origin-url.com", "https://localhost:8000"},
+			AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
+			AllowedHeaders:   []string{"Content-Type", "Authorization", "X-API-Key"},
+			ExposedHeaders:   []string{"Authorization"},
+			AllowCredentials: true,
+			MaxAge:           time.Hour,
+		},
+		Headers: HeadersConfig{
+			ContentSecurityPolicy:     "default-src 'none'; frame-ancestors 'none'",
+			CSPReportURI:              "/csp-reports",
+			HSTSMaxAge:                2 * 365 * 24 * time.Hour,
+			HSTSIncludeSubdomains:     true,
+			HSTSPreload:               true,
+			FrameOptions:              "DENY",
+			ReferrerPolicy:            "no-referrer",
+			CacheControl:              "no-store, max-age=0",
+			PermissionsPolicy:         "geolocation=(), microphone=(), camera=()",
+			CrossOriginResourcePolicy: "same-origin",
+			CrossOriginOpenerPolicy:   "same-origin",
+			CrossOriginEmbedderPolicy: "require-corp",
+		},
+		Sanitize: SanitizeConfig{
+			DefaultPolicy: "strict",
+			Fields: map[string]string{
+				"password":         "none",
+				"current_password": "none",
+				"new_password":     "none",
+			},
+			MaxBodyBytes:        1 << 20,
+			AllowedContentTypes: []string{"multipart/form-data", "text/csv"},
+		},
+		HPP: HPPConfig{
+			DefaultPolicy: "last",
+		},
+		RateLimit: RateLimitConfig{
+			Store:       "memory",
+			RedisAddr:   "localhost:6379",
+			IdleTimeout: 10 * time.Minute,
+			Default:     RateLimitPolicy{Name: "default", Limit: 60, Window: time.Minute, KeyBy: "user"},
+			Routes: []RateLimitRoute{
+				{Prefix: "/execs/login", Policy: RateLimitPolicy{Name: "login", Limit: 5, Window: time.Minute, KeyBy: "ip"}},
+				{Prefix: "/execs/forgotpassword", Policy: RateLimitPolicy{Name: "forgotpassword", Limit: 5, Window: time.Minute, KeyBy: "ip"}},
+				{Prefix: "/execs/resetpassword", Policy: RateLimitPolicy{Name: "resetpassword", Limit: 5, Window: time.Minute, KeyBy: "ip"}},
+				{Prefix: "/", Methods: []string{"GET"}, Policy: RateLimitPolicy{Name: "reads", Limit: 300, Window: time.Minute, Burst: 50, KeyBy: "user"}},
+			},
+			APIKeyFailures: RateLimitPolicy{Name: "api_key_failures", Limit: 10, Window: time.Minute, KeyBy: "ip"},
+		},
+		Mail: MailConfig{
+			Backend:       "smtp",
+			From:          "schooladmin@school.com",
+			Dir:           "mail",
+			DefaultLocale: "en",
+			SMTPHost:      "localhost",
+			SMTPPort:      1025,
+			SMTPTLS:       "none",
+			QueueInterval: 10 * time.Second,
+			MaxAttempts:   8,
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "json",
+		},
+		Tracing: TracingConfig{
+			Exporter:     "none",
+			File:         "traces.jsonl",
+			OTLPEndpoint: "http://localhost:4318",
+			ServiceName:  "restapi",
+			SampleRatio:  1,
+		},
+		Metrics: MetricsConfig{
+			AllowedNetworks: []string{"127.0.0.0/8", "::1/128", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7"},
+		},
+	}
+}
+
+// Load resolves the configuration for the given command line arguments
+// (without the program name). The file comes from -config or CONFIG_FILE.
+func Load(args []string) (Config, error) {
+	cfg := Defaults()
+	fields := leafFields(&cfg)
+
+	flags := flag.NewFlagSet("api", flag.ContinueOnError)
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "YAML or TOML config file")
+	flagValues := make(map[string]*string)
+	for _, field := range fields {
+		flagValues[field.flag] = flags.String(field.flag, "", "overrides "+field.env)
+	}
+	err := flags.Parse(args)
+	if err != nil {
+		return Config{}, err
+	}
+
+	if *configFile != "" {
+		err = loadFile(*configFile, &cfg)
+		if err != nil {
+			return Config{}, err
+		}
+	}
+
+	var errs []error
+	for _, field := range fields {
+		if value, ok := os.LookupEnv(field.env); ok && value != "" {
+			errs = append(errs, field.set(value, field.env))
+		}
+	}
+	flags.Visit(func(f *flag.Flag) {
+		if value, ok := flagValues[f.Name]; ok {
+			errs = append(errs, fieldByFlag(fields, f.Name).set(*value, "-"+f.Name))
+		}
+	})
+	err = errors.Join(errs...)
+	if err != nil {
+		return Config{}, err
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+func loadFile(path string, cfg *Config) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(strings.NewReader(string(content)))
+		decoder.KnownFields(true)
+		err = decoder.Decode(cfg)
+		// an empty file decodes to io.EOF, it just sets nothing
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("parsing %s: %w", path, err)
+		}
+	case ".toml":
+		metadata, err := toml.Decode(string(content), cfg)
+		if err != nil {
+			return fmt.Errorf("parsing %s: %w", path, err)
+		}
+		if undecoded := metadata.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("parsing %s: unknown setting %s", path, undecoded[0])
+		}
+	default:
+		return fmt.Errorf("config file %s: expected a .yaml, .yml or .toml extension", path)
+	}
+	return nil
+}
+
+// Validate reports every invalid setting at once, naming the environment
+// variable so the fix is obvious whichever source it came from
+func (c Config) Validate() error {
+	var errs []error
+	required := func(value, env string) {
+		if strings.TrimSpace(value) == "" {
+			errs = append(errs, fmt.Errorf("%s is required", env))
+		}
+	}
+	oneOf := func(value, env string, allowed ...string) {
+		if !slices.Contains(allowed, value) {
+			errs = append(errs, fmt.Errorf("%s must be one of %s, got %q", env, strings.Join(allowed, ", "), value))
+		}
+	}
+	positive := func(value time.Duration, env string) {
+		if value <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be a positive duration", env))
+		}
+	}
+
+	address := func(value, env string) {
+		if _, _, err := net.SplitHostPort(value); err != nil {
+			errs = append(errs, fmt.Errorf("%s must look like :3000 or 0.0.0.0:3000, got %q", env, value))
+		}
+	}
+
+	if c.TLS.Enabled {
+		address(c.Server.Port, "API_PORT")
+		required(c.Server.CertFile, "CERT_FILE")
+		required(c.Server.KeyFile, "KEY_FILE")
+		oneOf(c.TLS.MinVersion, "TLS_MIN_VERSION", "1.2", "1.3")
+		oneOf(c.TLS.MaxVersion, "TLS_MAX_VERSION", "", "1.2", "1.3")
+		// "1.2" and "1.3" order as strings, other values are reported above
+		if c.TLS.MaxVersion != "" && c.TLS.MaxVersion < c.TLS.MinVersion {
+			errs = append(errs, fmt.Errorf("TLS_MAX_VERSION %s is below TLS_MIN_VERSION %s", c.TLS.MaxVersion, c.TLS.MinVersion))
+		}
+		positive(c.TLS.ReloadInterval, "TLS_RELOAD_INTERVAL")
+		oneOf(c.TLS.ClientAuth, "TLS_CLIENT_AUTH", "none", "optional", "require")
+		if c.TLS.ClientAuth != "none" {
+			required(c.TLS.ClientCAFile, "TLS_CLIENT_CA_FILE")
+		} else if len(c.TLS.ServiceAccounts) > 0 {
+			errs = append(errs, errors.New("tls.service_accounts need TLS_CLIENT_AUTH optional or require"))
+		}
+		names := make(map[string]bool)
+		for i, account := range c.TLS.ServiceAccounts {
+			setting := fmt.Sprintf("tls.service_accounts[%d]", i)
+			if account.Name == "" {
+				errs = append(errs, fmt.Errorf("%s: name is required", setting))
+			} else if names[account.Name] {
+				errs = append(errs, fmt.Errorf("%s: name %q is used twice", setting, account.Name))
+			}
+			names[account.Name] = true
+			if !slices.Contains([]string{"admin", "manager", "exec"}, account.Role) {
+				errs = append(errs, fmt.Errorf("%s: role must be one of admin, manager, exec, got %q", setting, account.Role))
+			}
+			if len(account.CommonNames)+len(account.DNSNames)+len(account.URIs)+len(account.Emails) == 0 {
+				errs = append(errs, fmt.Errorf("%s: at least one common name, DNS name, URI or email is required", setting))
+			}
+		}
+	} else if c.Server.HTTPPort == "" {
+		errs = append(errs, errors.New("HTTP_PORT is required when TLS_ENABLED is false"))
+	}
+	if c.Server.HTTPPort != "" {
+		address(c.Server.HTTPPort, "HTTP_PORT")
+	}
+	if u, err := url.Parse(c.Server.PublicBaseURL); err != nil || u.Scheme == "" || u.Host == "" {
+		errs = append(errs, fmt.Errorf("PUBLIC_BASE_URL must be an absolute URL, got %q", c.Server.PublicBaseURL))
+	}
+	oneOf(strings.ToLower(c.Server.TrustedProxyHeader), "TRUSTED_PROXY_HEADER", "forwarded", "x-forwarded-for", "x-real-ip")
+	positive(c.Server.ReadHeaderTimeout, "SERVER_READ_HEADER_TIMEOUT")
+	positive(c.Server.ReadTimeout, "SERVER_READ_TIMEOUT")
+	positive(c.Server.WriteTimeout, "SERVER_WRITE_TIMEOUT")
+	positive(c.Server.IdleTimeout, "SERVER_IDLE_TIMEOUT")
+	positive(c.Server.ShutdownTimeout, "SHUTDOWN_TIMEOUT")
+
+	required(c.DB.User, "DB_USER")
+	required(c.DB.Name, "DB_NAME")
+	required(c.DB.Host, "HOST")
+	if port, err := strconv.Atoi(c.DB.Port); err != nil || port <= 0 || port > 65535 {
+		errs = append(errs, fmt.Errorf("DB_PORT must be a port number, got %q", c.DB.Port))
+	}
+	if c.DB.MaxOpenConns <= 0 {
+		errs = append(errs, errors.New("DB_MAX_OPEN_CONNS must be positive"))
+	}
+	positive(c.DB.ReadTimeout, "DB_READ_TIMEOUT")
+	positive(c.DB.WriteTimeout, "DB_WRITE_TIMEOUT")
+
+	required(c.JWT.Secret, "JWT_SECRET")
+	positive(c.JWT.ExpiresIn, "JWT_EXPIRES_IN")
+
+	positive(c.Tokens.ResetExpiry, "RESET_TOKEN_EXP_DURATION")
+	positive(c.Tokens.InviteExpiry, "INVITE_TOKEN_EXP_DURATION")
+	positive(c.Tokens.EmailVerificationExpiry, "EMAIL_VERIFICATION_TOKEN_EXP_DURATION")
+	for _, path := range []struct{ value, env string }{
+		{c.Tokens.ResetPasswordPath, "RESET_PASSWORD_PATH"},
+		{c.Tokens.EmailVerificationPath, "EMAIL_VERIFICATION_PATH"},
+	} {
+		if !strings.HasPrefix(path.value, "/") || !strings.Contains(path.value, "{token}") {
+			errs = append(errs, fmt.Errorf("%s must be a path containing {token}, got %q", path.env, path.value))
+		}
+	}
+	if c.Tokens.ResetRequestsPerHour <= 0 {
+		errs = append(errs, errors.New("RESET_REQUESTS_PER_HOUR must be positive"))
+	}
+
+	// with credentials "*" would mean echoing any origin, letting every site
+	// make authenticated requests
+	if c.CORS.AllowCredentials && slices.Contains(c.CORS.AllowedOrigins, "*") {
+		errs = append(errs, errors.New(`CORS_ALLOWED_ORIGINS can't contain "*" when CORS_ALLOW_CREDENTIALS is true, list the origins`))
+	}
+
+	if c.Headers.HSTSMaxAge < 0 {
+		errs = append(errs, errors.New("HSTS_MAX_AGE can't be negative"))
+	}
+	if uri := c.Headers.CSPReportURI; uri != "" {
+		if u, err := url.Parse(uri); err != nil || (!strings.HasPrefix(uri, "/") && !u.IsAbs()) {
+			errs = append(errs, fmt.Errorf("CSP_REPORT_URI must be a path or an absolute URL, got %q", uri))
+		}
+	}
+	for i, route := range c.Headers.Routes {
+		if !strings.HasPrefix(route.Prefix, "/") {
+			errs = append(errs, fmt.Errorf("security_headers.routes[%d]: prefix must start with /, got %q", i, route.Prefix))
+		}
+	}
+
+	policies := []string{"strict", "ugc", "none"}
+	oneOf(c.Sanitize.DefaultPolicy, "SANITIZE_DEFAULT_POLICY", policies...)
+	if c.Sanitize.MaxBodyBytes <= 0 {
+		errs = append(errs, errors.New("MAX_BODY_BYTES must be positive"))
+	}
+	for name, policy := range c.Sanitize.Fields {
+		oneOf(policy, "sanitize.fields."+name, policies...)
+	}
+	for i, route := range c.Sanitize.Routes {
+		setting := fmt.Sprintf("sanitize.routes[%d]", i)
+		if !strings.HasPrefix(route.Prefix, "/") {
+			errs = append(errs, fmt.Errorf("%s: prefix must start with /, got %q", setting, route.Prefix))
+		}
+		if route.DefaultPolicy != "" {
+			oneOf(route.DefaultPolicy, setting+".default_policy", policies...)
+		}
+		for name, policy := range route.Fields {
+			oneOf(policy, setting+".fields."+name, policies...)
+		}
+		if route.MaxBodyBytes < 0 {
+			errs = append(errs, fmt.Errorf("%s: max_body_bytes can't be negative", setting))
+		}
+	}
+
+	oneOf(c.HPP.DefaultPolicy, "HPP_DEFAULT_POLICY", "first", "last", "reject")
+
+	oneOf(c.RateLimit.Store, "RATE_LIMIT_STORE", "memory", "redis")
+	if c.RateLimit.Store == "redis" {
+		required(c.RateLimit.RedisAddr, "REDIS_ADDR")
+	}
+	positive(c.RateLimit.IdleTimeout, "RATE_LIMIT_IDLE_TIMEOUT")
+	ratePolicy := func(policy RateLimitPolicy, setting string) {
+		if policy.Name == "" {
+			errs = append(errs, fmt.Errorf("%s: name is required", setting))
+		}
+		if policy.Limit < 0 || policy.Burst < 0 {
+			errs = append(errs, fmt.Errorf("%s: limit and burst can't be negative", setting))
+		}
+		if policy.Limit > 0 && policy.Window <= 0 {
+			errs = append(errs, fmt.Errorf("%s: window must be positive", setting))
+		}
+		oneOf(policy.KeyBy, setting+".key_by", "ip", "user")
+	}
+	ratePolicy(c.RateLimit.Default, "rate_limit.default")
+	for i, route := range c.RateLimit.Routes {
+		setting := fmt.Sprintf("rate_limit.routes[%d]", i)
+		if !strings.HasPrefix(route.Prefix, "/") {
+			errs = append(errs, fmt.Errorf("%s: prefix must start with /, got %q", setting, route.Prefix))
+		}
+		ratePolicy(route.Policy, setting+".policy")
+	}
+	ratePolicy(c.RateLimit.APIKeyFailures, "rate_limit.api_key_failures")
+
+	oneOf(c.Mail.Backend, "MAIL_BACKEND", "smtp", "file", "capture")
+	oneOf(c.Mail.SMTPTLS, "SMTP_TLS", "none", "starttls", "tls")
+	positive(c.Mail.QueueInterval, "MAIL_QUEUE_INTERVAL")
+	if c.Mail.MaxAttempts <= 0 {
+		errs = append(errs, errors.New("MAIL_MAX_ATTEMPTS must be positive"))
+	}
+
+	oneOf(c.Log.Level, "LOG_LEVEL", "debug", "info", "warn", "error")
+	oneOf(c.Log.Format, "LOG_FORMAT", "json", "text")
+
+	oneOf(c.Tracing.Exporter, "OTEL_TRACES_EXPORTER", "none", "stdout", "file", "otlp")
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		errs = append(errs, errors.New("OTEL_TRACES_SAMPLER_ARG must be between 0 and 1"))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// field is one setting, reached through reflection so the file, env and
+// flag layers share a single list
+type field struct {
+	value reflect.Value
+	env   string
+	flag  string
+}
+
+func leafFields(cfg *Config) []field {
+	var fields []field
+	var walk func(v reflect.Value, prefix string)
+	walk = func(v reflect.Value, prefix string) {
+		for i := 0; i < v.NumField(); i++ {
+			structField := v.Type().Field(i)
+			name := prefix + structField.Tag.Get("yaml")
+			if structField.Type.Kind() == reflect.Struct {
+				walk(v.Field(i), name+".")
+				continue
+			}
+			// settings without a variable are only read from the file
+			if structField.Tag.Get("env") == "" {
+				continue
+			}
+			fields = append(fields, field{value: v.Field(i), env: structField.Tag.Get("env"), flag: name})
+		}
+	}
+	walk(reflect.ValueOf(cfg).Elem(), "")
+	return fields
+}
+
+func fieldByFlag(fields []field, name string) field {
+	for _, f := range fields {
+		if f.flag == name {
+			return f
+		}
+	}
+	panic("config: no setting for flag " + name)
+}
+
+func (f field) set(raw, source string) error {
+	var err error
+	switch f.value.Interface().(type) {
+	case string:
+		f.value.SetString(raw)
+	case int:
+		var n int
+		n, err = strconv.Atoi(raw)
+		f.value.SetInt(int64(n))
+	case bool:
+		var b bool
+		b, err = strconv.ParseBool(raw)
+		f.value.SetBool(b)
+	case float64:
+		var x float64
+		x, err = strconv.ParseFloat(raw, 64)
+		f.value.SetFloat(x)
+	case time.Duration:
+		var d time.Duration
+		d, err = time.ParseDuration(raw)
+		f.value.SetInt(int64(d))
+	case []string:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			item = strings.TrimSpace(item)
+			if item != "" {
+				items = append(items, item)
+			}
+		}
+		f.value.Set(reflect.ValueOf(items))
+	default:
+		panic("config: unsupported setting type " + f.value.Type().String())
+	}
+	if err != nil {
+		return fmt.Errorf("%s: invalid value %q", source, raw)
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testYAML = `db:
+  user: api
+  name: school
+  host: db.internal
+  max_open_conns: 10
+jwt:
+  secret: s3cret
+  expires_in: 5m
+tls:
+  min_version: "1.3"
+  cipher_suites: [TLS_AES_128_GCM_SHA256]
+  client_auth: require
+  client_ca_file: ca.pem
+  service_accounts:
+    - name: grading
+      role: exec
+      common_names: [grading-job]
+mail:
+  queue_interval: 30s
+`
+
+const testTOML = `[db]
+user = "api"
+name = "school"
+host = "db.internal"
+max_open_conns = 10
+
+[jwt]
+secret = "s3cret"
+expires_in = "5m"
+
+[tls]
+min_version = "1.3"
+cipher_suites = ["TLS_AES_128_GCM_SHA256"]
+client_auth = "require"
+client_ca_file = "ca.pem"
+
+[[tls.service_accounts]]
+name = "grading"
+role = "exec"
+common_names = ["grading-job"]
+
+[mail]
+queue_interval = "30s"
+`
+
+// writeConfig writes content to a file named name in a temporary directory
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, []byte(content), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  string
+		flag string
+		want int
+	}{
+		{name: "default", want: 25},
+		{name: "file over default", file: "max_open_conns: 10", want: 10},
+		{name: "env over file", file: "max_open_conns: 10", env: "20", want: 20},
+		{name: "flag over env", file: "max_open_conns: 10", env: "20", flag: "30", want: 30},
+		{name: "flag over default", flag: "30", want: 30},
+		{name: "empty env is unset", file: "max_open_conns: 10", env: "", want: 10},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			content := "db:\n  user: api\n  name: school\n  host: db.internal\n"
+			if test.file != "" {
+				content += "  " + test.file + "\n"
+			}
+			t.Setenv("CONFIG_FILE", "")
+			t.Setenv("JWT_SECRET", "s3cret")
+			t.Setenv("DB_MAX_OPEN_CONNS", test.env)
+			args := []string{"-config", writeConfig(t, "config.yaml", content)}
+			if test.flag != "" {
+				args = append(args, "-db.max_open_conns", test.flag)
+			}
+
+			cfg, err := Load(args)
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if cfg.DB.MaxOpenConns != test.want {
+				t.Errorf("DB.MaxOpenConns = %d, want %d", cfg.DB.MaxOpenConns, test.want)
+			}
+			// the file only replaces what it sets
+			if cfg.DB.MaxIdleConns != 25 {
+				t.Errorf("DB.MaxIdleConns = %d, want the default 25", cfg.DB.MaxIdleConns)
+			}
+		})
+	}
+}
+
+func TestLoadFileFormats(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	want := Defaults()
+	want.DB.User = "api"
+	want.DB.Name = "school"
+	want.DB.Host = "db.internal"
+	want.DB.MaxOpenConns = 10
+	want.JWT.Secret = "s3cret"
+	want.JWT.ExpiresIn = 5 * time.Minute
+	want.TLS.MinVersion = "1.3"
+	want.TLS.CipherSuites = []string{"TLS_AES_128_GCM_SHA256"}
+	want.TLS.ClientAuth = "require"
+	want.TLS.ClientCAFile = "ca.pem"
+	want.TLS.ServiceAccounts = []ServiceAccount{{Name: "grading", Role: "exec", CommonNames: []string{"grading-job"}}}
+	want.Mail.QueueInterval = 30 * time.Second
+
+	for name, content := range map[string]string{
+		"config.yaml": testYAML,
+		"config.yml":  testYAML,
+		"config.toml": testTOML,
+	} {
+		cfg, err := Load([]string{"-config", writeConfig(t, name, content)})
+		if err != nil {
+			t.Errorf("%s: Load: %v", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(cfg, want) {
+			t.Errorf("%s: got %+v\nwant %+v", name, cfg, want)
+		}
+	}
+}
+
+func TestLoadFileErrors(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"config.yaml", "db:\n  usr: api\n", "field usr not found"},
+		{"config.toml", "[db]\nusr = \"api\"\n", "unknown setting db.usr"},
+		{"config.yaml", "db: [", "parsing"},
+		{"config.json", "{}", "expected a .yaml, .yml or .toml extension"},
+	}
+	for _, test := range tests {
+		_, err := Load([]string{"-config", writeConfig(t, test.name, test.content)})
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s %q: error = %v, want it to contain %q", test.name, test.content, err, test.want)
+		}
+	}
+
+	_, err := Load([]string{"-config", filepath.Join(t.TempDir(), "missing.yaml")})
+	if err == nil || !strings.Contains(err.Error(), "reading config file") {
+		t.Errorf("missing file: error = %v", err)
+	}
+}
+
+func TestLoadInvalidValues(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("DB_MAX_OPEN_CONNS", "many")
+	_, err := Load([]string{"-jwt.expires_in", "soon"})
+	if err == nil {
+		t.Fatal("Load accepted invalid values")
+	}
+	for _, want := range []string{`DB_MAX_OPEN_CONNS: invalid value "many"`, `-jwt.expires_in: invalid value "soon"`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error = %v, want it to contain %q", err, want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := func() Config {
+		cfg := Defaults()
+		cfg.DB.User = "api"
+		cfg.DB.Name = "school"
+		cfg.DB.Host = "db.internal"
+		cfg.JWT.Secret = "s3cret"
+		return cfg
+	}
+	if err := valid().Validate(); err != nil {
+		t.Fatalf("Validate of the base config: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		want   []string
+	}{
+		{"missing credentials", func(c *Config) { c.DB.User = ""; c.JWT.Secret = " " },
+			[]string{"DB_USER is required", "JWT_SECRET is required"}},
+		{"TLS version", func(c *Config) { c.TLS.MinVersion = "1.1" },
+			[]string{`TLS_MIN_VERSION must be one of 1.2, 1.3, got "1.1"`}},
+		{"TLS max below min", func(c *Config) { c.TLS.MinVersion = "1.3"; c.TLS.MaxVersion = "1.2" },
+			[]string{"TLS_MAX_VERSION 1.2 is below TLS_MIN_VERSION 1.3"}},
+		{"client CA", func(c *Config) { c.TLS.ClientAuth = "require" },
+			[]string{"TLS_CLIENT_CA_FILE is required"}},
+		{"plain HTTP without a port", func(c *Config) { c.TLS.Enabled = false },
+			[]string{"HTTP_PORT is required when TLS_ENABLED is false"}},
+		{"port", func(c *Config) { c.Server.Port = "3000"; c.DB.Port = "mysql" },
+			[]string{`API_PORT must look like :3000 or 0.0.0.0:3000, got "3000"`, `DB_PORT must be a port number, got "mysql"`}},
+		{"durations", func(c *Config) { c.JWT.ExpiresIn = 0; c.Mail.QueueInterval = -time.Second },
+			[]string{"JWT_EXPIRES_IN must be a positive duration", "MAIL_QUEUE_INTERVAL must be a positive duration"}},
+		{"CORS", func(c *Config) { c.CORS.AllowCredentials = true; c.CORS.AllowedOrigins = []string{"*"} },
+			[]string{`CORS_ALLOWED_ORIGINS can't contain "*"`}},
+		{"token path", func(c *Config) { c.Tokens.ResetPasswordPath = "/reset" },
+			[]string{`RESET_PASSWORD_PATH must be a path containing {token}, got "/reset"`}},
+		{"rate limit route", func(c *Config) { c.RateLimit.Routes[0].Prefix = "execs" },
+			[]string{`rate_limit.routes[0]: prefix must start with /, got "execs"`}},
+		{"sample ratio", func(c *Config) { c.Tracing.SampleRatio = 2 },
+			[]string{"OTEL_TRACES_SAMPLER_ARG must be between 0 and 1"}},
+	}
+	for _, test := range tests {
+		cfg := valid()
+		test.change(&cfg)
+		err := cfg.Validate()
+		if err == nil {
+			t.Errorf("%s: Validate accepted the config", test.name)
+			continue
+		}
+		for _, want := range test.want {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: error = %v, want it to contain %q", test.name, err, want)
+			}
+		}
+		// every problem is on its own line after the heading
+		if lines := strings.Split(err.Error(), "\n"); lines[0] != "invalid configuration:" || len(lines)-1 != len(test.want) {
+			t.Errorf("%s: error = %q, want %d problems", test.name, err, len(test.want))
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"restapi/pkg/utils"
+	"strings"
+)
+
+// keys whose values never make it into a log line, matched case-insensitively
+// anywhere in the attribute key ("new_password", "X-Api-Token", ...)
+var sensitiveKeys = []string{
+	"password", "token", "resetcode", "reset_code", "verificationcode",
+	"secret", "authorization", "cookie", "jwt", "api_key", "api-key", "apikey",
+}
+
+const redacted = "[REDACTED]"
+
+type Options struct {
+	// Level is debug, info, warn or error
+	Level string
+	// Format is json or text
+	Format string
+	Output io.Writer
+}
+
+func New(options Options) (*slog.Logger, error) {
+	var level slog.Level
+	if options.Level != "" {
+		err := level.UnmarshalText([]byte(options.Level))
+		if err != nil {
+			return nil, fmt.Errorf("invalid log level %q", options.Level)
+		}
+	}
+
+	output := options.Output
+	if output == nil {
+		output = os.Stdout
+	}
+
+	handlerOptions := &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redactAttr,
+	}
+
+	switch strings.ToLower(options.Format) {
+	case "", "json":
+		return slog.New(slog.NewJSONHandler(output, handlerOptions)), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(output, handlerOptions)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q", options.Format)
+	}
+}
+
+func IsSensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, sensitive := range sensitiveKeys {
+		if strings.Contains(key, sensitive) {
+			return true
+		}
+	}
+	return false
+}
+
+func redactAttr(_ []string, a slog.Attr) slog.Attr {
+	if IsSensitive(a.Key) {
+		return slog.String(a.Key, redacted)
+	}
+	if a.Value.Kind() == slog.KindAny {
+		switch v := a.Value.Any().(type) {
+		case map[string]any, []any:
+			return slog.Any(a.Key, Redact(v))
+		}
+	}
+	return a
+}
+
+// Redact returns a copy of a decoded JSON value with sensitive fields masked
+func Redact(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		clean := make(map[string]any, len(v))
+		for key, item := range v {
+			if IsSensitive(key) {
+				clean[key] = redacted
+			} else {
+				clean[key] = Redact(item)
+			}
+		}
+		return clean
+	case []any:
+		clean := make([]any, len(v))
+		for i, item := range v {
+			clean[i] = Redact(item)
+		}
+		return clean
+	default:
+		return v
+	}
+}
+
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, utils.ContextKey("logger"), logger)
+}
+
+// FromContext returns the request scoped logger, or the default logger
+// outside of a request
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(utils.ContextKey("logger")).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// WithRequestID stores the request id, loggers created afterwards through
+// FromContext carry it on every line
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, utils.ContextKey("requestId"), requestID)
+}
+
+// RequestID returns the id of the request being served, or ""
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(utils.ContextKey("requestId")).(string)
+	return requestID
+}
@@ -0,0 +1,37 @@
+package mailer
+
+import "sync"
+
+// CaptureMailer keeps sent messages in memory so tests can inspect them
+type CaptureMailer struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewCaptureMailer() *CaptureMailer {
+	return &CaptureMailer{}
+}
+
+func (c *CaptureMailer) Send(msg Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	msg.From = sender(msg)
+	c.messages = append(c.messages, msg)
+	return nil
+}
+
+func (c *CaptureMailer) Messages() []Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	messages := make([]Message, len(c.messages))
+	copy(messages, c.messages)
+	return messages
+}
+
+func (c *CaptureMailer) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = nil
+}
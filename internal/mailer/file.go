@@ -0,0 +1,55 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// FileMailer writes every message as an .eml file into a directory, handy
+// for local runs without an SMTP server
+type FileMailer struct {
+	dir string
+	seq atomic.Uint64
+}
+
+func NewFileMailer(dir string) (*FileMailer, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	return &FileMailer{dir: dir}, nil
+}
+
+func (f *FileMailer) Send(msg Message) error {
+	recipient := strings.NewReplacer("@", "_at_", "/", "_", "\\", "_").Replace(msg.To)
+	name := fmt.Sprintf("%s-%d-%s.eml", time.Now().Format("20060102T150405"), f.seq.Add(1), recipient)
+
+	file, err := os.Create(filepath.Join(f.dir, name))
+	if err != nil {
+		return err
+	}
+
+	_, err = toMailMessage(msg).WriteTo(file)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Ping checks that the mail directory is still there
+func (f *FileMailer) Ping(ctx context.Context) error {
+	info, err := os.Stat(f.dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", f.dir)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/go-mail/mail/v2"
+)
+
+// Message is a rendered email ready to hand to a Mailer
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// TemplateData holds the values the email templates can refer to
+type TemplateData struct {
+	Name         string
+	Username     string
+	URL          string
+	NewEmail     string
+	ValidMinutes int
+}
+
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// Pinger is implemented by mailers that depend on something that can be
+// down, such as an SMTP server
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Ping checks that m can currently deliver, mailers without a Ping method
+// are always reachable
+func Ping(ctx context.Context, m Mailer) error {
+	if pinger, ok := m.(Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+// Options selects and sets up the mailer
+type Options struct {
+	// Backend is smtp, file or capture
+	Backend string
+	// From is the sender of messages that don't set one
+	From string
+	// Dir receives the .eml files of the file backend
+	Dir string
+	// DefaultLocale is the template locale used when the requested one has
+	// no template
+	DefaultLocale string
+	SMTPHost      string
+	SMTPPort      int
+	SMTPUsername  string
+	SMTPPassword  string
+	// SMTPTLS is none, starttls or tls
+	SMTPTLS string
+}
+
+var (
+	mu            sync.Mutex
+	defaultFrom   = "schooladmin@school.com"
+	defaultLocale = "en"
+)
+
+// New builds the mailer selected by options.Backend
+func New(options Options) (Mailer, error) {
+	switch options.Backend {
+	case "", "smtp":
+		return NewSMTPMailer(options.SMTPHost, options.SMTPPort, options.SMTPUsername, options.SMTPPassword, options.SMTPTLS)
+	case "file":
+		return NewFileMailer(options.Dir)
+	case "capture":
+		return NewCaptureMailer(), nil
+	default:
+		return nil, fmt.Errorf("unknown mail backend %q", options.Backend)
+	}
+}
+
+// Configure builds the mailer and sets the sender and the fallback locale of
+// every message
+func Configure(options Options) (Mailer, error) {
+	m, err := New(options)
+	if err != nil {
+		return nil, err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if options.From != "" {
+		defaultFrom = options.From
+	}
+	if options.DefaultLocale != "" {
+		defaultLocale = strings.ToLower(options.DefaultLocale)
+	}
+	return m, nil
+}
+
+func sender(msg Message) string {
+	if msg.From != "" {
+		return msg.From
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return defaultFrom
+}
+
+func toMailMessage(msg Message) *mail.Message {
+	m := mail.NewMessage()
+	m.SetHeader("From", sender(msg))
+	m.SetHeader("To", msg.To)
+	m.SetHeader("Subject", msg.Subject)
+	m.SetBody("text/plain", msg.Text)
+	if msg.HTML != "" {
+		m.AddAlternative("text/html", msg.HTML)
+	}
+	return m
+}
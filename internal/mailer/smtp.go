@@ -0,0 +1,71 @@
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+
+	"github.com/go-mail/mail/v2"
+)
+
+type SMTPMailer struct {
+	dialer *mail.Dialer
+}
+
+// NewSMTPMailer sends through host:port, tlsMode is one of "none", "starttls"
+// (STARTTLS required) or "tls" (implicit TLS, usually port 465)
+func NewSMTPMailer(host string, port int, username, password, tlsMode string) (*SMTPMailer, error) {
+	d := mail.NewDialer(host, port, username, password)
+
+	switch tlsMode {
+	case "", "none":
+		d.StartTLSPolicy = mail.NoStartTLS
+	case "starttls":
+		d.StartTLSPolicy = mail.MandatoryStartTLS
+	case "tls":
+		d.SSL = true
+	default:
+		return nil, fmt.Errorf("unknown SMTP TLS mode %q", tlsMode)
+	}
+	if tlsMode == "starttls" || tlsMode == "tls" {
+		d.TLSConfig = &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+	}
+
+	return &SMTPMailer{dialer: d}, nil
+}
+
+func (s *SMTPMailer) Send(msg Message) error {
+	return s.dialer.DialAndSend(toMailMessage(msg))
+}
+
+// Ping greets the SMTP server and sends NOOP, without logging in or
+// upgrading to STARTTLS
+func (s *SMTPMailer) Ping(ctx context.Context) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(s.dialer.Host, strconv.Itoa(s.dialer.Port)))
+	if err != nil {
+		return err
+	}
+	if s.dialer.SSL {
+		conn = tls.Client(conn, s.dialer.TLSConfig)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, s.dialer.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	err = client.Noop()
+	if err != nil {
+		return err
+	}
+	return client.Quit()
+}
@@ -0,0 +1,100 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"regexp"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Each email has a <name>.txt.tmpl defining "subject" and "body", and a
+// <name>.html.tmpl defining "body", under templates/<locale>/
+//
+//go:embed templates
+var templateFS embed.FS
+
+// Render builds the message for the named template, falling back from the
+// requested locale to its base language and then to the default locale
+func Render(name, locale string, data TemplateData) (Message, error) {
+	for _, candidate := range localeCandidates(locale) {
+		textPath := fmt.Sprintf("templates/%s/%s.txt.tmpl", candidate, name)
+		if _, err := fs.Stat(templateFS, textPath); err != nil {
+			continue
+		}
+
+		textTmpl, err := texttemplate.ParseFS(templateFS, textPath)
+		if err != nil {
+			return Message{}, err
+		}
+
+		var msg Message
+		var buf bytes.Buffer
+		err = textTmpl.ExecuteTemplate(&buf, "subject", data)
+		if err != nil {
+			return Message{}, err
+		}
+		msg.Subject = strings.TrimSpace(buf.String())
+
+		buf.Reset()
+		err = textTmpl.ExecuteTemplate(&buf, "body", data)
+		if err != nil {
+			return Message{}, err
+		}
+		msg.Text = strings.TrimSpace(buf.String())
+
+		htmlPath := fmt.Sprintf("templates/%s/%s.html.tmpl", candidate, name)
+		if _, err := fs.Stat(templateFS, htmlPath); err == nil {
+			htmlTmpl, err := htmltemplate.ParseFS(templateFS, htmlPath)
+			if err != nil {
+				return Message{}, err
+			}
+			buf.Reset()
+			err = htmlTmpl.ExecuteTemplate(&buf, "body", data)
+			if err != nil {
+				return Message{}, err
+			}
+			msg.HTML = buf.String()
+		}
+		return msg, nil
+	}
+	return Message{}, fmt.Errorf("no email template %q for locale %q", name, locale)
+}
+
+func localeCandidates(locale string) []string {
+	var candidates []string
+	locale = strings.ToLower(strings.TrimSpace(locale))
+	if locale != "" {
+		candidates = append(candidates, locale)
+		if base, _, found := strings.Cut(locale, "-"); found {
+			candidates = append(candidates, base)
+		}
+	}
+	mu.Lock()
+	fallback := defaultLocale
+	mu.Unlock()
+	return append(candidates, fallback, "en")
+}
+
+// maxLocaleLength is the size of mail_outbox.locale
+const maxLocaleLength = 35
+
+// localeTag is the shape of a BCP 47 tag: a language of letters followed by
+// subtags of up to 8 letters or digits
+var localeTag = regexp.MustCompile(`^[A-Za-z]{1,8}(-[A-Za-z0-9]{1,8})*$`)
+
+// LocaleFromHeader picks the first language of an Accept-Language header.
+// The header comes from the client, anything that isn't a language tag
+// that fits the outbox gives "" and the default locale.
+func LocaleFromHeader(acceptLanguage string) string {
+	first, _, _ := strings.Cut(acceptLanguage, ",")
+	tag, _, _ := strings.Cut(first, ";")
+	tag = strings.TrimSpace(tag)
+	if len(tag) > maxLocaleLength || !localeTag.MatchString(tag) {
+		return ""
+	}
+	return tag
+}
@@ -0,0 +1,157 @@
+package mailer
+
+import (
+	"strings"
+	"testing"
+)
+
+var templateNames = []string{"reset", "invite", "verify_email", "email_changed"}
+
+func configureCapture(t *testing.T) *CaptureMailer {
+	t.Helper()
+
+	m, err := Configure(Options{Backend: "capture", From: "admin@school.test", DefaultLocale: "en"})
+	if err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+	return m.(*CaptureMailer)
+}
+
+func TestEveryTemplateRendersInEveryLocale(t *testing.T) {
+	data := TemplateData{
+		Name:         "Ada",
+		Username:     "ada",
+		URL:          "https://school.test/link/abc",
+		NewEmail:     "ada@new.test",
+		ValidMinutes: 10,
+	}
+	for _, locale := range []string{"en", "fr"} {
+		for _, name := range templateNames {
+			msg, err := Render(name, locale, data)
+			if err != nil {
+				t.Fatalf("Render(%q, %q): %v", name, locale, err)
+			}
+			if msg.Subject == "" || msg.Text == "" || msg.HTML == "" {
+				t.Errorf("Render(%q, %q) left a part empty: %+v", name, locale, msg)
+			}
+			if strings.Contains(msg.Text, "<no value>") || strings.Contains(msg.HTML, "<no value>") {
+				t.Errorf("Render(%q, %q) refers to a missing field", name, locale)
+			}
+		}
+	}
+}
+
+func TestRenderFallsBackThroughLocales(t *testing.T) {
+	configureCapture(t)
+
+	tests := []struct {
+		locale  string
+		subject string
+	}{
+		{"fr", "Vous êtes invité sur le portail d'administration de l'école"},
+		{"fr-CA", "Vous êtes invité sur le portail d'administration de l'école"},
+		{"FR", "Vous êtes invité sur le portail d'administration de l'école"},
+		{"de", "You have been invited to the school admin portal"},
+		{"", "You have been invited to the school admin portal"},
+	}
+	for _, test := range tests {
+		msg, err := Render("invite", test.locale, TemplateData{})
+		if err != nil {
+			t.Fatalf("Render(invite, %q): %v", test.locale, err)
+		}
+		if msg.Subject != test.subject {
+			t.Errorf("Render(invite, %q) subject = %q, want %q", test.locale, msg.Subject, test.subject)
+		}
+	}
+}
+
+func TestRenderUnknownTemplate(t *testing.T) {
+	_, err := Render("missing", "en", TemplateData{})
+	if err == nil {
+		t.Fatal("Render of an unknown template succeeded")
+	}
+}
+
+func TestHTMLBodyEscapesData(t *testing.T) {
+	msg, err := Render("invite", "en", TemplateData{Name: "<script>alert(1)</script>", URL: "javascript:alert(1)"})
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	if strings.Contains(msg.HTML, "<script>") {
+		t.Errorf("HTML body carries the name unescaped:\n%s", msg.HTML)
+	}
+	if strings.Contains(msg.HTML, `href="javascript:`) {
+		t.Errorf("HTML body links to a javascript: URL:\n%s", msg.HTML)
+	}
+}
+
+func TestVerifyEmailWording(t *testing.T) {
+	current, err := Render("verify_email", "en", TemplateData{URL: "https://school.test/v"})
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	changed, err := Render("verify_email", "en", TemplateData{URL: "https://school.test/v", NewEmail: "ada@new.test"})
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	if current.Subject != "Confirm your email address" {
+		t.Errorf("subject for the current address = %q", current.Subject)
+	}
+	if changed.Subject != "Confirm your new email address" {
+		t.Errorf("subject for a new address = %q", changed.Subject)
+	}
+}
+
+func TestRenderedMessageDeliversThroughCapture(t *testing.T) {
+	capture := configureCapture(t)
+
+	msg, err := Render("email_changed", "fr", TemplateData{NewEmail: "ada@new.test"})
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	msg.To = "ada@school.test"
+	err = capture.Send(msg)
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	messages := capture.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("captured %d messages, want 1", len(messages))
+	}
+	msg = messages[0]
+	if msg.To != "ada@school.test" || msg.From != "admin@school.test" {
+		t.Errorf("captured From %q To %q", msg.From, msg.To)
+	}
+	if msg.Subject != "Votre adresse email a été modifiée" {
+		t.Errorf("captured subject %q, want the French one", msg.Subject)
+	}
+	if !strings.Contains(msg.Text, "ada@new.test") {
+		t.Errorf("captured body doesn't name the new address:\n%s", msg.Text)
+	}
+
+	capture.Reset()
+	if len(capture.Messages()) != 0 {
+		t.Error("Reset kept the captured messages")
+	}
+}
+
+func TestLocaleFromHeader(t *testing.T) {
+	tests := map[string]string{
+		"":                              "",
+		"fr-CA,fr;q=0.9,en;q=0.8":       "fr-CA",
+		"en;q=0.8":                      "en",
+		"*":                             "",
+		" de , en":                      "de",
+		"zh-Hant-TW":                    "zh-Hant-TW",
+		strings.Repeat("a", 40):         "",
+		"en-" + strings.Repeat("x", 40): "",
+		"en_US":                         "",
+		"<script>":                      "",
+	}
+	for header, want := range tests {
+		if got := LocaleFromHeader(header); got != want {
+			t.Errorf("LocaleFromHeader(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package mailqueue
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"restapi/internal/mailer"
+	"restapi/internal/repository/sqlconnect"
+	"time"
+)
+
+const (
+	batchSize   = 20
+	lease       = 5 * time.Minute
+	baseBackoff = 30 * time.Second
+	maxBackoff  = time.Hour
+)
+
+// Worker delivers the messages queued in the mail_outbox table
+type Worker struct {
+	mailer      mailer.Mailer
+	interval    time.Duration
+	maxAttempts int
+	logger      *slog.Logger
+	stop        chan struct{}
+	done        chan struct{}
+}
+
+func NewWorker(m mailer.Mailer, interval time.Duration, maxAttempts int, logger *slog.Logger) *Worker {
+	return &Worker{
+		mailer:      m,
+		interval:    interval,
+		maxAttempts: maxAttempts,
+		logger:      logger,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+func (w *Worker) Start() {
+	go w.run()
+}
+
+// Stop waits for the batch in flight to finish
+func (w *Worker) Stop() {
+	close(w.stop)
+	<-w.done
+}
+
+func (w *Worker) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		w.processBatch()
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *Worker) processBatch() {
+	ctx := context.Background()
+	emails, err := sqlconnect.ClaimDueEmails(ctx, batchSize, lease)
+	if err != nil {
+		w.logger.Error("claiming queued emails failed", "err", err)
+		return
+	}
+
+	for _, email := range emails {
+		// rendering issues the token of the link, right before the send
+		msg, err := sqlconnect.RenderOutboxEmail(ctx, email)
+		if errors.Is(err, sqlconnect.ErrOutboxEmailObsolete) {
+			w.logger.Info("dropping email that no longer applies", "email_id", email.ID, "template", email.Template)
+			markErr := sqlconnect.MarkEmailFailed(ctx, email.ID, err, time.Now(), true)
+			if markErr != nil {
+				w.logger.Error("recording obsolete email failed", "email_id", email.ID, "err", markErr)
+			}
+			continue
+		}
+		if err == nil {
+			err = w.mailer.Send(msg)
+		}
+		if err == nil {
+			err = sqlconnect.MarkEmailSent(ctx, email.ID)
+			if err != nil {
+				w.logger.Error("marking email as sent failed", "email_id", email.ID, "err", err)
+			}
+			continue
+		}
+
+		attempts := email.Attempts + 1
+		dead := attempts >= w.maxAttempts
+		if dead {
+			w.logger.Warn("giving up on email", "email_id", email.ID, "attempts", attempts, "err", err)
+		} else {
+			w.logger.Info("email delivery failed, will retry", "email_id", email.ID, "attempts", attempts, "err", err)
+		}
+
+		markErr := sqlconnect.MarkEmailFailed(ctx, email.ID, err, time.Now().Add(backoff(attempts)), dead)
+		if markErr != nil {
+			w.logger.Error("recording failed delivery failed", "email_id", email.ID, "err", errors.Join(err, markErr))
+		}
+	}
+}
+
+// backoff doubles the wait after every failed attempt, up to maxBackoff
+func backoff(attempts int) time.Duration {
+	wait := baseBackoff
+	for i := 1; i < attempts && wait < maxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, maxBackoff)
+}
@@ -0,0 +1,333 @@
+// Package metrics is a small Prometheus client: counters, histograms and
+// values read at scrape time, served in the text exposition format.
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultBuckets suit request latencies in seconds
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type collector interface {
+	name() string
+	write(ctx context.Context, w io.Writer) error
+}
+
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Default is the registry the application metrics are registered on
+var Default = NewRegistry()
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.collectors {
+		if existing.name() == c.name() {
+			panic("metrics: duplicate metric " + c.name())
+		}
+	}
+	r.collectors = append(r.collectors, c)
+}
+
+// Handler serves every registered metric. A metric that fails to collect,
+// say because the database is down, is left out of the scrape.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mu.Lock()
+		collectors := slices.Clone(r.collectors)
+		r.mu.Unlock()
+		sort.Slice(collectors, func(i, j int) bool { return collectors[i].name() < collectors[j].name() })
+
+		var body strings.Builder
+		for _, c := range collectors {
+			var metric strings.Builder
+			err := c.write(req.Context(), &metric)
+			if err != nil {
+				slog.Default().Warn("collecting metric failed", "metric", c.name(), "err", err)
+				continue
+			}
+			body.WriteString(metric.String())
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		io.WriteString(w, body.String())
+	})
+}
+
+// labelSet keys the children of a vector by their label values
+type labelSet struct {
+	names []string
+}
+
+func (l labelSet) key(values []string) string {
+	if len(values) != len(l.names) {
+		panic(fmt.Sprintf("metrics: expected %d label values, got %d", len(l.names), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// format renders {a="x",b="y"}, extra is appended as is (the le label of a
+// histogram bucket)
+func (l labelSet) format(key string, extra string) string {
+	var pairs []string
+	if len(l.names) > 0 {
+		for i, value := range strings.Split(key, "\xff") {
+			pairs = append(pairs, l.names[i]+`="`+escapeLabel(value)+`"`)
+		}
+	}
+	if extra != "" {
+		pairs = append(pairs, extra)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, strings.ReplaceAll(help, "\n", " "), name, kind)
+}
+
+// sortedKeys keeps the output stable between scrapes
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// CounterVec counts events, split by the values of its labels
+type CounterVec struct {
+	metricName string
+	help       string
+	labels     labelSet
+	mu         sync.RWMutex
+	values     map[string]*atomic.Uint64
+}
+
+func (r *Registry) NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	c := &CounterVec{
+		metricName: name,
+		help:       help,
+		labels:     labelSet{names: labelNames},
+		values:     make(map[string]*atomic.Uint64),
+	}
+	r.register(c)
+	return c
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	key := c.labels.key(labelValues)
+
+	c.mu.RLock()
+	value, ok := c.values[key]
+	c.mu.RUnlock()
+	if !ok {
+		c.mu.Lock()
+		value, ok = c.values[key]
+		if !ok {
+			value = &atomic.Uint64{}
+			c.values[key] = value
+		}
+		c.mu.Unlock()
+	}
+	value.Add(1)
+}
+
+func (c *CounterVec) name() string {
+	return c.metricName
+}
+
+func (c *CounterVec) write(ctx context.Context, w io.Writer) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	writeHeader(w, c.metricName, c.help, "counter")
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %d\n", c.metricName, c.labels.format(key, ""), c.values[key].Load())
+	}
+	return nil
+}
+
+// HistogramVec tracks the distribution of observed values, split by the
+// values of its labels
+type HistogramVec struct {
+	metricName string
+	help       string
+	labels     labelSet
+	buckets    []float64
+	mu         sync.Mutex
+	values     map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	buckets = slices.Clone(buckets)
+	slices.Sort(buckets)
+	h := &HistogramVec{
+		metricName: name,
+		help:       help,
+		labels:     labelSet{names: labelNames},
+		buckets:    buckets,
+		values:     make(map[string]*histogram),
+	}
+	r.register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	key := h.labels.key(labelValues)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	entry, ok := h.values[key]
+	if !ok {
+		entry = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = entry
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			entry.counts[i]++
+		}
+	}
+	entry.count++
+	entry.sum += value
+}
+
+func (h *HistogramVec) name() string {
+	return h.metricName
+}
+
+func (h *HistogramVec) write(ctx context.Context, w io.Writer) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	writeHeader(w, h.metricName, h.help, "histogram")
+	for _, key := range sortedKeys(h.values) {
+		entry := h.values[key]
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, h.labels.format(key, `le="`+formatFloat(bound)+`"`), entry.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, h.labels.format(key, `le="+Inf"`), entry.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.metricName, h.labels.format(key, ""), formatFloat(entry.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.metricName, h.labels.format(key, ""), entry.count)
+	}
+	return nil
+}
+
+// funcMetric is read at scrape time. fn returns one value per value of the
+// label, or a single value under "" when label is empty.
+type funcMetric struct {
+	metricName string
+	help       string
+	kind       string
+	labels     labelSet
+	fn         func(ctx context.Context) (map[string]float64, error)
+}
+
+// NewGaugeFunc registers a gauge whose values come from fn on every scrape
+func (r *Registry) NewGaugeFunc(name, help, label string, fn func(ctx context.Context) (map[string]float64, error)) {
+	r.register(newFuncMetric(name, help, "gauge", label, fn))
+}
+
+// NewCounterFunc registers a counter kept elsewhere, such as the totals in
+// sql.DBStats
+func (r *Registry) NewCounterFunc(name, help, label string, fn func(ctx context.Context) (map[string]float64, error)) {
+	r.register(newFuncMetric(name, help, "counter", label, fn))
+}
+
+func newFuncMetric(name, help, kind, label string, fn func(ctx context.Context) (map[string]float64, error)) *funcMetric {
+	var labels labelSet
+	if label != "" {
+		labels.names = []string{label}
+	}
+	return &funcMetric{metricName: name, help: help, kind: kind, labels: labels, fn: fn}
+}
+
+func (f *funcMetric) name() string {
+	return f.metricName
+}
+
+func (f *funcMetric) write(ctx context.Context, w io.Writer) error {
+	values, err := f.fn(ctx)
+	if err != nil {
+		return err
+	}
+
+	writeHeader(w, f.metricName, f.help, f.kind)
+	for _, key := range sortedKeys(values) {
+		fmt.Fprintf(w, "%s%s %s\n", f.metricName, f.labels.format(key, ""), formatFloat(values[key]))
+	}
+	return nil
+}
+
+// Cached runs fn at most once per ttl, the scrapes in between get the
+// values it returned last. Errors aren't kept, the next scrape tries again.
+func Cached(ttl time.Duration, fn func(ctx context.Context) (map[string]float64, error)) func(ctx context.Context) (map[string]float64, error) {
+	var mu sync.Mutex
+	var values map[string]float64
+	var readAt time.Time
+	return func(ctx context.Context) (map[string]float64, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if values != nil && time.Since(readAt) < ttl {
+			return values, nil
+		}
+		fresh, err := fn(ctx)
+		if err != nil {
+			return nil, err
+		}
+		values, readAt = fresh, time.Now()
+		return values, nil
+	}
+}
+
+// Single is a convenience for funcs of unlabelled metrics
+func Single(value float64) map[string]float64 {
+	return map[string]float64{"": value}
+}
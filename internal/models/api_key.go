@@ -0,0 +1,41 @@
+package models
+
+// API key scopes, a write scope also grants the read scope of the resource
+const (
+	ScopeStudentsRead  = "students:read"
+	ScopeStudentsWrite = "students:write"
+	ScopeTeachersRead  = "teachers:read"
+	ScopeTeachersWrite = "teachers:write"
+)
+
+var APIKeyScopes = []string{ScopeStudentsRead, ScopeStudentsWrite, ScopeTeachersRead, ScopeTeachersWrite}
+
+type APIKey struct {
+	ID 			int		`json:"id,omitempty" db:"id,omitempty"`
+	Name 		string	`json:"name,omitempty" db:"name,omitempty"`
+	Prefix 		string	`json:"prefix,omitempty" db:"prefix,omitempty"`
+	KeyHash		string	`json:"-" db:"key_hash,omitempty"`
+	Scopes		[]string	`json:"scopes,omitempty" db:"scopes,omitempty"`
+	CreatedBy		string	`json:"created_by,omitempty" db:"created_by,omitempty"`
+	CreatedAt		string	`json:"created_at,omitempty" db:"created_at,omitempty"`
+	ExpiresAt		*string	`json:"expires_at,omitempty" db:"expires_at,omitempty"`
+	LastUsedAt		*string	`json:"last_used_at,omitempty" db:"last_used_at,omitempty"`
+	RevokedAt		*string	`json:"revoked_at,omitempty" db:"revoked_at,omitempty"`
+	RotatedFrom		*int	`json:"rotated_from,omitempty" db:"rotated_from,omitempty"`
+	// Key is the secret itself, it is only returned when the key is created
+	// or rotated and never stored
+	Key		string	`json:"key,omitempty" db:"-"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name		string	`json:"name,omitempty"`
+	Scopes		[]string	`json:"scopes,omitempty"`
+	// ExpiresAt is RFC 3339, keys without one don't expire
+	ExpiresAt		string	`json:"expires_at,omitempty"`
+}
+
+type RotateAPIKeyRequest struct {
+	// GracePeriod keeps the old key working while integrations switch over,
+	// e.g. "24h"
+	GracePeriod		string	`json:"grace_period,omitempty"`
+}
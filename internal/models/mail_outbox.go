@@ -0,0 +1,32 @@
+package models
+
+const (
+	OutboxStatusPending = "pending"
+	OutboxStatusSending = "sending"
+	OutboxStatusSent    = "sent"
+	OutboxStatusDead    = "dead"
+)
+
+// token purposes of the outbox, the worker issues the token when it sends
+// the email
+const (
+	OutboxTokenReset             = "reset"
+	OutboxTokenInvite            = "invite"
+	OutboxTokenEmailVerification = "verify_email"
+)
+
+type OutboxEmail struct {
+	ID 			int		`json:"id,omitempty" db:"id,omitempty"`
+	Recipient 	string	`json:"recipient,omitempty" db:"recipient,omitempty"`
+	Template	string	`json:"template,omitempty" db:"template,omitempty"`
+	Locale		string	`json:"locale,omitempty" db:"locale,omitempty"`
+	TemplateData		*string	`json:"-" db:"template_data,omitempty"`
+	TokenPurpose		*string	`json:"-" db:"token_purpose,omitempty"`
+	ExecID		*int	`json:"-" db:"exec_id,omitempty"`
+	Status		string	`json:"status,omitempty" db:"status,omitempty"`
+	Attempts	int		`json:"attempts" db:"attempts"`
+	NextAttemptAt		string	`json:"next_attempt_at,omitempty" db:"next_attempt_at,omitempty"`
+	LastError		*string	`json:"last_error,omitempty" db:"last_error,omitempty"`
+	CreatedAt		string	`json:"created_at,omitempty" db:"created_at,omitempty"`
+	SentAt		*string	`json:"sent_at,omitempty" db:"sent_at,omitempty"`
+}
@@ -0,0 +1,289 @@
+package sqlconnect
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"restapi/internal/models"
+	"restapi/pkg/utils"
+	"strings"
+	"time"
+)
+
+const (
+	apiKeyColumns = "id, name, prefix, key_hash, scopes, created_by, created_at, expires_at, last_used_at, revoked_at, rotated_from"
+
+	// apiKeyMarker starts every key, so leaked keys are easy to grep for
+	apiKeyMarker = "sk_"
+
+	// last_used_at is written at most this often per key
+	apiKeyTouchInterval = time.Minute
+)
+
+var (
+	ErrInvalidAPIKey = errors.New("invalid API key")
+	ErrAPIKeyExpired = errors.New("API key expired")
+)
+
+// generateAPIKey returns a new key, sk_<prefix>_<secret>, with its prefix
+// and the sha256 hash of the whole key that gets stored. The prefix is
+// stored in clear to find the key and to tell keys apart in listings.
+func generateAPIKey() (key, prefix, hash string, err error) {
+	prefixBytes := make([]byte, 6)
+	_, err = rand.Read(prefixBytes)
+	if err != nil {
+		return "", "", "", err
+	}
+	secretBytes := make([]byte, 32)
+	_, err = rand.Read(secretBytes)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	prefix = hex.EncodeToString(prefixBytes)
+	key = apiKeyMarker + prefix + "_" + hex.EncodeToString(secretBytes)
+	return key, prefix, hashAPIKey(key), nil
+}
+
+func hashAPIKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+func scanAPIKey(row interface{ Scan(...any) error }) (models.APIKey, error) {
+	var key models.APIKey
+	var scopes string
+	err := row.Scan(&key.ID, &key.Name, &key.Prefix, &key.KeyHash, &scopes, &key.CreatedBy, &key.CreatedAt, &key.ExpiresAt, &key.LastUsedAt, &key.RevokedAt, &key.RotatedFrom)
+	key.Scopes = strings.Split(scopes, ",")
+	return key, err
+}
+
+func GetAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+
+	db, err := ConnectDb()
+	if err != nil {
+		return nil, dbError(err, "error retrieving data")
+	}
+
+	rows, err := db.QueryContext(ctx, tagQuery(ctx, "SELECT "+apiKeyColumns+" FROM api_keys ORDER BY id DESC"))
+	if err != nil {
+		return nil, dbError(err, "error retrieving data")
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, dbError(err, "error retrieving data")
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func GetAPIKeyByID(ctx context.Context, id int) (models.APIKey, error) {
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+
+	db, err := ConnectDb()
+	if err != nil {
+		return models.APIKey{}, dbError(err, "error retrieving data")
+	}
+
+	key, err := scanAPIKey(db.QueryRowContext(ctx, tagQuery(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE id = ?"), id))
+	if err == sql.ErrNoRows {
+		return models.APIKey{}, utils.ErrorHandler(err, "API key not found")
+	} else if err != nil {
+		return models.APIKey{}, dbError(err, "error retrieving data")
+	}
+	return key, nil
+}
+
+// AddAPIKey stores a new key and returns it with the secret, which can't be
+// recovered afterwards
+func AddAPIKey(ctx context.Context, key models.APIKey) (models.APIKey, error) {
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	db, err := ConnectDb()
+	if err != nil {
+		return models.APIKey{}, dbError(err, "error adding data")
+	}
+
+	return insertAPIKey(ctx, db, key)
+}
+
+// insertAPIKey runs on the pool or inside a transaction
+func insertAPIKey(ctx context.Context, db execer, key models.APIKey) (models.APIKey, error) {
+	secret, prefix, hash, err := generateAPIKey()
+	if err != nil {
+		return models.APIKey{}, utils.ErrorHandler(err, "error adding data")
+	}
+	key.Key = secret
+	key.Prefix = prefix
+	key.KeyHash = hash
+	key.CreatedAt = time.Now().Format(time.RFC3339)
+
+	result, err := db.ExecContext(ctx, tagQuery(ctx, "INSERT INTO api_keys (name, prefix, key_hash, scopes, created_by, created_at, expires_at, rotated_from) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"),
+		key.Name, key.Prefix, key.KeyHash, strings.Join(key.Scopes, ","), key.CreatedBy, key.CreatedAt, key.ExpiresAt, key.RotatedFrom)
+	if err != nil {
+		return models.APIKey{}, dbError(err, "error adding data")
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return models.APIKey{}, dbError(err, "error adding data")
+	}
+	key.ID = int(id)
+	return key, nil
+}
+
+// RevokeAPIKey stops a key from authenticating straight away
+func RevokeAPIKey(ctx context.Context, id int) error {
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	db, err := ConnectDb()
+	if err != nil {
+		return dbError(err, "error updating data")
+	}
+
+	result, err := db.ExecContext(ctx, tagQuery(ctx, "UPDATE api_keys SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL"), time.Now().Format(time.RFC3339), id)
+	if err != nil {
+		return dbError(err, "error updating data")
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return dbError(err, "error updating data")
+	}
+	if rowsAffected == 0 {
+		return utils.ErrorHandler(sql.ErrNoRows, "API key not found or already revoked")
+	}
+	return nil
+}
+
+// RotateAPIKey issues a new secret with the name, scopes and expiry of key
+// id. The old key keeps working for the grace period, so integrations can
+// switch over without downtime.
+func RotateAPIKey(ctx context.Context, id int, gracePeriod time.Duration, rotatedBy string) (models.APIKey, error) {
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	db, err := ConnectDb()
+	if err != nil {
+		return models.APIKey{}, dbError(err, "error updating data")
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.APIKey{}, dbError(err, "error updating data")
+	}
+
+	old, err := scanAPIKey(tx.QueryRowContext(ctx, tagQuery(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE id = ? FOR UPDATE"), id))
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return models.APIKey{}, utils.ErrorHandler(err, "API key not found")
+	} else if err != nil {
+		tx.Rollback()
+		return models.APIKey{}, dbError(err, "error updating data")
+	}
+
+	now := time.Now()
+	if old.RevokedAt != nil {
+		tx.Rollback()
+		return models.APIKey{}, utils.ErrorHandler(errors.New("API key is revoked"), "a revoked API key can't be rotated")
+	}
+	if apiKeyExpired(old, now) {
+		tx.Rollback()
+		return models.APIKey{}, utils.ErrorHandler(ErrAPIKeyExpired, "an expired API key can't be rotated, create a new one")
+	}
+
+	key, err := insertAPIKey(ctx, tx, models.APIKey{
+		Name:        old.Name,
+		Scopes:      old.Scopes,
+		CreatedBy:   rotatedBy,
+		ExpiresAt:   old.ExpiresAt,
+		RotatedFrom: &old.ID,
+	})
+	if err != nil {
+		tx.Rollback()
+		return models.APIKey{}, err
+	}
+
+	// the grace period only ever shortens the life of the old key
+	graceEnd := now.Add(gracePeriod)
+	if old.ExpiresAt == nil || graceEnd.Before(parseTime(*old.ExpiresAt)) {
+		_, err = tx.ExecContext(ctx, tagQuery(ctx, "UPDATE api_keys SET expires_at = ? WHERE id = ?"), graceEnd.Format(time.RFC3339), old.ID)
+		if err != nil {
+			tx.Rollback()
+			return models.APIKey{}, dbError(err, "error updating data")
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return models.APIKey{}, dbError(err, "error updating data")
+	}
+	return key, nil
+}
+
+// AuthenticateAPIKey returns the key matching the secret, ErrInvalidAPIKey
+// for unknown, malformed or revoked keys and ErrAPIKeyExpired once it has
+// expired
+func AuthenticateAPIKey(ctx context.Context, secret string) (models.APIKey, error) {
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	prefix, _, ok := strings.Cut(strings.TrimPrefix(secret, apiKeyMarker), "_")
+	if !ok || !strings.HasPrefix(secret, apiKeyMarker) {
+		return models.APIKey{}, ErrInvalidAPIKey
+	}
+
+	db, err := ConnectDb()
+	if err != nil {
+		return models.APIKey{}, dbError(err, "internal error")
+	}
+
+	key, err := scanAPIKey(db.QueryRowContext(ctx, tagQuery(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE prefix = ?"), prefix))
+	if err == sql.ErrNoRows {
+		return models.APIKey{}, ErrInvalidAPIKey
+	} else if err != nil {
+		return models.APIKey{}, dbError(err, "database error")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashAPIKey(secret)), []byte(key.KeyHash)) != 1 || key.RevokedAt != nil {
+		return models.APIKey{}, ErrInvalidAPIKey
+	}
+	now := time.Now()
+	if apiKeyExpired(key, now) {
+		return models.APIKey{}, ErrAPIKeyExpired
+	}
+
+	if key.LastUsedAt == nil || now.Sub(parseTime(*key.LastUsedAt)) >= apiKeyTouchInterval {
+		lastUsedAt := now.Format(time.RFC3339)
+		key.LastUsedAt = &lastUsedAt
+		_, err = db.ExecContext(ctx, tagQuery(ctx, "UPDATE api_keys SET last_used_at = ? WHERE id = ?"), lastUsedAt, key.ID)
+		if err != nil {
+			// the request can go on, the timestamp is caught up next time
+			loggerFor(ctx).Warn("recording API key use failed", "key_id", key.ID, "err", err)
+		}
+	}
+	return key, nil
+}
+
+func apiKeyExpired(key models.APIKey, now time.Time) bool {
+	return key.ExpiresAt != nil && !now.Before(parseTime(*key.ExpiresAt))
+}
+
+// parseTime reads the RFC 3339 timestamps stored in VARCHAR columns, a value
+// that doesn't parse reads as the zero time
+func parseTime(value string) time.Time {
+	t, _ := time.Parse(time.RFC3339, value)
+	return t
+}
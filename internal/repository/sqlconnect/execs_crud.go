@@ -1,48 +1,52 @@
 package sqlconnect
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
-	"fmt"
-	"log"
+	"errors"
 	"net/http"
-	"os"
+	"net/url"
 	"reflect"
+	"restapi/internal/mailer"
 	"restapi/internal/models"
 	"restapi/pkg/utils"
 	"strconv"
+	"strings"
 	"time"
-
-	"github.com/go-mail/mail/v2"
 )
 
-func GetExecByID(id int) (models.Exec, error) {
+func GetExecByID(ctx context.Context, id int) (models.Exec, error) {
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+
 	db, err := ConnectDb()
 
 	if err != nil {
-		return models.Exec{}, utils.ErrorHandler(err, "error retrieving data")
+		return models.Exec{}, dbError(err, "error retrieving data")
 	}
-	defer db.Close()
 
 	var exec models.Exec
-	err = db.QueryRow("SELECT * FROM execs WHERE id = ?", id).Scan(&exec.ID, &exec.FirstName, &exec.LastName, &exec.Email, &exec.Username, &exec.UserCreatedAt, &exec.InactiveStatus, &exec.Role)
+	err = db.QueryRowContext(ctx, tagQuery(ctx, "SELECT * FROM execs WHERE id = ?"), id).Scan(&exec.ID, &exec.FirstName, &exec.LastName, &exec.Email, &exec.Username, &exec.UserCreatedAt, &exec.InactiveStatus, &exec.Role)
 	if err == sql.ErrNoRows {
-		return models.Exec{}, utils.ErrorHandler(err, "error retrieving data")
+		return models.Exec{}, dbError(err, "error retrieving data")
 	} else if err != nil {
-		return models.Exec{}, utils.ErrorHandler(err, "error retrieving data")
+		return models.Exec{}, dbError(err, "error retrieving data")
 	}
 	return exec, nil
 }
 
 func GetExecsDBHandler(execs []models.Exec, r *http.Request) ([]models.Exec, error) {
+	ctx, cancel := withReadTimeout(r.Context())
+	defer cancel()
+
 	db, err := ConnectDb()
 
 	if err != nil {
-		return nil, utils.ErrorHandler(err, "error retrieving data")
+		return nil, dbError(err, "error retrieving data")
 	}
-	defer db.Close()
 
 	query := "SELECT * FROM execs WHERE 1=1";
 	var args []interface{}
@@ -50,10 +54,10 @@ func GetExecsDBHandler(execs []models.Exec, r *http.Request) ([]models.Exec, err
 
 	query = utils.AddSorting(r, query)
 
-	rows, err := db.Query(query, args...)
+	rows, err := db.QueryContext(ctx, tagQuery(ctx, query), args...)
 	if err != nil {
-		fmt.Println(err)
-		return nil, utils.ErrorHandler(err, "error retrieving data")
+		loggerFor(ctx).Error("query failed", "err", err)
+		return nil, dbError(err, "error retrieving data")
 	}
 	defer rows.Close()
 
@@ -62,84 +66,212 @@ func GetExecsDBHandler(execs []models.Exec, r *http.Request) ([]models.Exec, err
 		var exec models.Exec
 		err := rows.Scan(&exec.ID, &exec.FirstName, &exec.LastName, &exec.Email, &exec.Username, &exec.UserCreatedAt, &exec.InactiveStatus, &exec.Role)
 		if err != nil {
-			return nil, utils.ErrorHandler(err, "error retrieving data")
+			return nil, dbError(err, "error retrieving data")
 		}
 		execs = append(execs, exec)
 	}
 	return execs, nil
 }
 
-func AddExecsDBHandler(newExecs []models.Exec) ([]models.Exec, error) {
+// AddExecsDBHandler creates the execs and invites each of them in locale
+func AddExecsDBHandler(ctx context.Context, newExecs []models.Exec, locale string) ([]models.Exec, error) {
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
 	db, err := ConnectDb()
 
 	if err != nil {
-		return nil, utils.ErrorHandler(err, "error adding data")
+		return nil, dbError(err, "error adding data")
 	}
-	defer db.Close()
 
-	stmt, err := db.Prepare(utils.GenerateInsertQuery("execs", models.Exec{}))
+	// the execs and their invitations commit together, the mail worker only
+	// sees the invitations once every exec of the request is in
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, utils.ErrorHandler(err, "error adding data")
+		return nil, dbError(err, "error adding data")
+	}
+
+	stmt, err := tx.PrepareContext(ctx, tagQuery(ctx, utils.GenerateInsertQuery("execs", models.Exec{})))
+	if err != nil {
+		tx.Rollback()
+		return nil, dbError(err, "error adding data")
 	}
 	defer stmt.Close()
 
 	addedExecs := make([]models.Exec, len(newExecs))
 	for i, newExec := range newExecs {
-		newExec.Password, err = utils.HashPassword(newExec.Password)
+		// execs set their own password through the invitation link, until then
+		// the account carries a random password nobody knows
+		placeholder, _, err := generateResetToken()
 		if err != nil {
-			return nil, utils.ErrorHandler(err, "error adding exec into database")
+			tx.Rollback()
+			return nil, dbError(err, "error adding exec into database")
+		}
+		newExec.Password, err = utils.HashPassword(placeholder)
+		if err != nil {
+			tx.Rollback()
+			return nil, dbError(err, "error adding exec into database")
 		}
 
 		values := utils.GetStructValues(newExec)
-		res, err := stmt.Exec(values...)
+		res, err := stmt.ExecContext(ctx, values...)
 		if err != nil {
-			return nil, utils.ErrorHandler(err, "error adding data")
+			tx.Rollback()
+			return nil, dbError(err, "error adding data")
 		}
 		lastId, err := res.LastInsertId()
 		if err != nil {
-			return nil, utils.ErrorHandler(err, "error adding data")
+			tx.Rollback()
+			return nil, dbError(err, "error adding data")
 		}
 		newExec.ID = int(lastId)
+		newExec.Password = ""
 		addedExecs[i] = newExec
 	}
+
+	for _, newExec := range addedExecs {
+		err = sendInvitation(ctx, tx, newExec, locale)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, dbError(err, "error adding data")
+	}
 	return addedExecs, nil
 }
 
-func PatchExecs(updates []map[string]interface{}) error {
+// sendInvitation queues the invitation of a new exec, the link to set a
+// password is issued when the email goes out
+func sendInvitation(ctx context.Context, db execer, exec models.Exec, locale string) error {
+	err := queueEmail(ctx, db, exec.Email, "invite", locale, mailer.TemplateData{
+		Name: exec.FirstName,
+		Username: exec.Username,
+	}, models.OutboxTokenInvite, exec.ID)
+	if err != nil {
+		return dbError(err, "failed to send invitation email")
+	}
+	return nil
+}
+
+// SetExecInactiveStatus deactivates or reactivates an exec. Deactivating
+// also revokes every session the exec holds, for good: the tokens stay
+// refused after a reactivation.
+func SetExecInactiveStatus(ctx context.Context, id int, inactive bool) error {
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
 	db, err := ConnectDb()
 	if err != nil {
-		return utils.ErrorHandler(err, "error updating data")
+		return dbError(err, "error updating data")
 	}
-	defer db.Close()
 
-	tx, err := db.Begin()
+	query := "UPDATE execs SET inactive_status = ? WHERE id = ?"
+	args := []interface{}{inactive, id}
+	if inactive {
+		query = "UPDATE execs SET inactive_status = ?, sessions_revoked_at = ? WHERE id = ?"
+		args = []interface{}{inactive, time.Now().UTC().Format(time.RFC3339), id}
+	}
+	result, err := db.ExecContext(ctx, tagQuery(ctx, query), args...)
+	if err != nil {
+		return dbError(err, "error updating data")
+	}
+
+	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		return utils.ErrorHandler(err, "error updating data")
+		return dbError(err, "error updating data")
+	}
+
+	if rowsAffected == 0 {
+		var exists bool
+		err = db.QueryRowContext(ctx, tagQuery(ctx, "SELECT EXISTS(SELECT 1 FROM execs WHERE id = ?)"), id).Scan(&exists)
+		if err != nil {
+			return dbError(err, "error updating data")
+		}
+		if !exists {
+			return utils.ErrorHandler(sql.ErrNoRows, "exec not found")
+		}
+	}
+	return nil
+}
+
+// ExecSessionState tells whether an exec may still use a session token
+type ExecSessionState struct {
+	Inactive bool
+	// RevokedAt refuses the tokens issued at or before it, zero when the
+	// sessions were never revoked
+	RevokedAt time.Time
+}
+
+func GetExecSessionState(ctx context.Context, id int) (ExecSessionState, error) {
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+
+	db, err := ConnectDb()
+	if err != nil {
+		return ExecSessionState{}, dbError(err, "internal error")
+	}
+
+	var state ExecSessionState
+	var revokedAt sql.NullString
+	err = db.QueryRowContext(ctx, tagQuery(ctx, "SELECT inactive_status, sessions_revoked_at FROM execs WHERE id = ?"), id).Scan(&state.Inactive, &revokedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ExecSessionState{}, dbError(err, "exec not found")
+		}
+		return ExecSessionState{}, dbError(err, "database error")
+	}
+	if revokedAt.Valid {
+		state.RevokedAt = parseTime(revokedAt.String)
+	}
+	return state, nil
+}
+
+// PatchExecs applies the updates in one transaction, verification emails for
+// changed addresses go out in locale
+func PatchExecs(ctx context.Context, updates []map[string]interface{}, locale string) error {
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	db, err := ConnectDb()
+	if err != nil {
+		return dbError(err, "error updating data")
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return dbError(err, "error updating data")
 	}
 
 	for _, update := range updates {
 		idStr, ok := update["id"].(string)
 		if !ok {
 			tx.Rollback()
-			return utils.ErrorHandler(err, "error updating data")
+			return dbError(err, "error updating data")
 		}
 
 		id, err := strconv.Atoi(idStr)
 		if err != nil {
 			tx.Rollback()
-			return utils.ErrorHandler(err, "invalid id")
+			return dbError(err, "invalid id")
 		}
 
 		var execFromDb models.Exec
-		err = db.QueryRow("SELECT * FROM execs WHERE id = ?", id).Scan(&execFromDb.ID, &execFromDb.FirstName, &execFromDb.LastName, &execFromDb.Email, &execFromDb.Username, &execFromDb.UserCreatedAt, &execFromDb.InactiveStatus, &execFromDb.Role)
+		err = db.QueryRowContext(ctx, tagQuery(ctx, "SELECT * FROM execs WHERE id = ?"), id).Scan(&execFromDb.ID, &execFromDb.FirstName, &execFromDb.LastName, &execFromDb.Email, &execFromDb.Username, &execFromDb.UserCreatedAt, &execFromDb.InactiveStatus, &execFromDb.Role)
 		if err != nil {
 			tx.Rollback()
 			if err == sql.ErrNoRows {
-				return utils.ErrorHandler(err, "Exec not found")
+				return dbError(err, "Exec not found")
 			}
-			return utils.ErrorHandler(err, "error updating data")
+			return dbError(err, "error updating data")
 		}
 
+		// email changes only go through once the new address is confirmed
+		newEmail, emailChanged := pendingEmailChange(update, execFromDb.Email)
+
 		execVal := reflect.ValueOf(&execFromDb).Elem()
 		execType := execVal.Type()
 
@@ -157,8 +289,8 @@ func PatchExecs(updates []map[string]interface{}) error {
 							fieldVal.Set(val.Convert(fieldVal.Type()))
 						} else {
 							tx.Rollback()
-							log.Printf("cannot convert %v to %v", val.Type(), fieldVal.Type())
-							return utils.ErrorHandler(err, "error updating data")
+							loggerFor(ctx).Warn("cannot convert patch value", "field", k, "from", val.Type().String(), "to", fieldVal.Type().String())
+							return dbError(err, "error updating data")
 						}
 					}
 					break
@@ -166,36 +298,48 @@ func PatchExecs(updates []map[string]interface{}) error {
 			}
 		}
 
-		_, err = tx.Exec("UPDATE execs SET first_name = ?, last_name = ?, email = ?, username = ? WHERE id = ?", execFromDb.FirstName, execFromDb.LastName, execFromDb.Email, &execFromDb.Username, execFromDb.ID)
+		_, err = tx.ExecContext(ctx, tagQuery(ctx, "UPDATE execs SET first_name = ?, last_name = ?, email = ?, username = ? WHERE id = ?"), execFromDb.FirstName, execFromDb.LastName, execFromDb.Email, &execFromDb.Username, execFromDb.ID)
 		if err != nil {
 			tx.Rollback()
-			return utils.ErrorHandler(err, "error updating data")
+			return dbError(err, "error updating data")
+		}
+
+		if emailChanged {
+			err = requestEmailChange(ctx, tx, execFromDb.ID, newEmail, locale)
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
 		}
 	}
 	err = tx.Commit()
 	if err != nil {
-		return utils.ErrorHandler(err, "error updating data")
+		return dbError(err, "error updating data")
 	}
 	return nil
 }
 
-func PatchExec(id int, updates map[string]interface{}) (models.Exec, error) {
+func PatchExec(ctx context.Context, id int, updates map[string]interface{}, locale string) (models.Exec, error) {
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
 	db, err := ConnectDb()
 	if err != nil {
-		log.Println(err)
-		return models.Exec{}, utils.ErrorHandler(err, "error updating data")
+		loggerFor(ctx).Error("database connection failed", "err", err)
+		return models.Exec{}, dbError(err, "error updating data")
 	}
-	defer db.Close()
 
 	var existingExec models.Exec
-	err = db.QueryRow("SELECT * FROM execs WHERE id = ?", id).Scan(&existingExec.ID, &existingExec.FirstName, &existingExec.LastName, &existingExec.Email, &existingExec.Username, &existingExec.UserCreatedAt, &existingExec.InactiveStatus, &existingExec.Role)
+	err = db.QueryRowContext(ctx, tagQuery(ctx, "SELECT * FROM execs WHERE id = ?"), id).Scan(&existingExec.ID, &existingExec.FirstName, &existingExec.LastName, &existingExec.Email, &existingExec.Username, &existingExec.UserCreatedAt, &existingExec.InactiveStatus, &existingExec.Role)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return models.Exec{}, utils.ErrorHandler(err, "Teacher not found")
+			return models.Exec{}, dbError(err, "Teacher not found")
 		}
-		return models.Exec{}, utils.ErrorHandler(err, "error updating data")
+		return models.Exec{}, dbError(err, "error updating data")
 	}
 
+	newEmail, emailChanged := pendingEmailChange(updates, existingExec.Email)
+
 	execVal := reflect.ValueOf(&existingExec).Elem()
 	execType := execVal.Type()
 
@@ -211,153 +355,328 @@ func PatchExec(id int, updates map[string]interface{}) (models.Exec, error) {
 		}
 	}
 
-	_, err = db.Exec("UPDATE execs SET first_name = ?, last_name = ?, email = ?, username = ? WHERE id = ?", existingExec.FirstName,
+	// the other fields aren't saved when the email change is refused
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Exec{}, dbError(err, "error updating data")
+	}
+
+	_, err = tx.ExecContext(ctx, tagQuery(ctx, "UPDATE execs SET first_name = ?, last_name = ?, email = ?, username = ? WHERE id = ?"), existingExec.FirstName,
 		existingExec.LastName, existingExec.Email, existingExec.Username, existingExec.ID)
 	if err != nil {
-		return models.Exec{}, utils.ErrorHandler(err, "error updating data")
+		tx.Rollback()
+		return models.Exec{}, dbError(err, "error updating data")
+	}
+
+	if emailChanged {
+		err = requestEmailChange(ctx, tx, existingExec.ID, newEmail, locale)
+		if err != nil {
+			tx.Rollback()
+			return models.Exec{}, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return models.Exec{}, dbError(err, "error updating data")
 	}
 	return existingExec, nil
 }
 
-func DeleteOneExec(id int) error {
+// pendingEmailChange takes a changed email out of updates so it isn't written
+// straight to the execs table, and reports the address to be verified
+func pendingEmailChange(updates map[string]interface{}, currentEmail string) (string, bool) {
+	newEmail, ok := updates["email"].(string)
+	if !ok {
+		return "", false
+	}
+	delete(updates, "email")
+	if newEmail == "" || newEmail == currentEmail {
+		return "", false
+	}
+	return newEmail, true
+}
+
+// requestEmailChange runs inside the transaction of the update, a refused
+// address or a failed insert into the outbox rolls the whole update back
+func requestEmailChange(ctx context.Context, db execer, id int, newEmail, locale string) error {
+	var emailTaken bool
+	err := db.QueryRowContext(ctx, tagQuery(ctx, "SELECT EXISTS(SELECT 1 FROM execs WHERE email = ? AND id <> ?)"), newEmail, id).Scan(&emailTaken)
+	if err != nil {
+		return dbError(err, "error updating data")
+	}
+	if emailTaken {
+		return utils.ErrorHandler(errors.New("email already in use"), "email already in use")
+	}
+
+	return sendEmailVerification(ctx, db, id, newEmail, true, locale)
+}
+
+// RequestEmailVerification mails a verification link to the current address
+// of an exec that hasn't verified it yet
+func RequestEmailVerification(ctx context.Context, id int, locale string) error {
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
 	db, err := ConnectDb()
 	if err != nil {
-		return utils.ErrorHandler(err, "error deleting data")
+		return dbError(err, "internal error")
+	}
+
+	var email string
+	var verifiedAt, pendingEmail sql.NullString
+	err = db.QueryRowContext(ctx, tagQuery(ctx, "SELECT email, email_verified_at, pending_email FROM execs WHERE id = ?"), id).Scan(&email, &verifiedAt, &pendingEmail)
+	if err == sql.ErrNoRows {
+		return utils.ErrorHandler(err, "exec not found")
+	} else if err != nil {
+		return dbError(err, "internal error")
+	}
+	if verifiedAt.Valid {
+		return utils.ErrorHandler(errors.New("email already verified"), "email already verified")
+	}
+	// a new link would replace the one sent to the new address, confirming
+	// that one verifies the exec anyway
+	if pendingEmail.Valid {
+		return utils.ErrorHandler(errors.New("email change pending"), "an email change is pending, confirm the new address instead")
 	}
-	defer db.Close()
 
-	result, err := db.Exec("DELETE FROM execs WHERE id = ?", id)
+	return sendEmailVerification(ctx, db, id, email, false, locale)
+}
+
+// sendEmailVerification queues a verification link to address, the link is
+// issued when the email goes out. A pending address replaces the current one
+// once confirmed, otherwise confirming only marks the current one verified.
+func sendEmailVerification(ctx context.Context, db execer, id int, address string, pending bool, locale string) error {
+	// links sent for an earlier request stop working
+	query := "UPDATE execs SET email_verification_token = NULL, email_verification_expires = NULL WHERE id = ?"
+	args := []any{id}
+	if pending {
+		query = "UPDATE execs SET pending_email = ?, email_verification_token = NULL, email_verification_expires = NULL WHERE id = ?"
+		args = []any{address, id}
+	}
+	_, err := db.ExecContext(ctx, tagQuery(ctx, query), args...)
 	if err != nil {
-		return utils.ErrorHandler(err, "error deleting data")
+		return dbError(err, "error updating data")
+	}
+
+	data := mailer.TemplateData{}
+	if pending {
+		data.NewEmail = address
+	}
+	err = queueEmail(ctx, db, address, "verify_email", locale, data, models.OutboxTokenEmailVerification, id)
+	if err != nil {
+		return dbError(err, "failed to send verification email")
+	}
+	return nil
+}
+
+// ConfirmEmailChangeDbHandler applies the verification token, the notice to a
+// replaced address goes out in locale
+func ConfirmEmailChangeDbHandler(ctx context.Context, token, locale string) error {
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	bytes, err := hex.DecodeString(token)
+	if err != nil {
+		return dbError(err, "Invalid or expired verification code")
+	}
+
+	hashedToken := sha256.Sum256(bytes)
+	hashedTokenString := hex.EncodeToString(hashedToken[:])
+
+	db, err := ConnectDb()
+	if err != nil {
+		return dbError(err, "Internal Error")
+	}
+
+	var id int
+	var oldEmail string
+	var newEmail sql.NullString
+
+	query := "SELECT id, email, pending_email FROM execs WHERE email_verification_token = ? AND email_verification_expires > ?"
+	err = db.QueryRowContext(ctx, tagQuery(ctx, query), hashedTokenString, time.Now().Format(time.RFC3339)).Scan(&id, &oldEmail, &newEmail)
+	if err != nil {
+		return dbError(err, "Invalid or expired verification code")
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	// without a pending address the link verifies the current one
+	if !newEmail.Valid {
+		updateQuery := "UPDATE execs SET email_verified_at = ?, email_verification_token = NULL, email_verification_expires = NULL WHERE id = ?"
+		_, err = db.ExecContext(ctx, tagQuery(ctx, updateQuery), now, id)
+		if err != nil {
+			return dbError(err, "Internal Error")
+		}
+		return nil
+	}
+
+	var emailTaken bool
+	err = db.QueryRowContext(ctx, tagQuery(ctx, "SELECT EXISTS(SELECT 1 FROM execs WHERE email = ? AND id <> ?)"), newEmail.String, id).Scan(&emailTaken)
+	if err != nil {
+		return dbError(err, "Internal Error")
+	}
+	if emailTaken {
+		return utils.ErrorHandler(errors.New("email already in use"), "email already in use")
+	}
+
+	// reset links already sent to the old address stop working as well
+	updateQuery := "UPDATE execs SET email = pending_email, email_verified_at = ?, pending_email = NULL, email_verification_token = NULL, email_verification_expires = NULL, password_reset_token = NULL, password_token_expires = NULL WHERE id = ?"
+	_, err = db.ExecContext(ctx, tagQuery(ctx, updateQuery), now, id)
+	if err != nil {
+		return dbError(err, "Internal Error")
+	}
+
+	err = queueEmail(ctx, db, oldEmail, "email_changed", locale, mailer.TemplateData{NewEmail: newEmail.String}, "", 0)
+	if err != nil {
+		// the change is already committed, a missing notice shouldn't undo it
+		dbError(err, "failed to send email change notification")
+	}
+	return nil
+}
+
+func DeleteOneExec(ctx context.Context, id int) error {
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	db, err := ConnectDb()
+	if err != nil {
+		return dbError(err, "error deleting data")
+	}
+
+	result, err := db.ExecContext(ctx, tagQuery(ctx, "DELETE FROM execs WHERE id = ?"), id)
+	if err != nil {
+		return dbError(err, "error deleting data")
 	}
 
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		return utils.ErrorHandler(err, "exec not found")
+		return dbError(err, "exec not found")
 	}
 
 	if rowsAffected == 0 {
-		return utils.ErrorHandler(err, "error deleting data")
+		return dbError(err, "error deleting data")
 	}
 	return nil
 }
 
-func GetUserByUsername(username string) (*models.Exec, error) {
+func GetUserByUsername(ctx context.Context, username string) (*models.Exec, error) {
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+
 	db, err := ConnectDb()
 
 	if err != nil {
-		return nil, utils.ErrorHandler(err, "internal error")
+		return nil, dbError(err, "internal error")
 	}
-	defer db.Close()
 
 	user := &models.Exec{}
 
-	err = db.QueryRow(`SELECT id, first_name, last_name, email, username, password, inactive_status, role FROM execs WHERE username = ?`, username).Scan(&user.ID, &user.FirstName, &user.LastName, &user.Email, &user.Username, &user.Password, &user.InactiveStatus, &user.Role)
+	err = db.QueryRowContext(ctx, tagQuery(ctx, `SELECT id, first_name, last_name, email, username, password, inactive_status, role FROM execs WHERE username = ?`), username).Scan(&user.ID, &user.FirstName, &user.LastName, &user.Email, &user.Username, &user.Password, &user.InactiveStatus, &user.Role)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, utils.ErrorHandler(err, "internal error")
+			return nil, dbError(err, "internal error")
 		}
 		
-		return nil, utils.ErrorHandler(err, "database error")
+		return nil, dbError(err, "database error")
 	}
 	return user, nil
 }
 
-func UpdatePasswordInDB(userId int, currentPassword, newPassword string) (bool, error) {
+func UpdatePasswordInDB(ctx context.Context, userId int, currentPassword, newPassword string) (bool, error) {
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
 	db, err := ConnectDb()
 	if err != nil {
-		return false, utils.ErrorHandler(err, "database connection error")
+		return false, dbError(err, "database connection error")
 	}
-	defer db.Close()
 
 	var username string
 	var userPassword string
 	var userRole string
 
-	err = db.QueryRow("SELECT username, password, role FROM execs WHERE id = ?", userId).Scan(&username, &userPassword, &userRole)
+	err = db.QueryRowContext(ctx, tagQuery(ctx, "SELECT username, password, role FROM execs WHERE id = ?"), userId).Scan(&username, &userPassword, &userRole)
 	if err != nil {
-		return false, utils.ErrorHandler(err, "user not found")
+		return false, dbError(err, "user not found")
 	}
 
 	err = utils.VerifyPassword(currentPassword, userPassword)
 	if err != nil {
 		
-		return false, utils.ErrorHandler(err, "The password you entered is wrong")
+		return false, dbError(err, "The password you entered is wrong")
 	}
 
 	hashedPassword, err := utils.HashPassword(newPassword)
 	if err != nil {
-		return false, utils.ErrorHandler(err, "internal error")
+		return false, dbError(err, "internal error")
 	}
 
 	currentTime := time.Now().Format(time.RFC3339)
-	_, err = db.Exec("UPDATE execs SET password = ?, password_changed_at = ? WHERE id = ?", hashedPassword, currentTime, userId)
+	_, err = db.ExecContext(ctx, tagQuery(ctx, "UPDATE execs SET password = ?, password_changed_at = ? WHERE id = ?"), hashedPassword, currentTime, userId)
 	if err != nil {
-		return false, utils.ErrorHandler(err, "failed to update the password")
+		return false, dbError(err, "failed to update the password")
 	}
 	return true, nil
 }
 
-func ForgotPasswordDbHandler(emailId string) error {
+func ForgotPasswordDbHandler(ctx context.Context, emailId, locale string) error {
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
 	db, err := ConnectDb()
 	if err != nil {
-		return utils.ErrorHandler(err, "internal error") 
+		return dbError(err, "internal error") 
 	}
-	defer db.Close()
 
+	// unknown or inactive addresses are not an error, the caller answers the
+	// same way either way so the endpoint can't be used to probe for accounts
 	var exec models.Exec
-	err = db.QueryRow("SELECT id FROM execs WHERE email = ?", emailId).Scan(&exec.ID)
-	if err != nil {
-		return utils.ErrorHandler(err, "user not found")
+	err = db.QueryRowContext(ctx, tagQuery(ctx, "SELECT id FROM execs WHERE email = ? AND inactive_status = FALSE"), emailId).Scan(&exec.ID)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return dbError(err, "internal error")
 	}
 
-	duration, err := strconv.Atoi(os.Getenv("RESET_TOKEN_EXP_DURATION"))
+	// the reset link is issued when the email goes out
+	err = queueEmail(ctx, db, emailId, "reset", locale, mailer.TemplateData{}, models.OutboxTokenReset, exec.ID)
 	if err != nil {
-		return utils.ErrorHandler(err, "failed to send password reset email")
+		return dbError(err, "failed to send password reset email")
 	}
-	mins := time.Duration(duration)
+	return nil
+}
 
-	expiry := time.Now().Add(mins * time.Minute).Format(time.RFC3339)
+// publicURL fills the {token} placeholder of a frontend route and prefixes it
+// with the public base URL
+func publicURL(pathTemplate, token string) string {
+	baseURL := strings.TrimSuffix(options.PublicBaseURL, "/")
+	return baseURL + strings.ReplaceAll(pathTemplate, "{token}", url.PathEscape(token))
+}
 
+// generateResetToken returns a random token for the emailed link together
+// with the sha256 hash of it that gets stored in password_reset_token
+func generateResetToken() (string, string, error) {
 	tokenBytes := make([]byte, 32)
-	_, err = rand.Read(tokenBytes)
+	_, err := rand.Read(tokenBytes)
 	if err != nil {
-		return utils.ErrorHandler(err, "failed to send password reset token")
+		return "", "", err
 	}
 
 	token := hex.EncodeToString(tokenBytes)
 
 	hashedToken := sha256.Sum256(tokenBytes)
 
-	hashedTokenString := hex.EncodeToString(hashedToken[:])
-
-	_, err = db.Exec("UPDATE execs SET password_reset_token = ?, password_token_expires = ? WHERE id = ?", hashedTokenString, expiry, exec.ID)
-	if err != nil {
-		return utils.ErrorHandler(err, "failed to send password reset token")
-	}
-
-	// send the email
-	resetURL := fmt.Sprintf("https://localhost:8000/execs/resetpassword/reset/%s", token)
-	message := fmt.Sprintf("Forgot your password? Please set your password using the following link: \n%s\nIf you didn't request a password rest, then please ignore this email. Valid for %d minutes", resetURL, int(mins))
-
-	m := mail.NewMessage()
-	m.SetHeader("From", "schooladmin@school.com")
-	m.SetHeader("To", emailId)
-	m.SetHeader("Subject", "Your password reset link")
-	m.SetBody("text/plain", message)
-
-	d := mail.NewDialer("localhost", 1025, "", "")
-	err = d.DialAndSend(m)
-	if err != nil {
-		return utils.ErrorHandler(err, "failed to send password reset email")
-	}
-	return nil
+	return token, hex.EncodeToString(hashedToken[:]), nil
 }
 
-func ResetPasswordDbHandler(token string, newPassword string) error {
+func ResetPasswordDbHandler(ctx context.Context, token string, newPassword string) error {
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
 	bytes, err := hex.DecodeString(token)
 	if err != nil {
-		return utils.ErrorHandler(err, "Internal Error")
+		return dbError(err, "Internal Error")
 	}
 
 	hashedToken := sha256.Sum256(bytes)
@@ -365,27 +684,29 @@ func ResetPasswordDbHandler(token string, newPassword string) error {
 
 	db, err := ConnectDb()
 	if err != nil {
-		return utils.ErrorHandler(err, "Internal Error")
+		return dbError(err, "Internal Error")
 	}
-	defer db.Close()
 
 	var user models.Exec
 
 	query := "SELECT id, email FROM execs WHERE password_reset_token = ? AND password_token_expires > ?"
-	err = db.QueryRow(query, hashedTokenString, time.Now().Format(time.RFC3339)).Scan(&user.ID, &user.Email)
+	err = db.QueryRowContext(ctx, tagQuery(ctx, query), hashedTokenString, time.Now().Format(time.RFC3339)).Scan(&user.ID, &user.Email)
 	if err != nil {
-		return utils.ErrorHandler(err, "Invalid or expired reset code")
+		return dbError(err, "Invalid or expired reset code")
 	}
 
 	hashedPassword, err := utils.HashPassword(newPassword)
 	if err != nil {
-		return utils.ErrorHandler(err, "Internal Error")
+		return dbError(err, "Internal Error")
 	}
 
-	updateQuery := "UPDATE execs SET password = ?, password_reset_token = NULL, password_token_expires = NULL, password_changed_at = ? WHERE id = ?"
-	_, err = db.Exec(updateQuery, hashedPassword, time.Now().Format(time.RFC3339), user.ID)
+	// reset and invitation links only ever go to the current address, using
+	// one verifies it
+	now := time.Now()
+	updateQuery := "UPDATE execs SET password = ?, password_reset_token = NULL, password_token_expires = NULL, password_changed_at = ?, email_verified_at = COALESCE(email_verified_at, ?) WHERE id = ?"
+	_, err = db.ExecContext(ctx, tagQuery(ctx, updateQuery), hashedPassword, now.Format(time.RFC3339), now.UTC().Format(time.RFC3339), user.ID)
 	if err != nil {
-		return utils.ErrorHandler(err, "Internal Error")
+		return dbError(err, "Internal Error")
 	}
 	return nil
 }
\ No newline at end of file
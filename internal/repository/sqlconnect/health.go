@@ -0,0 +1,54 @@
+package sqlconnect
+
+import (
+	"context"
+)
+
+// PingDB checks that the database answers
+func PingDB(ctx context.Context) error {
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+
+	db, err := ConnectDb()
+	if err != nil {
+		return dbError(err, "database unavailable")
+	}
+
+	err = db.PingContext(ctx)
+	if err != nil {
+		return dbError(err, "database unavailable")
+	}
+	return nil
+}
+
+// GetAppliedMigrations lists the versions recorded in schema_migrations
+func GetAppliedMigrations(ctx context.Context) ([]string, error) {
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+
+	db, err := ConnectDb()
+	if err != nil {
+		return nil, dbError(err, "error retrieving migrations")
+	}
+
+	rows, err := db.QueryContext(ctx, tagQuery(ctx, "SELECT version FROM schema_migrations ORDER BY version"))
+	if err != nil {
+		return nil, dbError(err, "error retrieving migrations")
+	}
+	defer rows.Close()
+
+	var versions []string
+	for rows.Next() {
+		var version string
+		err = rows.Scan(&version)
+		if err != nil {
+			return nil, dbError(err, "error retrieving migrations")
+		}
+		versions = append(versions, version)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, dbError(err, "error retrieving migrations")
+	}
+	return versions, nil
+}
@@ -0,0 +1,321 @@
+package sqlconnect
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"restapi/internal/mailer"
+	"restapi/internal/models"
+	"restapi/pkg/utils"
+	"time"
+)
+
+const outboxColumns = "id, recipient, template, locale, template_data, token_purpose, exec_id, status, attempts, next_attempt_at, last_error, created_at, sent_at"
+
+// ErrOutboxEmailObsolete is returned for a queued email whose exec no longer
+// matches it: deleted, deactivated or with another address by now
+var ErrOutboxEmailObsolete = errors.New("email no longer applies to its exec")
+
+// queueEmail stores the template and its data in the outbox, the mail queue
+// worker renders and delivers it. Links aren't part of data: for a
+// tokenPurpose the worker issues the token of execID when it sends the email,
+// so the outbox never holds a usable token.
+func queueEmail(ctx context.Context, db execer, to, name, locale string, data mailer.TemplateData, tokenPurpose string, execID int) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	var purpose *string
+	var exec *int
+	if tokenPurpose != "" {
+		purpose = &tokenPurpose
+		exec = &execID
+	}
+
+	now := time.Now().Format(time.RFC3339)
+	_, err = db.ExecContext(ctx, tagQuery(ctx, "INSERT INTO mail_outbox (recipient, template, locale, template_data, token_purpose, exec_id, status, attempts, next_attempt_at, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, 0, ?, ?)"),
+		to, name, locale, string(encoded), purpose, exec, models.OutboxStatusPending, now, now)
+	return err
+}
+
+func scanOutboxEmail(row interface{ Scan(...any) error }) (models.OutboxEmail, error) {
+	var email models.OutboxEmail
+	err := row.Scan(&email.ID, &email.Recipient, &email.Template, &email.Locale, &email.TemplateData, &email.TokenPurpose, &email.ExecID, &email.Status, &email.Attempts, &email.NextAttemptAt, &email.LastError, &email.CreatedAt, &email.SentAt)
+	return email, err
+}
+
+// RenderOutboxEmail builds the message of a queued email. An email with a
+// token purpose gets a fresh token here, stored hashed on its exec, or
+// ErrOutboxEmailObsolete when the exec no longer matches the email.
+func RenderOutboxEmail(ctx context.Context, email models.OutboxEmail) (mailer.Message, error) {
+	var data mailer.TemplateData
+	if email.TemplateData != nil {
+		err := json.Unmarshal([]byte(*email.TemplateData), &data)
+		if err != nil {
+			return mailer.Message{}, err
+		}
+	}
+
+	if email.TokenPurpose != nil {
+		if email.ExecID == nil {
+			return mailer.Message{}, ErrOutboxEmailObsolete
+		}
+		link, validity, err := issueEmailToken(ctx, *email.TokenPurpose, *email.ExecID, email.Recipient)
+		if err != nil {
+			return mailer.Message{}, err
+		}
+		data.URL = link
+		data.ValidMinutes = int(validity.Minutes())
+	}
+
+	msg, err := mailer.Render(email.Template, email.Locale, data)
+	if err != nil {
+		return mailer.Message{}, err
+	}
+	msg.To = email.Recipient
+	return msg, nil
+}
+
+// issueEmailToken stores a new token of purpose for the exec, replacing the
+// one sent before, and returns the link carrying it. The token is only
+// issued while the exec still reads recipient.
+func issueEmailToken(ctx context.Context, purpose string, execID int, recipient string) (string, time.Duration, error) {
+	var query, pathTemplate string
+	var validity time.Duration
+	switch purpose {
+	case models.OutboxTokenReset, models.OutboxTokenInvite:
+		query = "UPDATE execs SET password_reset_token = ?, password_token_expires = ? WHERE id = ? AND email = ? AND inactive_status = FALSE"
+		pathTemplate = options.ResetPasswordPath
+		validity = options.ResetExpiry
+		if purpose == models.OutboxTokenInvite {
+			validity = options.InviteExpiry
+		}
+	case models.OutboxTokenEmailVerification:
+		query = "UPDATE execs SET email_verification_token = ?, email_verification_expires = ? WHERE id = ? AND COALESCE(pending_email, email) = ?"
+		pathTemplate = options.EmailVerificationPath
+		validity = options.EmailVerificationExpiry
+	default:
+		return "", 0, fmt.Errorf("unknown email token purpose %q", purpose)
+	}
+
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	db, err := ConnectDb()
+	if err != nil {
+		return "", 0, dbError(err, "error updating data")
+	}
+
+	token, hashedTokenString, err := generateResetToken()
+	if err != nil {
+		return "", 0, err
+	}
+
+	expiry := time.Now().Add(validity).Format(time.RFC3339)
+	result, err := db.ExecContext(ctx, tagQuery(ctx, query), hashedTokenString, expiry, execID, recipient)
+	if err != nil {
+		return "", 0, dbError(err, "error updating data")
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return "", 0, dbError(err, "error updating data")
+	}
+	if rowsAffected == 0 {
+		return "", 0, ErrOutboxEmailObsolete
+	}
+	return publicURL(pathTemplate, token), validity, nil
+}
+
+func GetOutboxEmailsDBHandler(ctx context.Context, status string) ([]models.OutboxEmail, error) {
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+
+	db, err := ConnectDb()
+	if err != nil {
+		return nil, dbError(err, "error retrieving data")
+	}
+
+	query := "SELECT " + outboxColumns + " FROM mail_outbox WHERE 1=1"
+	var args []interface{}
+	if status != "" {
+		query += " AND status = ?"
+		args = append(args, status)
+	}
+	query += " ORDER BY id DESC LIMIT 100"
+
+	rows, err := db.QueryContext(ctx, tagQuery(ctx, query), args...)
+	if err != nil {
+		return nil, dbError(err, "error retrieving data")
+	}
+	defer rows.Close()
+
+	emails := []models.OutboxEmail{}
+	for rows.Next() {
+		email, err := scanOutboxEmail(rows)
+		if err != nil {
+			return nil, dbError(err, "error retrieving data")
+		}
+		emails = append(emails, email)
+	}
+	return emails, nil
+}
+
+// ClaimDueEmails marks up to limit due messages as sending and returns them.
+// The lease pushes next_attempt_at forward, so a worker that dies mid-send
+// leaves the messages to be picked up again once the lease runs out.
+func ClaimDueEmails(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxEmail, error) {
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	db, err := ConnectDb()
+	if err != nil {
+		return nil, dbError(err, "error retrieving data")
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, dbError(err, "error retrieving data")
+	}
+
+	now := time.Now()
+	rows, err := tx.QueryContext(ctx, tagQuery(ctx, "SELECT "+outboxColumns+" FROM mail_outbox WHERE status IN (?, ?) AND next_attempt_at <= ? ORDER BY next_attempt_at LIMIT ? FOR UPDATE SKIP LOCKED"),
+		models.OutboxStatusPending, models.OutboxStatusSending, now.Format(time.RFC3339), limit)
+	if err != nil {
+		tx.Rollback()
+		return nil, dbError(err, "error retrieving data")
+	}
+
+	var emails []models.OutboxEmail
+	for rows.Next() {
+		email, err := scanOutboxEmail(rows)
+		if err != nil {
+			rows.Close()
+			tx.Rollback()
+			return nil, dbError(err, "error retrieving data")
+		}
+		emails = append(emails, email)
+	}
+	rows.Close()
+
+	leaseUntil := now.Add(lease).Format(time.RFC3339)
+	for _, email := range emails {
+		_, err = tx.ExecContext(ctx, tagQuery(ctx, "UPDATE mail_outbox SET status = ?, next_attempt_at = ? WHERE id = ?"), models.OutboxStatusSending, leaseUntil, email.ID)
+		if err != nil {
+			tx.Rollback()
+			return nil, dbError(err, "error updating data")
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, dbError(err, "error updating data")
+	}
+	return emails, nil
+}
+
+// MarkEmailSent records a delivery, the template data is only needed to
+// render the message and is dropped
+func MarkEmailSent(ctx context.Context, id int) error {
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	db, err := ConnectDb()
+	if err != nil {
+		return dbError(err, "error updating data")
+	}
+
+	_, err = db.ExecContext(ctx, tagQuery(ctx, "UPDATE mail_outbox SET status = ?, attempts = attempts + 1, last_error = NULL, template_data = NULL, sent_at = ? WHERE id = ?"), models.OutboxStatusSent, time.Now().Format(time.RFC3339), id)
+	if err != nil {
+		return dbError(err, "error updating data")
+	}
+	return nil
+}
+
+// MarkEmailFailed records a failed delivery, the message is retried at
+// nextAttempt unless dead is set
+func MarkEmailFailed(ctx context.Context, id int, sendErr error, nextAttempt time.Time, dead bool) error {
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	db, err := ConnectDb()
+	if err != nil {
+		return dbError(err, "error updating data")
+	}
+
+	status := models.OutboxStatusPending
+	if dead {
+		status = models.OutboxStatusDead
+	}
+
+	_, err = db.ExecContext(ctx, tagQuery(ctx, "UPDATE mail_outbox SET status = ?, attempts = attempts + 1, last_error = ?, next_attempt_at = ? WHERE id = ?"), status, sendErr.Error(), nextAttempt.Format(time.RFC3339), id)
+	if err != nil {
+		return dbError(err, "error updating data")
+	}
+	return nil
+}
+
+// ResendOutboxEmail puts a dead or still retrying message back at the front
+// of the queue with a fresh set of attempts
+func ResendOutboxEmail(ctx context.Context, id int) (models.OutboxEmail, error) {
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	db, err := ConnectDb()
+	if err != nil {
+		return models.OutboxEmail{}, dbError(err, "error updating data")
+	}
+
+	email, err := scanOutboxEmail(db.QueryRowContext(ctx, tagQuery(ctx, "SELECT "+outboxColumns+" FROM mail_outbox WHERE id = ?"), id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return models.OutboxEmail{}, dbError(err, "email not found")
+		}
+		return models.OutboxEmail{}, dbError(err, "error updating data")
+	}
+
+	if email.Status != models.OutboxStatusDead && email.Status != models.OutboxStatusPending {
+		return models.OutboxEmail{}, utils.ErrorHandler(errors.New("email is not in a failed state"), "only failed emails can be resent")
+	}
+
+	email.Status = models.OutboxStatusPending
+	email.Attempts = 0
+	email.NextAttemptAt = time.Now().Format(time.RFC3339)
+	_, err = db.ExecContext(ctx, tagQuery(ctx, "UPDATE mail_outbox SET status = ?, attempts = 0, next_attempt_at = ? WHERE id = ?"), email.Status, email.NextAttemptAt, id)
+	if err != nil {
+		return models.OutboxEmail{}, dbError(err, "error updating data")
+	}
+	return email, nil
+}
+
+// CountOutboxEmailsByStatus returns how many messages the outbox holds in
+// each status
+func CountOutboxEmailsByStatus(ctx context.Context) (map[string]int, error) {
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+
+	db, err := ConnectDb()
+	if err != nil {
+		return nil, dbError(err, "error retrieving data")
+	}
+
+	rows, err := db.QueryContext(ctx, tagQuery(ctx, "SELECT status, COUNT(*) FROM mail_outbox GROUP BY status"))
+	if err != nil {
+		return nil, dbError(err, "error retrieving data")
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var status string
+		var count int
+		err = rows.Scan(&status, &count)
+		if err != nil {
+			return nil, dbError(err, "error retrieving data")
+		}
+		counts[status] = count
+	}
+	return counts, nil
+}
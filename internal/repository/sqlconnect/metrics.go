@@ -0,0 +1,79 @@
+package sqlconnect
+
+import (
+	"context"
+	"database/sql"
+	"restapi/internal/metrics"
+	"restapi/internal/models"
+	"time"
+)
+
+const mailQueueDepthTTL = 30 * time.Second
+
+func init() {
+	gauge := func(name, help string, value func(sql.DBStats) float64) {
+		metrics.Default.NewGaugeFunc(name, help, "", func(ctx context.Context) (map[string]float64, error) {
+			return metrics.Single(value(poolStats())), nil
+		})
+	}
+	counter := func(name, help string, value func(sql.DBStats) float64) {
+		metrics.Default.NewCounterFunc(name, help, "", func(ctx context.Context) (map[string]float64, error) {
+			return metrics.Single(value(poolStats())), nil
+		})
+	}
+
+	gauge("db_pool_max_open_connections", "Maximum number of open connections to the database.",
+		func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) })
+	gauge("db_pool_open_connections", "Established connections, in use and idle.",
+		func(s sql.DBStats) float64 { return float64(s.OpenConnections) })
+	gauge("db_pool_in_use_connections", "Connections currently in use.",
+		func(s sql.DBStats) float64 { return float64(s.InUse) })
+	gauge("db_pool_idle_connections", "Idle connections.",
+		func(s sql.DBStats) float64 { return float64(s.Idle) })
+	counter("db_pool_wait_count_total", "Times a query had to wait for a free connection.",
+		func(s sql.DBStats) float64 { return float64(s.WaitCount) })
+	counter("db_pool_wait_duration_seconds_total", "Time spent waiting for a free connection.",
+		func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() })
+	counter("db_pool_max_idle_closed_total", "Connections closed because of SetMaxIdleConns.",
+		func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) })
+	counter("db_pool_max_idle_time_closed_total", "Connections closed because of SetConnMaxIdleTime.",
+		func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) })
+	counter("db_pool_max_lifetime_closed_total", "Connections closed because of SetConnMaxLifetime.",
+		func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) })
+
+	// counting the outbox is a query, scrapes share one result per interval
+	metrics.Default.NewGaugeFunc("mail_queue_messages", "Messages in the mail outbox, by status.", "status",
+		metrics.Cached(mailQueueDepthTTL, mailQueueDepth))
+}
+
+// poolStats reads the stats of the pool without opening it, a process that
+// has not queried yet reports zeros
+func poolStats() sql.DBStats {
+	poolMu.Lock()
+	defer poolMu.Unlock()
+
+	if pool == nil {
+		return sql.DBStats{}
+	}
+	return pool.Stats()
+}
+
+func mailQueueDepth(ctx context.Context) (map[string]float64, error) {
+	counts, err := CountOutboxEmailsByStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// every status is reported, an empty queue should read 0 rather than
+	// vanish from the graphs
+	depth := map[string]float64{
+		models.OutboxStatusPending: 0,
+		models.OutboxStatusSending: 0,
+		models.OutboxStatusSent:    0,
+		models.OutboxStatusDead:    0,
+	}
+	for status, count := range counts {
+		depth[status] = float64(count)
+	}
+	return depth, nil
+}
@@ -1,28 +1,154 @@
 package sqlconnect
 
 import (
+	"context"
 	"database/sql"
-	"fmt"
-	"os"
+	"log/slog"
+	"net"
+	"restapi/internal/logging"
+	"strings"
+	"sync"
+	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 )
 
-func ConnectDb() (*sql.DB, error ){
-	user := os.Getenv("DB_USER")
-	password := os.Getenv("DB_PASSWORD")
-	dbname := os.Getenv("DB_NAME")
-	dbport := os.Getenv("DB_PORT")
-	host := os.Getenv("HOST")
+var logger = slog.Default()
 
-	// connectionString := "root:M@rch041992@tcp(127.0.0.l:3306)/" + dbname
-	connectionString := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s", user, password, host, dbport, dbname)
-	db, err := sql.Open("mysql", connectionString)
+// Options are the settings of the repository functions: the database, its
+// pool and timeouts, and the emailed tokens and links
+type Options struct {
+	User            string
+	Password        string
+	Name            string
+	Host            string
+	Port            string
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+
+	// PublicBaseURL prefixes the frontend routes of the emailed links, in
+	// which {token} is replaced by the token
+	PublicBaseURL           string
+	ResetPasswordPath       string
+	EmailVerificationPath   string
+	ResetExpiry             time.Duration
+	InviteExpiry            time.Duration
+	EmailVerificationExpiry time.Duration
+}
+
+var options = Options{
+	Port:                    "3306",
+	MaxOpenConns:            25,
+	MaxIdleConns:            25,
+	ConnMaxLifetime:         5 * time.Minute,
+	ReadTimeout:             5 * time.Second,
+	WriteTimeout:            10 * time.Second,
+	PublicBaseURL:           "https://localhost:8000",
+	ResetPasswordPath:       "/execs/resetpassword/reset/{token}",
+	EmailVerificationPath:   "/execs/verifyemail/{token}",
+	ResetExpiry:             10 * time.Minute,
+	InviteExpiry:            24 * time.Hour,
+	EmailVerificationExpiry: time.Hour,
+}
+
+var (
+	poolMu sync.Mutex
+	pool   *sql.DB
+	closed bool
+)
+
+// Configure replaces the settings, call it before the first query
+func Configure(o Options) {
+	poolMu.Lock()
+	defer poolMu.Unlock()
+	options = o
+}
+
+// SetLogger replaces the logger the repository functions write to
+func SetLogger(l *slog.Logger) {
+	logger = l
+}
+
+// loggerFor tags the repository logger with the id of the request that
+// triggered the query
+func loggerFor(ctx context.Context) *slog.Logger {
+	if requestID := logging.RequestID(ctx); requestID != "" {
+		return logger.With("request_id", requestID)
+	}
+	return logger
+}
+
+// tagQuery prefixes the query with a comment naming the request, so slow
+// query logs and SHOW PROCESSLIST can be matched with the access log
+func tagQuery(ctx context.Context, query string) string {
+	requestID := logging.RequestID(ctx)
+	if requestID == "" {
+		return query
+	}
+	// the middleware only lets through safe ids, this keeps a stray "*/"
+	// from ending the comment early whatever the caller put in the context
+	requestID = strings.Map(func(r rune) rune {
+		if r == '-' || r == '_' || r == '.' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' {
+			return r
+		}
+		return -1
+	}, requestID)
+	return "/* request_id=" + requestID + " */ " + query
+}
 
+// execer runs statements on the pool or inside a transaction
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// ConnectDb returns the connection pool shared by the repository functions,
+// opening it on first use. Callers must not close it, Close does that on
+// shutdown.
+func ConnectDb() (*sql.DB, error) {
+	poolMu.Lock()
+	defer poolMu.Unlock()
+
+	if closed {
+		return nil, ErrDBUnavailable
+	}
+	if pool != nil {
+		return pool, nil
+	}
+
+	// connectionString := "root:M@rch041992@tcp(127.0.0.l:3306)/" + dbname
+	config := mysql.NewConfig()
+	config.User = options.User
+	config.Passwd = options.Password
+	config.Net = "tcp"
+	config.Addr = net.JoinHostPort(options.Host, options.Port)
+	config.DBName = options.Name
+	connector, err := mysql.NewConnector(config)
 	if err != nil {
 		// panic(err)
 		return nil, err
 	}
-	fmt.Println("Connected to mariadb...")
-	return db, nil
-}
\ No newline at end of file
+	db := sql.OpenDB(tracedConnector{connector})
+	db.SetMaxOpenConns(options.MaxOpenConns)
+	db.SetMaxIdleConns(options.MaxIdleConns)
+	db.SetConnMaxLifetime(options.ConnMaxLifetime)
+
+	pool = db
+	logger.Debug("connected to mariadb")
+	return pool, nil
+}
+
+// Close waits for the queries in flight and closes the pool
+func Close() error {
+	poolMu.Lock()
+	defer poolMu.Unlock()
+
+	closed = true
+	if pool == nil {
+		return nil
+	}
+	return pool.Close()
+}
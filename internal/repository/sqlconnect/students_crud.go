@@ -1,9 +1,9 @@
 package sqlconnect
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
 	"net/http"
 	"reflect"
 	"restapi/internal/models"
@@ -12,31 +12,35 @@ import (
 	"strings"
 )
 
-func GetStudentByID(id int) (models.Student, error) {
+func GetStudentByID(ctx context.Context, id int) (models.Student, error) {
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+
 	db, err := ConnectDb()
 
 	if err != nil {
-		return models.Student{}, utils.ErrorHandler(err, "error retrieving data")
+		return models.Student{}, dbError(err, "error retrieving data")
 	}
-	defer db.Close()
 
 	var student models.Student
-	err = db.QueryRow("SELECT * FROM students WHERE id = ?", id).Scan(&student.ID, &student.FirstName, &student.LastName, &student.Email, &student.Class)
+	err = db.QueryRowContext(ctx, tagQuery(ctx, "SELECT * FROM students WHERE id = ?"), id).Scan(&student.ID, &student.FirstName, &student.LastName, &student.Email, &student.Class)
 	if err == sql.ErrNoRows {
-		return models.Student{}, utils.ErrorHandler(err, "error retrieving data")
+		return models.Student{}, dbError(err, "error retrieving data")
 	} else if err != nil {
-		return models.Student{}, utils.ErrorHandler(err, "error retrieving data")
+		return models.Student{}, dbError(err, "error retrieving data")
 	}
 	return student, nil
 }
 
 func GetStudentsDBHandler(students []models.Student, r *http.Request, limit, page int) ([]models.Student, int, error) {
+	ctx, cancel := withReadTimeout(r.Context())
+	defer cancel()
+
 	db, err := ConnectDb()
 
 	if err != nil {
-		return nil, 0, utils.ErrorHandler(err, "error retrieving data")
+		return nil, 0, dbError(err, "error retrieving data")
 	}
-	defer db.Close()
 
 	query := "SELECT * FROM students WHERE 1=1";
 	
@@ -49,10 +53,10 @@ func GetStudentsDBHandler(students []models.Student, r *http.Request, limit, pag
 
 	query = utils.AddSorting(r, query)
 
-	rows, err := db.Query(query, args...)
+	rows, err := db.QueryContext(ctx, tagQuery(ctx, query), args...)
 	if err != nil {
-		fmt.Println(err)
-		return nil, 0, utils.ErrorHandler(err, "error retrieving data")
+		loggerFor(ctx).Error("query failed", "err", err)
+		return nil, 0, dbError(err, "error retrieving data")
 	}
 	defer rows.Close()
 
@@ -61,48 +65,50 @@ func GetStudentsDBHandler(students []models.Student, r *http.Request, limit, pag
 		var student models.Student
 		err := rows.Scan(&student.ID, &student.FirstName, &student.LastName, &student.Email, &student.Class)
 		if err != nil {
-			return nil, 0, utils.ErrorHandler(err, "error retrieving data")
+			return nil, 0, dbError(err, "error retrieving data")
 		}
 		students = append(students, student)
 	}
 
 	// Get total count
 	var totalStudents int
-	err = db.QueryRow("SELECT COUNT(*) FROM students").Scan(&totalStudents)
+	err = db.QueryRowContext(ctx, tagQuery(ctx, "SELECT COUNT(*) FROM students")).Scan(&totalStudents)
 	if err != nil {
-		utils.ErrorHandler(err, "")
+		dbError(err, "")
 		totalStudents = 0
 	}
 	return students, totalStudents, nil
 }
 
-func AddStudentsDBHandler(newStudents []models.Student) ([]models.Student, error) {
+func AddStudentsDBHandler(ctx context.Context, newStudents []models.Student) ([]models.Student, error) {
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
 	db, err := ConnectDb()
 
 	if err != nil {
-		return nil, utils.ErrorHandler(err, "error adding data")
+		return nil, dbError(err, "error adding data")
 	}
-	defer db.Close()
 
-	stmt, err := db.Prepare(utils.GenerateInsertQuery("students", models.Student{}))
+	stmt, err := db.PrepareContext(ctx, tagQuery(ctx, utils.GenerateInsertQuery("students", models.Student{})))
 	if err != nil {
-		return nil, utils.ErrorHandler(err, "error adding data")
+		return nil, dbError(err, "error adding data")
 	}
 	defer stmt.Close()
 
 	addedStudents := make([]models.Student, len(newStudents))
 	for i, newStudent := range newStudents {
 		values := utils.GetStructValues(newStudent)
-		res, err := stmt.Exec(values...)
+		res, err := stmt.ExecContext(ctx, values...)
 		if err != nil {
 			if strings.Contains(err.Error(), "a foreign key constraint fails (`school`.`students`, CONSTRAINT `students_ibfk_1` FOREIGN KEY (`class`) REFERENCES `teachers` (`class`))") {
-				return nil, utils.ErrorHandler(err, "class / class teacher does not exist.")
+				return nil, dbError(err, "class / class teacher does not exist.")
 			}
-			return nil, utils.ErrorHandler(err, "error adding data")
+			return nil, dbError(err, "error adding data")
 		}
 		lastId, err := res.LastInsertId()
 		if err != nil {
-			return nil, utils.ErrorHandler(err, "error adding data")
+			return nil, dbError(err, "error adding data")
 		}
 		newStudent.ID = int(lastId)
 		addedStudents[i] = newStudent
@@ -110,64 +116,68 @@ func AddStudentsDBHandler(newStudents []models.Student) ([]models.Student, error
 	return addedStudents, nil
 }
 
-func UpdateStudent(id int, updatedStudent models.Student) (models.Student, error) {
+func UpdateStudent(ctx context.Context, id int, updatedStudent models.Student) (models.Student, error) {
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
 	db, err := ConnectDb()
 	if err != nil {
-		return models.Student{}, utils.ErrorHandler(err, "error updating data")
+		return models.Student{}, dbError(err, "error updating data")
 	}
-	defer db.Close()
 
 	var existingStudent models.Student
-	err = db.QueryRow("SELECT * FROM students WHERE id = ?", id).Scan(&existingStudent.ID, &existingStudent.FirstName, &existingStudent.LastName, &existingStudent.Email, &existingStudent.Class)
+	err = db.QueryRowContext(ctx, tagQuery(ctx, "SELECT * FROM students WHERE id = ?"), id).Scan(&existingStudent.ID, &existingStudent.FirstName, &existingStudent.LastName, &existingStudent.Email, &existingStudent.Class)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return models.Student{}, utils.ErrorHandler(err, "error updating data")
+			return models.Student{}, dbError(err, "error updating data")
 		}
-		return models.Student{}, utils.ErrorHandler(err, "error updating data")
+		return models.Student{}, dbError(err, "error updating data")
 	}
 
 	updatedStudent.ID = existingStudent.ID
-	_, err = db.Exec("UPDATE students SET first_name = ?, last_name = ?, email = ?, class = ? WHERE id = ?", updatedStudent.FirstName,
+	_, err = db.ExecContext(ctx, tagQuery(ctx, "UPDATE students SET first_name = ?, last_name = ?, email = ?, class = ? WHERE id = ?"), updatedStudent.FirstName,
 		updatedStudent.LastName, updatedStudent.Email, updatedStudent.Class, updatedStudent.ID)
 	if err != nil {
-		return models.Student{}, utils.ErrorHandler(err, "error updating data")
+		return models.Student{}, dbError(err, "error updating data")
 	}
 	return updatedStudent, nil
 }
 
-func PatchStudents(updates []map[string]interface{}) error {
+func PatchStudents(ctx context.Context, updates []map[string]interface{}) error {
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
 	db, err := ConnectDb()
 	if err != nil {
-		return utils.ErrorHandler(err, "error updating data")
+		return dbError(err, "error updating data")
 	}
-	defer db.Close()
 
-	tx, err := db.Begin()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		return utils.ErrorHandler(err, "error updating data")
+		return dbError(err, "error updating data")
 	}
 
 	for _, update := range updates {
 		idStr, ok := update["id"].(string)
 		if !ok {
 			tx.Rollback()
-			return utils.ErrorHandler(err, "error updating data")
+			return dbError(err, "error updating data")
 		}
 
 		id, err := strconv.Atoi(idStr)
 		if err != nil {
 			tx.Rollback()
-			return utils.ErrorHandler(err, "invalid id")
+			return dbError(err, "invalid id")
 		}
 
 		var studentFromDb models.Student
-		err = db.QueryRow("SELECT * FROM students WHERE id = ?", id).Scan(&studentFromDb.ID, &studentFromDb.FirstName, &studentFromDb.LastName, &studentFromDb.Email, &studentFromDb.Class)
+		err = db.QueryRowContext(ctx, tagQuery(ctx, "SELECT * FROM students WHERE id = ?"), id).Scan(&studentFromDb.ID, &studentFromDb.FirstName, &studentFromDb.LastName, &studentFromDb.Email, &studentFromDb.Class)
 		if err != nil {
 			tx.Rollback()
 			if err == sql.ErrNoRows {
-				return utils.ErrorHandler(err, "Teacher not found")
+				return dbError(err, "Teacher not found")
 			}
-			return utils.ErrorHandler(err, "error updating data")
+			return dbError(err, "error updating data")
 		}
 
 		studentVal := reflect.ValueOf(&studentFromDb).Elem()
@@ -187,8 +197,8 @@ func PatchStudents(updates []map[string]interface{}) error {
 							fieldVal.Set(val.Convert(fieldVal.Type()))
 						} else {
 							tx.Rollback()
-							log.Printf("cannot convert %v to %v", val.Type(), fieldVal.Type())
-							return utils.ErrorHandler(err, "error updating data")
+							loggerFor(ctx).Warn("cannot convert patch value", "field", k, "from", val.Type().String(), "to", fieldVal.Type().String())
+							return dbError(err, "error updating data")
 						}
 					}
 					break
@@ -196,34 +206,36 @@ func PatchStudents(updates []map[string]interface{}) error {
 			}
 		}
 
-		_, err = tx.Exec("UPDATE students SET first_name = ?, last_name = ?, email = ?, class = ? WHERE id = ?", studentFromDb.FirstName, studentFromDb.LastName, studentFromDb.Email, studentFromDb.Class, studentFromDb.ID)
+		_, err = tx.ExecContext(ctx, tagQuery(ctx, "UPDATE students SET first_name = ?, last_name = ?, email = ?, class = ? WHERE id = ?"), studentFromDb.FirstName, studentFromDb.LastName, studentFromDb.Email, studentFromDb.Class, studentFromDb.ID)
 		if err != nil {
 			tx.Rollback()
-			return utils.ErrorHandler(err, "error updating data")
+			return dbError(err, "error updating data")
 		}
 	}
 	err = tx.Commit()
 	if err != nil {
-		return utils.ErrorHandler(err, "error updating data")
+		return dbError(err, "error updating data")
 	}
 	return nil
 }
 
-func PatchStudent(id int, updates map[string]interface{}) (models.Student, error) {
+func PatchStudent(ctx context.Context, id int, updates map[string]interface{}) (models.Student, error) {
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
 	db, err := ConnectDb()
 	if err != nil {
-		log.Println(err)
-		return models.Student{}, utils.ErrorHandler(err, "error updating data")
+		loggerFor(ctx).Error("database connection failed", "err", err)
+		return models.Student{}, dbError(err, "error updating data")
 	}
-	defer db.Close()
 
 	var existingStudent models.Student
-	err = db.QueryRow("SELECT * FROM students WHERE id = ?", id).Scan(&existingStudent.ID, &existingStudent.FirstName, &existingStudent.LastName, &existingStudent.Email, &existingStudent.Class)
+	err = db.QueryRowContext(ctx, tagQuery(ctx, "SELECT * FROM students WHERE id = ?"), id).Scan(&existingStudent.ID, &existingStudent.FirstName, &existingStudent.LastName, &existingStudent.Email, &existingStudent.Class)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return models.Student{}, utils.ErrorHandler(err, "Teacher not found")
+			return models.Student{}, dbError(err, "Teacher not found")
 		}
-		return models.Student{}, utils.ErrorHandler(err, "error updating data")
+		return models.Student{}, dbError(err, "error updating data")
 	}
 
 	studentVal := reflect.ValueOf(&existingStudent).Elem()
@@ -241,69 +253,73 @@ func PatchStudent(id int, updates map[string]interface{}) (models.Student, error
 		}
 	}
 
-	_, err = db.Exec("UPDATE students SET first_name = ?, last_name = ?, email = ?, class = ? WHERE id = ?", existingStudent.FirstName,
+	_, err = db.ExecContext(ctx, tagQuery(ctx, "UPDATE students SET first_name = ?, last_name = ?, email = ?, class = ? WHERE id = ?"), existingStudent.FirstName,
 		existingStudent.LastName, existingStudent.Email, existingStudent.Class, existingStudent.ID)
 	if err != nil {
-		return models.Student{}, utils.ErrorHandler(err, "error updating data")
+		return models.Student{}, dbError(err, "error updating data")
 	}
 	return existingStudent, nil
 }
 
-func DeleteOneStudent(id int) error {
+func DeleteOneStudent(ctx context.Context, id int) error {
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
 	db, err := ConnectDb()
 	if err != nil {
-		return utils.ErrorHandler(err, "error deleting data")
+		return dbError(err, "error deleting data")
 	}
-	defer db.Close()
 
-	result, err := db.Exec("DELETE FROM students WHERE id = ?", id)
+	result, err := db.ExecContext(ctx, tagQuery(ctx, "DELETE FROM students WHERE id = ?"), id)
 	if err != nil {
-		return utils.ErrorHandler(err, "error deleting data")
+		return dbError(err, "error deleting data")
 	}
 
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		return utils.ErrorHandler(err, "teacher not found")
+		return dbError(err, "teacher not found")
 	}
 
 	if rowsAffected == 0 {
-		return utils.ErrorHandler(err, "error deleting data")
+		return dbError(err, "error deleting data")
 	}
 	return nil
 }
 
-func DeleteStudents(ids []int) ([]int, error) {
+func DeleteStudents(ctx context.Context, ids []int) ([]int, error) {
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
 	db, err := ConnectDb()
 	if err != nil {
-		return nil, utils.ErrorHandler(err, "error deleting data")
+		return nil, dbError(err, "error deleting data")
 	}
-	defer db.Close()
 
-	tx, err := db.Begin()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, utils.ErrorHandler(err, "error deleting data")
+		return nil, dbError(err, "error deleting data")
 	}
 
-	stmt, err := tx.Prepare("DELETE FROM students WHERE id = ?")
+	stmt, err := tx.PrepareContext(ctx, tagQuery(ctx, "DELETE FROM students WHERE id = ?"))
 	if err != nil {
-		log.Println(err)
+		loggerFor(ctx).Error("prepare failed", "err", err)
 		tx.Rollback()
-		return nil, utils.ErrorHandler(err, "error deleting data")
+		return nil, dbError(err, "error deleting data")
 	}
 	defer stmt.Close()
 	deletedIds := []int{}
 
 	for _, id := range ids {
-		result, err := stmt.Exec(id)
+		result, err := stmt.ExecContext(ctx, id)
 		if err != nil {
 			tx.Rollback()
-			return nil, utils.ErrorHandler(err, "error deleting data")
+			return nil, dbError(err, "error deleting data")
 		}
 
 		rowsAffected, err := result.RowsAffected()
 		if err != nil {
 			tx.Rollback()
-			return nil, utils.ErrorHandler(err, "error deleting data")
+			return nil, dbError(err, "error deleting data")
 		}
 
 		if rowsAffected > 0 {
@@ -312,17 +328,17 @@ func DeleteStudents(ids []int) ([]int, error) {
 
 		if rowsAffected < 1 {
 			tx.Rollback()
-			return nil, utils.ErrorHandler(err, fmt.Sprintf("ID %d not found", id))
+			return nil, dbError(err, fmt.Sprintf("ID %d not found", id))
 		}
 	}
 
 	err = tx.Commit()
 	if err != nil {
-		return nil, utils.ErrorHandler(err, "error deleting data")
+		return nil, dbError(err, "error deleting data")
 	}
 
 	if len(deletedIds) < 1 {
-		return nil, utils.ErrorHandler(err, "IDs do not exist")
+		return nil, dbError(err, "IDs do not exist")
 	}
 	return deletedIds, nil
 }
\ No newline at end of file
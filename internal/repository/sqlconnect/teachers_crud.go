@@ -1,9 +1,9 @@
 package sqlconnect
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
 	"net/http"
 	"reflect"
 	"restapi/internal/models"
@@ -11,31 +11,35 @@ import (
 	"strconv"
 )
 
-func GetTeacherByID(id int) (models.Teacher, error) {
+func GetTeacherByID(ctx context.Context, id int) (models.Teacher, error) {
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+
 	db, err := ConnectDb()
 
 	if err != nil {
-		return models.Teacher{}, utils.ErrorHandler(err, "error retrieving data")
+		return models.Teacher{}, dbError(err, "error retrieving data")
 	}
-	defer db.Close()
 
 	var teacher models.Teacher
-	err = db.QueryRow("SELECT * FROM teachers WHERE id = ?", id).Scan(&teacher.ID, &teacher.FirstName, &teacher.LastName, &teacher.Email, &teacher.Class, &teacher.Subject)
+	err = db.QueryRowContext(ctx, tagQuery(ctx, "SELECT * FROM teachers WHERE id = ?"), id).Scan(&teacher.ID, &teacher.FirstName, &teacher.LastName, &teacher.Email, &teacher.Class, &teacher.Subject)
 	if err == sql.ErrNoRows {
-		return models.Teacher{}, utils.ErrorHandler(err, "error retrieving data")
+		return models.Teacher{}, dbError(err, "error retrieving data")
 	} else if err != nil {
-		return models.Teacher{}, utils.ErrorHandler(err, "error retrieving data")
+		return models.Teacher{}, dbError(err, "error retrieving data")
 	}
 	return teacher, nil
 }
 
 func GetTeachersDBHandler(teachers []models.Teacher, r *http.Request) ([]models.Teacher, error) {
+	ctx, cancel := withReadTimeout(r.Context())
+	defer cancel()
+
 	db, err := ConnectDb()
 
 	if err != nil {
-		return nil, utils.ErrorHandler(err, "error retrieving data")
+		return nil, dbError(err, "error retrieving data")
 	}
-	defer db.Close()
 
 	query := "SELECT * FROM teachers WHERE 1=1";
 	var args []interface{}
@@ -43,10 +47,10 @@ func GetTeachersDBHandler(teachers []models.Teacher, r *http.Request) ([]models.
 
 	query = utils.AddSorting(r, query)
 
-	rows, err := db.Query(query, args...)
+	rows, err := db.QueryContext(ctx, tagQuery(ctx, query), args...)
 	if err != nil {
-		fmt.Println(err)
-		return nil, utils.ErrorHandler(err, "error retrieving data")
+		loggerFor(ctx).Error("query failed", "err", err)
+		return nil, dbError(err, "error retrieving data")
 	}
 	defer rows.Close()
 
@@ -55,39 +59,41 @@ func GetTeachersDBHandler(teachers []models.Teacher, r *http.Request) ([]models.
 		var teacher models.Teacher
 		err := rows.Scan(&teacher.ID, &teacher.FirstName, &teacher.LastName, &teacher.Email, &teacher.Class, &teacher.Subject)
 		if err != nil {
-			return nil, utils.ErrorHandler(err, "error retrieving data")
+			return nil, dbError(err, "error retrieving data")
 		}
 		teachers = append(teachers, teacher)
 	}
 	return teachers, nil
 }
 
-func AddTeachersDBHandler(newTeachers []models.Teacher) ([]models.Teacher, error) {
+func AddTeachersDBHandler(ctx context.Context, newTeachers []models.Teacher) ([]models.Teacher, error) {
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
 	db, err := ConnectDb()
 
 	if err != nil {
-		return nil, utils.ErrorHandler(err, "error adding data")
+		return nil, dbError(err, "error adding data")
 	}
-	defer db.Close()
 
-	// stmt, err := db.Prepare("INSERT INTO teachers (first_name, last_name, email, class, subject) VALUES (?,?,?,?,?)")
-	stmt, err := db.Prepare(utils.GenerateInsertQuery("teachers", models.Teacher{}))
+	// stmt, err := db.PrepareContext(ctx, tagQuery(ctx, "INSERT INTO teachers (first_name, last_name, email, class, subject) VALUES (?,?,?,?,?)"))
+	stmt, err := db.PrepareContext(ctx, tagQuery(ctx, utils.GenerateInsertQuery("teachers", models.Teacher{})))
 	if err != nil {
-		return nil, utils.ErrorHandler(err, "error adding data")
+		return nil, dbError(err, "error adding data")
 	}
 	defer stmt.Close()
 
 	addedTeachers := make([]models.Teacher, len(newTeachers))
 	for i, newTeacher := range newTeachers {
-		// res, err := stmt.Exec(newTeacher.FirstName, newTeacher.LastName, newTeacher.Email, newTeacher.Class, newTeacher.Subject)
+		// res, err := stmt.ExecContext(ctx, newTeacher.FirstName, newTeacher.LastName, newTeacher.Email, newTeacher.Class, newTeacher.Subject)
 		values := utils.GetStructValues(newTeacher)
-		res, err := stmt.Exec(values...)
+		res, err := stmt.ExecContext(ctx, values...)
 		if err != nil {
-			return nil, utils.ErrorHandler(err, "error adding data")
+			return nil, dbError(err, "error adding data")
 		}
 		lastId, err := res.LastInsertId()
 		if err != nil {
-			return nil, utils.ErrorHandler(err, "error adding data")
+			return nil, dbError(err, "error adding data")
 		}
 		newTeacher.ID = int(lastId)
 		addedTeachers[i] = newTeacher
@@ -95,64 +101,68 @@ func AddTeachersDBHandler(newTeachers []models.Teacher) ([]models.Teacher, error
 	return addedTeachers, nil
 }
 
-func UpdateTeacher(id int, updatedTeacher models.Teacher) (models.Teacher, error) {
+func UpdateTeacher(ctx context.Context, id int, updatedTeacher models.Teacher) (models.Teacher, error) {
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
 	db, err := ConnectDb()
 	if err != nil {
-		return models.Teacher{}, utils.ErrorHandler(err, "error updating data")
+		return models.Teacher{}, dbError(err, "error updating data")
 	}
-	defer db.Close()
 
 	var existingTeacher models.Teacher
-	err = db.QueryRow("SELECT * FROM teachers WHERE id = ?", id).Scan(&existingTeacher.ID, &existingTeacher.FirstName, &existingTeacher.LastName, &existingTeacher.Email, &existingTeacher.Class, &existingTeacher.Subject)
+	err = db.QueryRowContext(ctx, tagQuery(ctx, "SELECT * FROM teachers WHERE id = ?"), id).Scan(&existingTeacher.ID, &existingTeacher.FirstName, &existingTeacher.LastName, &existingTeacher.Email, &existingTeacher.Class, &existingTeacher.Subject)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return models.Teacher{}, utils.ErrorHandler(err, "error updating data")
+			return models.Teacher{}, dbError(err, "error updating data")
 		}
-		return models.Teacher{}, utils.ErrorHandler(err, "error updating data")
+		return models.Teacher{}, dbError(err, "error updating data")
 	}
 
 	updatedTeacher.ID = existingTeacher.ID
-	_, err = db.Exec("UPDATE teachers SET first_name = ?, last_name = ?, email = ?, class = ?, subject = ? WHERE id = ?", updatedTeacher.FirstName,
+	_, err = db.ExecContext(ctx, tagQuery(ctx, "UPDATE teachers SET first_name = ?, last_name = ?, email = ?, class = ?, subject = ? WHERE id = ?"), updatedTeacher.FirstName,
 		updatedTeacher.LastName, updatedTeacher.Email, updatedTeacher.Class, updatedTeacher.Subject, updatedTeacher.ID)
 	if err != nil {
-		return models.Teacher{}, utils.ErrorHandler(err, "error updating data")
+		return models.Teacher{}, dbError(err, "error updating data")
 	}
 	return updatedTeacher, nil
 }
 
-func PatchTeachers(updates []map[string]interface{}) error {
+func PatchTeachers(ctx context.Context, updates []map[string]interface{}) error {
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
 	db, err := ConnectDb()
 	if err != nil {
-		return utils.ErrorHandler(err, "error updating data")
+		return dbError(err, "error updating data")
 	}
-	defer db.Close()
 
-	tx, err := db.Begin()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		return utils.ErrorHandler(err, "error updating data")
+		return dbError(err, "error updating data")
 	}
 
 	for _, update := range updates {
 		idStr, ok := update["id"].(string)
 		if !ok {
 			tx.Rollback()
-			return utils.ErrorHandler(err, "error updating data")
+			return dbError(err, "error updating data")
 		}
 
 		id, err := strconv.Atoi(idStr)
 		if err != nil {
 			tx.Rollback()
-			return utils.ErrorHandler(err, "invalid id")
+			return dbError(err, "invalid id")
 		}
 
 		var teacherFromDb models.Teacher
-		err = db.QueryRow("SELECT * FROM teachers WHERE id = ?", id).Scan(&teacherFromDb.ID, &teacherFromDb.FirstName, &teacherFromDb.LastName, &teacherFromDb.Email, &teacherFromDb.Class, &teacherFromDb.Subject)
+		err = db.QueryRowContext(ctx, tagQuery(ctx, "SELECT * FROM teachers WHERE id = ?"), id).Scan(&teacherFromDb.ID, &teacherFromDb.FirstName, &teacherFromDb.LastName, &teacherFromDb.Email, &teacherFromDb.Class, &teacherFromDb.Subject)
 		if err != nil {
 			tx.Rollback()
 			if err == sql.ErrNoRows {
-				return utils.ErrorHandler(err, "Teacher not found")
+				return dbError(err, "Teacher not found")
 			}
-			return utils.ErrorHandler(err, "error updating data")
+			return dbError(err, "error updating data")
 		}
 
 		teacherVal := reflect.ValueOf(&teacherFromDb).Elem()
@@ -172,8 +182,8 @@ func PatchTeachers(updates []map[string]interface{}) error {
 							fieldVal.Set(val.Convert(fieldVal.Type()))
 						} else {
 							tx.Rollback()
-							log.Printf("cannot convert %v to %v", val.Type(), fieldVal.Type())
-							return utils.ErrorHandler(err, "error updating data")
+							loggerFor(ctx).Warn("cannot convert patch value", "field", k, "from", val.Type().String(), "to", fieldVal.Type().String())
+							return dbError(err, "error updating data")
 						}
 					}
 					break
@@ -181,37 +191,39 @@ func PatchTeachers(updates []map[string]interface{}) error {
 			}
 		}
 
-		_, err = tx.Exec("UPDATE teachers SET first_name = ?, last_name = ?, email = ?, class = ?, subject = ? WHERE id = ?", teacherFromDb.FirstName, teacherFromDb.LastName, teacherFromDb.Email, teacherFromDb.Class, teacherFromDb.Subject, teacherFromDb.ID)
+		_, err = tx.ExecContext(ctx, tagQuery(ctx, "UPDATE teachers SET first_name = ?, last_name = ?, email = ?, class = ?, subject = ? WHERE id = ?"), teacherFromDb.FirstName, teacherFromDb.LastName, teacherFromDb.Email, teacherFromDb.Class, teacherFromDb.Subject, teacherFromDb.ID)
 		if err != nil {
 			tx.Rollback()
-			return utils.ErrorHandler(err, "error updating data")
+			return dbError(err, "error updating data")
 		}
 	}
 	err = tx.Commit()
 	if err != nil {
-		return utils.ErrorHandler(err, "error updating data")
+		return dbError(err, "error updating data")
 	}
 	return nil
 }
 
-func PatchTeacher(id int, updates map[string]interface{}) (models.Teacher, error) {
+func PatchTeacher(ctx context.Context, id int, updates map[string]interface{}) (models.Teacher, error) {
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
 	db, err := ConnectDb()
 	if err != nil {
-		log.Println(err)
-		return models.Teacher{}, utils.ErrorHandler(err, "error updating data")
+		loggerFor(ctx).Error("database connection failed", "err", err)
+		return models.Teacher{}, dbError(err, "error updating data")
 	}
-	defer db.Close()
 
 	var existingTeacher models.Teacher
-	err = db.QueryRow("SELECT * FROM teachers WHERE id = ?", id).Scan(&existingTeacher.ID, &existingTeacher.FirstName, &existingTeacher.LastName, &existingTeacher.Email, &existingTeacher.Class, &existingTeacher.Subject)
+	err = db.QueryRowContext(ctx, tagQuery(ctx, "SELECT * FROM teachers WHERE id = ?"), id).Scan(&existingTeacher.ID, &existingTeacher.FirstName, &existingTeacher.LastName, &existingTeacher.Email, &existingTeacher.Class, &existingTeacher.Subject)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return models.Teacher{}, utils.ErrorHandler(err, "Teacher not found")
+			return models.Teacher{}, dbError(err, "Teacher not found")
 		}
 		return models.
 
 		// Apply updates using reflect
-		Teacher{}, utils.ErrorHandler(err, "error updating data")
+		Teacher{}, dbError(err, "error updating data")
 	}
 
 	teacherVal := reflect.ValueOf(&existingTeacher).Elem()
@@ -229,69 +241,73 @@ func PatchTeacher(id int, updates map[string]interface{}) (models.Teacher, error
 		}
 	}
 
-	_, err = db.Exec("UPDATE teachers SET first_name = ?, last_name = ?, email = ?, class = ?, subject = ? WHERE id = ?", existingTeacher.FirstName,
+	_, err = db.ExecContext(ctx, tagQuery(ctx, "UPDATE teachers SET first_name = ?, last_name = ?, email = ?, class = ?, subject = ? WHERE id = ?"), existingTeacher.FirstName,
 		existingTeacher.LastName, existingTeacher.Email, existingTeacher.Class, existingTeacher.Subject, existingTeacher.ID)
 	if err != nil {
-		return models.Teacher{}, utils.ErrorHandler(err, "error updating data")
+		return models.Teacher{}, dbError(err, "error updating data")
 	}
 	return existingTeacher, nil
 }
 
-func DeleteOneTeacher(id int) error {
+func DeleteOneTeacher(ctx context.Context, id int) error {
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
 	db, err := ConnectDb()
 	if err != nil {
-		return utils.ErrorHandler(err, "error deleting data")
+		return dbError(err, "error deleting data")
 	}
-	defer db.Close()
 
-	result, err := db.Exec("DELETE FROM teachers WHERE id = ?", id)
+	result, err := db.ExecContext(ctx, tagQuery(ctx, "DELETE FROM teachers WHERE id = ?"), id)
 	if err != nil {
-		return utils.ErrorHandler(err, "error deleting data")
+		return dbError(err, "error deleting data")
 	}
 
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		return utils.ErrorHandler(err, "teacher not found")
+		return dbError(err, "teacher not found")
 	}
 
 	if rowsAffected == 0 {
-		return utils.ErrorHandler(err, "error deleting data")
+		return dbError(err, "error deleting data")
 	}
 	return nil
 }
 
-func DeleteTeachers(ids []int) ([]int, error) {
+func DeleteTeachers(ctx context.Context, ids []int) ([]int, error) {
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
 	db, err := ConnectDb()
 	if err != nil {
-		return nil, utils.ErrorHandler(err, "error deleting data")
+		return nil, dbError(err, "error deleting data")
 	}
-	defer db.Close()
 
-	tx, err := db.Begin()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, utils.ErrorHandler(err, "error deleting data")
+		return nil, dbError(err, "error deleting data")
 	}
 
-	stmt, err := tx.Prepare("DELETE FROM teachers WHERE id = ?")
+	stmt, err := tx.PrepareContext(ctx, tagQuery(ctx, "DELETE FROM teachers WHERE id = ?"))
 	if err != nil {
-		log.Println(err)
+		loggerFor(ctx).Error("prepare failed", "err", err)
 		tx.Rollback()
-		return nil, utils.ErrorHandler(err, "error deleting data")
+		return nil, dbError(err, "error deleting data")
 	}
 	defer stmt.Close()
 	deletedIds := []int{}
 
 	for _, id := range ids {
-		result, err := stmt.Exec(id)
+		result, err := stmt.ExecContext(ctx, id)
 		if err != nil {
 			tx.Rollback()
-			return nil, utils.ErrorHandler(err, "error deleting data")
+			return nil, dbError(err, "error deleting data")
 		}
 
 		rowsAffected, err := result.RowsAffected()
 		if err != nil {
 			tx.Rollback()
-			return nil, utils.ErrorHandler(err, "error deleting data")
+			return nil, dbError(err, "error deleting data")
 		}
 
 		if rowsAffected > 0 {
@@ -300,33 +316,35 @@ func DeleteTeachers(ids []int) ([]int, error) {
 
 		if rowsAffected < 1 {
 			tx.Rollback()
-			return nil, utils.ErrorHandler(err, fmt.Sprintf("ID %d not found", id))
+			return nil, dbError(err, fmt.Sprintf("ID %d not found", id))
 		}
 	}
 
 	err = tx.Commit()
 	if err != nil {
-		return nil, utils.ErrorHandler(err, "error deleting data")
+		return nil, dbError(err, "error deleting data")
 	}
 
 	if len(deletedIds) < 1 {
-		return nil, utils.ErrorHandler(err, "IDs do not exist")
+		return nil, dbError(err, "IDs do not exist")
 	}
 	return deletedIds, nil
 }
 
-func GetStudentsByTeacherIdFomDB(teacherId string, students []models.Student) ([]models.Student, error) {
+func GetStudentsByTeacherIdFomDB(ctx context.Context, teacherId string, students []models.Student) ([]models.Student, error) {
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+
 	db, err := ConnectDb()
 	if err != nil {
-		log.Println(err)
+		loggerFor(ctx).Error("database connection failed", "err", err)
 		return nil, err
 	}
-	defer db.Close()
 
 	query := `SELECT * FROM students WHERE class = (SELECT class FROM teachers WHERE id = ?)`
-	rows, err := db.Query(query, teacherId)
+	rows, err := db.QueryContext(ctx, tagQuery(ctx, query), teacherId)
 	if err != nil {
-		return nil, utils.ErrorHandler(err, "error fetching data")
+		return nil, dbError(err, "error fetching data")
 	}
 	defer rows.Close()
 
@@ -334,31 +352,33 @@ func GetStudentsByTeacherIdFomDB(teacherId string, students []models.Student) ([
 		var student models.Student
 		err := rows.Scan(&student.ID, &student.FirstName, &student.LastName, &student.Email, &student.Class)
 		if err != nil {
-			return nil, utils.ErrorHandler(err, "error fetching data")
+			return nil, dbError(err, "error fetching data")
 		}
 		students = append(students, student)
 	}
 	err = rows.Err()
 	if err != nil {
-		return nil, utils.ErrorHandler(err, "error fetching data")
+		return nil, dbError(err, "error fetching data")
 	}
 	return students, nil
 }
 
-func GetStudentCountByTeacherIdFromDB(teacherId string) (int, error) {
+func GetStudentCountByTeacherIdFromDB(ctx context.Context, teacherId string) (int, error) {
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+
 	db, err := ConnectDb()
 	if err != nil {
-		return 0, utils.ErrorHandler(err, "error fetching data")
+		return 0, dbError(err, "error fetching data")
 	}
-	defer db.Close()
 
 	query := `SELECT COUNT(*) FROM students WHERE class = (SELECT class FROM teachers WHERE id = ?)`
 
 	var studentCount int
 
-	err = db.QueryRow(query, teacherId).Scan(&studentCount)
+	err = db.QueryRowContext(ctx, tagQuery(ctx, query), teacherId).Scan(&studentCount)
 	if err != nil {
-		return 0, utils.ErrorHandler(err, "error fetching data")
+		return 0, dbError(err, "error fetching data")
 	}
 	return studentCount, nil
 }
\ No newline at end of file
@@ -0,0 +1,49 @@
+package sqlconnect
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"restapi/pkg/utils"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+var (
+	// ErrDBTimeout is returned when an operation ran past its deadline
+	ErrDBTimeout = errors.New("database operation timed out")
+	// ErrDBUnavailable is returned when the database can't be reached or
+	// the request was abandoned before the operation finished
+	ErrDBUnavailable = errors.New("database unavailable")
+)
+
+// withReadTimeout bounds a lookup by the read timeout
+func withReadTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, options.ReadTimeout)
+}
+
+// withWriteTimeout bounds an insert, update or delete, including the
+// emails it queues, by the write timeout
+func withWriteTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, options.WriteTimeout)
+}
+
+// dbError is utils.ErrorHandler for database errors, except that timeouts
+// and connection failures come back as ErrDBTimeout and ErrDBUnavailable so
+// handlers can answer them with 504 and 503
+func dbError(err error, message string) error {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		utils.ErrorHandler(err, message)
+		return ErrDBTimeout
+	case errors.Is(err, ErrDBUnavailable):
+		return err
+	case errors.Is(err, context.Canceled), errors.Is(err, driver.ErrBadConn),
+		errors.Is(err, mysql.ErrInvalidConn), errors.As(err, &netErr):
+		utils.ErrorHandler(err, message)
+		return ErrDBUnavailable
+	}
+	return utils.ErrorHandler(err, message)
+}
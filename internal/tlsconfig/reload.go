@@ -0,0 +1,147 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// CertReloader serves a key pair and swaps it in when the files change on
+// disk, so a rotated certificate is picked up without a restart. Files are
+// polled since a rotation usually replaces them rather than writing them.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+	logger   *slog.Logger
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	version fileVersion
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// fileVersion tells whether either file of the pair was replaced
+type fileVersion struct {
+	certModTime time.Time
+	certSize    int64
+	keyModTime  time.Time
+	keySize     int64
+}
+
+// NewCertReloader loads the key pair, failing if it can't be used
+func NewCertReloader(certFile, keyFile string, interval time.Duration, logger *slog.Logger) (*CertReloader, error) {
+	r := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		interval: interval,
+		logger:   logger,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	version, err := r.stat()
+	if err != nil {
+		return nil, err
+	}
+	err = r.load(version)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+func (r *CertReloader) Start() {
+	go r.run()
+}
+
+func (r *CertReloader) Stop() {
+	close(r.stop)
+	<-r.done
+}
+
+func (r *CertReloader) run() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			r.check()
+		}
+	}
+}
+
+func (r *CertReloader) check() {
+	version, err := r.stat()
+	if err != nil {
+		r.logger.Error("checking the TLS key pair failed, still serving the previous certificate", "err", err)
+		return
+	}
+
+	r.mu.RLock()
+	changed := version != r.version
+	r.mu.RUnlock()
+	if !changed {
+		return
+	}
+
+	// the cert and the key are rarely replaced at the same instant, a
+	// mismatched pair is retried at the next tick
+	err = r.load(version)
+	if err != nil {
+		r.logger.Error("reloading the TLS key pair failed, still serving the previous certificate", "err", err)
+	}
+}
+
+func (r *CertReloader) stat() (fileVersion, error) {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return fileVersion{}, err
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return fileVersion{}, err
+	}
+	return fileVersion{
+		certModTime: certInfo.ModTime(),
+		certSize:    certInfo.Size(),
+		keyModTime:  keyInfo.ModTime(),
+		keySize:     keyInfo.Size(),
+	}, nil
+}
+
+func (r *CertReloader) load(version fileVersion) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+	cert.Leaf = leaf
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.version = version
+	r.mu.Unlock()
+
+	r.logger.Info("loaded TLS certificate", "subject", leaf.Subject.String(), "not_after", leaf.NotAfter)
+	return nil
+}
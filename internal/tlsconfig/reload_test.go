@@ -0,0 +1,174 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type keyPair struct {
+	cert []byte
+	key  []byte
+}
+
+// newKeyPair makes a self-signed PEM certificate and key for commonName
+func newKeyPair(t *testing.T, commonName string) keyPair {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{commonName},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyPair{
+		cert: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		key:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+// replaceFile writes content to path with a new modification time, the
+// way a rotation would
+func replaceFile(t *testing.T, path string, content []byte, modTime time.Time) {
+	t.Helper()
+	err := os.WriteFile(path, content, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chtimes(path, modTime, modTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func servedName(t *testing.T, r *CertReloader) string {
+	t.Helper()
+	cert, err := r.GetCertificate(nil)
+	if err != nil || cert == nil {
+		t.Fatalf("GetCertificate = %v, %v", cert, err)
+	}
+	return cert.Leaf.Subject.CommonName
+}
+
+func TestCertReloaderCheck(t *testing.T) {
+	old := newKeyPair(t, "old.example.com")
+	rotated := newKeyPair(t, "new.example.com")
+	other := newKeyPair(t, "other.example.com")
+
+	tests := []struct {
+		name string
+		// the new content of each file, nil leaves it alone and empty
+		// removes it
+		cert []byte
+		key  []byte
+		want string
+	}{
+		{name: "unchanged", want: "old.example.com"},
+		{name: "rotated pair", cert: rotated.cert, key: rotated.key, want: "new.example.com"},
+		{name: "cert replaced before its key", cert: rotated.cert, want: "old.example.com"},
+		{name: "key that doesn't match", cert: rotated.cert, key: other.key, want: "old.example.com"},
+		{name: "garbage cert", cert: []byte("not a certificate"), key: rotated.key, want: "old.example.com"},
+		{name: "missing key", key: []byte{}, want: "old.example.com"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			certFile := filepath.Join(dir, "cert.pem")
+			keyFile := filepath.Join(dir, "key.pem")
+			loaded := time.Now().Add(-time.Minute)
+			replaceFile(t, certFile, old.cert, loaded)
+			replaceFile(t, keyFile, old.key, loaded)
+
+			r, err := NewCertReloader(certFile, keyFile, time.Hour, slog.New(slog.NewTextHandler(io.Discard, nil)))
+			if err != nil {
+				t.Fatalf("NewCertReloader: %v", err)
+			}
+
+			for path, content := range map[string][]byte{certFile: test.cert, keyFile: test.key} {
+				switch {
+				case content == nil:
+				case len(content) == 0:
+					os.Remove(path)
+				default:
+					replaceFile(t, path, content, time.Now())
+				}
+			}
+			r.check()
+
+			if got := servedName(t, r); got != test.want {
+				t.Errorf("serving %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCertReloaderRetriesAMismatchedPair(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	old := newKeyPair(t, "old.example.com")
+	rotated := newKeyPair(t, "new.example.com")
+	replaceFile(t, certFile, old.cert, time.Now().Add(-time.Minute))
+	replaceFile(t, keyFile, old.key, time.Now().Add(-time.Minute))
+
+	r, err := NewCertReloader(certFile, keyFile, 10*time.Millisecond, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("NewCertReloader: %v", err)
+	}
+	r.Start()
+	defer r.Stop()
+
+	replaceFile(t, certFile, rotated.cert, time.Now())
+	time.Sleep(50 * time.Millisecond)
+	if got := servedName(t, r); got != "old.example.com" {
+		t.Fatalf("serving %s with a mismatched pair, want old.example.com", got)
+	}
+
+	replaceFile(t, keyFile, rotated.key, time.Now())
+	deadline := time.Now().Add(2 * time.Second)
+	for servedName(t, r) != "new.example.com" {
+		if time.Now().After(deadline) {
+			t.Fatal("the rotated key pair was never loaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNewCertReloaderRejectsABrokenPair(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	replaceFile(t, certFile, newKeyPair(t, "a.example.com").cert, time.Now())
+	replaceFile(t, keyFile, newKeyPair(t, "b.example.com").key, time.Now())
+
+	_, err := NewCertReloader(certFile, keyFile, time.Hour, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err == nil {
+		t.Error("NewCertReloader accepted a certificate with another key")
+	}
+	_, err = NewCertReloader(filepath.Join(dir, "missing.pem"), keyFile, time.Hour, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err == nil {
+		t.Error("NewCertReloader accepted a missing certificate")
+	}
+}
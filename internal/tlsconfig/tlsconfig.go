@@ -0,0 +1,114 @@
+// Package tlsconfig builds the server TLS settings: protocol versions, cipher
+// suites, optional client certificate verification and a key pair that is
+// reloaded from disk when it is rotated.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Options configures ServerConfig. MinVersion and MaxVersion are "1.2" or
+// "1.3", an empty MaxVersion allows the newest version Go supports.
+// CipherSuites only apply up to TLS 1.2, the TLS 1.3 suites are not
+// configurable.
+type Options struct {
+	MinVersion   string
+	MaxVersion   string
+	CipherSuites []string
+	// ClientAuth is none, optional (verify a certificate when one is sent)
+	// or require
+	ClientAuth   string
+	ClientCAFile string
+}
+
+var versions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ServerConfig returns the TLS config serving the certificates of certs
+func ServerConfig(options Options, certs *CertReloader) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certs.GetCertificate,
+	}
+
+	if options.MinVersion != "" {
+		version, ok := versions[options.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unsupported TLS version %q", options.MinVersion)
+		}
+		config.MinVersion = version
+	}
+	if options.MaxVersion != "" {
+		version, ok := versions[options.MaxVersion]
+		if !ok {
+			return nil, fmt.Errorf("unsupported TLS version %q", options.MaxVersion)
+		}
+		if version < config.MinVersion {
+			return nil, errors.New("the maximum TLS version is below the minimum")
+		}
+		config.MaxVersion = version
+	}
+
+	suites, err := cipherSuites(options.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	config.CipherSuites = suites
+
+	switch options.ClientAuth {
+	case "", "none":
+		config.ClientAuth = tls.NoClientCert
+		return config, nil
+	case "optional":
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	case "require":
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("unknown client auth mode %q", options.ClientAuth)
+	}
+
+	pem, err := os.ReadFile(options.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading the client CA: %w", err)
+	}
+	config.ClientCAs = x509.NewCertPool()
+	if !config.ClientCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in %s", options.ClientCAFile)
+	}
+	return config, nil
+}
+
+// cipherSuites maps the IANA names, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+// to their ids. Suites Go considers insecure are refused.
+func cipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+
+	var ids []uint16
+	var unknown []string
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			unknown = append(unknown, name)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("unknown or insecure cipher suites: %s", strings.Join(unknown, ", "))
+	}
+	return ids, nil
+}
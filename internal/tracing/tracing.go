@@ -0,0 +1,111 @@
+// Package tracing sets up OpenTelemetry: W3C trace context propagation and a
+// tracer provider exporting to stdout, a file or an OTLP/HTTP collector.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "restapi"
+
+// Options configures Setup. Exporter is one of "none", "stdout", "file"
+// (spans appended as JSON lines to File) or "otlp" (OTLP/HTTP, sent to the
+// collector at OTLPEndpoint).
+type Options struct {
+	Exporter     string
+	File         string
+	OTLPEndpoint string
+	OTLPHeaders  map[string]string
+	ServiceName  string
+	SampleRatio  float64
+}
+
+// ParseHeaders reads OTLP headers written as key1=value1,key2=value2
+func ParseHeaders(value string) map[string]string {
+	headers := make(map[string]string)
+	for _, header := range strings.Split(value, ",") {
+		key, value, found := strings.Cut(header, "=")
+		if found {
+			headers[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	return headers
+}
+
+// Setup installs the global tracer provider and propagator. The returned
+// function flushes the spans still buffered, call it on shutdown.
+func Setup(options Options) (func(context.Context) error, error) {
+	// incoming traceparent headers are honoured even when we don't export,
+	// so ids still show up in the logs
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var closer io.Closer
+	switch options.Exporter {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "stdout":
+		var err error
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		if err != nil {
+			return nil, err
+		}
+	case "file":
+		file, err := os.OpenFile(options.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(file))
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		closer = file
+	case "otlp":
+		// the endpoint is the collector's base URL, as in the OTLP spec
+		var err error
+		exporter, err = otlptracehttp.New(context.Background(),
+			otlptracehttp.WithEndpointURL(strings.TrimSuffix(options.OTLPEndpoint, "/")+"/v1/traces"),
+			otlptracehttp.WithHeaders(options.OTLPHeaders),
+		)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown OTEL_TRACES_EXPORTER %q", options.Exporter)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", options.ServiceName))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(options.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closer != nil {
+			err = errors.Join(err, closer.Close())
+		}
+		return err
+	}, nil
+}
+
+// Tracer is the tracer the application spans are started from
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
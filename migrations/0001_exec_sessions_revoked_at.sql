@@ -0,0 +1,4 @@
+-- tokens issued before this moment are refused, set when an exec is
+-- deactivated so reactivating doesn't bring old sessions back
+ALTER TABLE execs
+	ADD COLUMN sessions_revoked_at VARCHAR(255) NULL;
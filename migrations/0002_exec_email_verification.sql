@@ -0,0 +1,7 @@
+-- email_verified_at is set once an exec proves it reads its address: through
+-- the invitation link, a password reset link or a verification link
+ALTER TABLE execs
+	ADD COLUMN pending_email VARCHAR(255) NULL,
+	ADD COLUMN email_verification_token VARCHAR(255) NULL,
+	ADD COLUMN email_verification_expires VARCHAR(255) NULL,
+	ADD COLUMN email_verified_at VARCHAR(255) NULL;
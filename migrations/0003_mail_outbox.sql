@@ -0,0 +1,19 @@
+-- the outbox keeps the template and its data instead of the rendered
+-- message, links are only built when the worker sends the email so no row
+-- carries a usable reset, invitation or verification token
+CREATE TABLE IF NOT EXISTS mail_outbox (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	recipient VARCHAR(255) NOT NULL,
+	template VARCHAR(64) NOT NULL DEFAULT '',
+	locale VARCHAR(35) NOT NULL DEFAULT '',
+	template_data TEXT NULL,
+	token_purpose VARCHAR(32) NULL,
+	exec_id INT NULL,
+	status VARCHAR(16) NOT NULL DEFAULT 'pending',
+	attempts INT NOT NULL DEFAULT 0,
+	next_attempt_at VARCHAR(255) NOT NULL,
+	last_error TEXT NULL,
+	created_at VARCHAR(255) NOT NULL,
+	sent_at VARCHAR(255) NULL,
+	INDEX idx_mail_outbox_due (status, next_attempt_at)
+);
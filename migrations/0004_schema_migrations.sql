@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version VARCHAR(255) PRIMARY KEY,
+	applied_at VARCHAR(255) NOT NULL
+);
+
+-- every migration from here on ends by recording itself, the earlier ones
+-- are recorded here
+INSERT IGNORE INTO schema_migrations (version, applied_at) VALUES
+	('0001_exec_sessions_revoked_at', DATE_FORMAT(UTC_TIMESTAMP(), '%Y-%m-%dT%H:%i:%sZ')),
+	('0002_exec_email_verification', DATE_FORMAT(UTC_TIMESTAMP(), '%Y-%m-%dT%H:%i:%sZ')),
+	('0003_mail_outbox', DATE_FORMAT(UTC_TIMESTAMP(), '%Y-%m-%dT%H:%i:%sZ')),
+	('0004_schema_migrations', DATE_FORMAT(UTC_TIMESTAMP(), '%Y-%m-%dT%H:%i:%sZ'));
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	prefix VARCHAR(32) NOT NULL,
+	key_hash CHAR(64) NOT NULL,
+	scopes VARCHAR(1024) NOT NULL,
+	created_by VARCHAR(255) NOT NULL,
+	created_at VARCHAR(255) NOT NULL,
+	expires_at VARCHAR(255) NULL,
+	last_used_at VARCHAR(255) NULL,
+	revoked_at VARCHAR(255) NULL,
+	rotated_from INT NULL,
+	UNIQUE INDEX idx_api_keys_prefix (prefix)
+);
+
+INSERT IGNORE INTO schema_migrations (version, applied_at) VALUES
+	('0005_api_keys', DATE_FORMAT(UTC_TIMESTAMP(), '%Y-%m-%dT%H:%i:%sZ'));
@@ -0,0 +1,27 @@
+package migrations
+
+import (
+	"embed"
+	"io/fs"
+	"sort"
+	"strings"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+// Versions lists the migrations shipped with this build, in the order they
+// are applied. A version is the file name without the .sql extension, which
+// is what each migration records in schema_migrations.
+func Versions() ([]string, error) {
+	names, err := fs.Glob(files, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]string, 0, len(names))
+	for _, name := range names {
+		versions = append(versions, strings.TrimSuffix(name, ".sql"))
+	}
+	sort.Strings(versions)
+	return versions, nil
+}